@@ -6,13 +6,34 @@ import (
 	"reflect"
 	"runtime"
 	"sync"
+
+	"github.com/pkg/errors"
 )
 
+var ErrNotPersistent = errors.New("data type not persistent")
+
 type PersistentData interface {
 	Serialize() []byte
 	Deserialize([]byte) error
 }
 
+// CheckedData is PersistentData which may fail to serialize,
+// such as wrapper of data which is not PersistentData
+type CheckedData interface {
+	PersistentData
+
+	SerializeChecked() ([]byte, error)
+}
+
+// Serialize data with SerializeChecked if data is CheckedData
+func Serialize(data PersistentData) ([]byte, error) {
+	if checked, ok := data.(CheckedData); ok {
+		return checked.SerializeChecked()
+	}
+
+	return data.Serialize(), nil
+}
+
 var (
 	typeList  []sync.Pool
 	typeCache map[reflect.Type]TID
@@ -46,6 +67,24 @@ func RegisterType(t PersistentData, newFn func() PersistentData) (tid TID) {
 	return
 }
 
+// LookupType get TID for registered PersistentData type.
+func LookupType(v PersistentData) (TID, error) {
+	typ := reflect.TypeOf(v)
+	if typ == nil {
+		return -1, fmt.Errorf("nil data has no type")
+	}
+
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	if tid, exist := typeCache[typ]; exist {
+		return tid, nil
+	}
+
+	return -1, fmt.Errorf("type %s not registered", typ.String())
+}
+
 // NewTypeValue create PersistentData type.
 // TID is unique identity for type creation
 // from persistent storage
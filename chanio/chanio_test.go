@@ -2,12 +2,16 @@ package chanio_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/frozenpine/msgqueue/chanio"
 )
@@ -55,7 +59,7 @@ func (v *Varaint) Deserialize(data []byte) error {
 }
 
 func TestFileStore(t *testing.T) {
-	flowFile := "flow.dat"
+	flowFile := filepath.Join(t.TempDir(), "flow.dat")
 
 	store := chanio.NewFileStore(flowFile)
 
@@ -176,6 +180,741 @@ func TestDecode(t *testing.T) {
 	}
 }
 
+func TestFileStoreRecovery(t *testing.T) {
+	tid := chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	dir := t.TempDir()
+	flowFile := filepath.Join(dir, "origin.dat")
+
+	store := chanio.NewFileStore(flowFile)
+	if err := store.Open(os.O_WRONLY | os.O_CREATE | os.O_TRUNC); err != nil {
+		t.Fatal("store open failed:", err)
+	}
+
+	// record end offsets, first is file header
+	bounds := []int64{store.Size()}
+	for idx := 0; idx < 3; idx++ {
+		if err := store.Write(tid, &Int{idx}); err != nil {
+			t.Fatal(err)
+		}
+		bounds = append(bounds, store.Size())
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	origin, err := os.ReadFile(flowFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if int64(len(origin)) != bounds[len(bounds)-1] {
+		t.Fatal("file size mismatch:", len(origin), bounds)
+	}
+
+	// valid records before offset
+	recordsBefore := func(offset int) int {
+		count := 0
+		for _, end := range bounds[1:] {
+			if end <= int64(offset) {
+				count++
+			}
+		}
+		return count
+	}
+
+	check := func(name string, data []byte, expect int) {
+		path := filepath.Join(dir, "check.dat")
+		if err := os.WriteFile(path, data, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		store := chanio.NewFileStore(path)
+		if err := store.Open(os.O_RDWR | os.O_APPEND); err != nil {
+			t.Fatalf("%s: open failed: %v", name, err)
+		}
+
+		if store.Size() != bounds[expect] {
+			t.Fatalf("%s: recovered size mismatch: %d, expect %d", name, store.Size(), bounds[expect])
+		}
+
+		readAll := func(count int) {
+			for idx := 0; idx < count; idx++ {
+				v, err := store.Read()
+				if err != nil {
+					t.Fatalf("%s: read record %d failed: %v", name, idx, err)
+				}
+
+				value := v.(*Int).int
+				if (idx < expect && value != idx) || (idx == expect && value != 100) {
+					t.Fatalf("%s: record %d mismatch: %d", name, idx, value)
+				}
+			}
+
+			if _, err := store.Read(); !errors.Is(err, io.EOF) {
+				t.Fatalf("%s: read after end should be EOF: %v", name, err)
+			}
+		}
+
+		readAll(expect)
+
+		if err := store.Write(tid, &Int{100}); err != nil {
+			t.Fatalf("%s: write after recovery failed: %v", name, err)
+		}
+
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Open(os.O_RDONLY); err != nil {
+			t.Fatalf("%s: reopen failed: %v", name, err)
+		}
+
+		readAll(expect + 1)
+
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for offset := 0; offset < len(origin); offset++ {
+		check(
+			"truncate at "+strconv.Itoa(offset),
+			origin[:offset], recordsBefore(offset),
+		)
+
+		corrupted := bytes.Clone(origin)
+		corrupted[offset] ^= 0xff
+		name := "corrupt at " + strconv.Itoa(offset)
+
+		if offset < 8 {
+			store := chanio.NewFileStore(filepath.Join(dir, "header.dat"))
+			os.WriteFile(filepath.Join(dir, "header.dat"), corrupted, os.ModePerm)
+
+			err := store.Open(os.O_RDWR | os.O_APPEND)
+			if offset <= 4 && !errors.Is(err, chanio.ErrInvalidHeader) {
+				t.Fatalf("%s: invalid header not checked: %v", name, err)
+			}
+			if err == nil {
+				store.Close()
+			}
+
+			continue
+		}
+
+		check(name, corrupted, recordsBefore(offset))
+	}
+}
+
+func TestSegmentLog(t *testing.T) {
+	tid := chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	dir := t.TempDir()
+
+	log := chanio.NewSegmentLog(dir, chanio.RollPolicy{MaxRecords: 3})
+	if err := log.Open(os.O_RDWR | os.O_CREATE | os.O_APPEND); err != nil {
+		t.Fatal("log open failed:", err)
+	}
+
+	for idx := 0; idx < 7; idx++ {
+		if err := log.Write(tid, &Int{idx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// explicit roll, empty active segment will not be rolled again
+	if err := log.Roll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Roll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Write(tid, &Int{7}); err != nil {
+		t.Fatal(err)
+	}
+
+	segments := log.Segments()
+	bases := []uint64{0, 3, 6, 7}
+	if len(segments) != len(bases) {
+		t.Fatalf("segment count mismatch: %+v", segments)
+	}
+	for idx, seg := range segments {
+		if seg.Base != bases[idx] {
+			t.Fatalf("segment base mismatch: %+v", seg)
+		}
+	}
+
+	if err := log.DeleteSegment(7); !errors.Is(err, chanio.ErrSegmentActive) {
+		t.Fatal("active segment deleted:", err)
+	}
+	if err := log.DeleteSegment(0); err != nil {
+		t.Fatal(err)
+	}
+	archived, err := log.ArchiveSegment(3, filepath.Join(dir, "archive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(archived); err != nil {
+		t.Fatal("archived segment missing:", err)
+	}
+
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	log = chanio.NewSegmentLog(dir, chanio.RollPolicy{})
+	if err := log.Open(os.O_RDONLY); err != nil {
+		t.Fatal("log reopen failed:", err)
+	}
+	defer log.Close()
+
+	if start, end := log.StartSequence(), log.EndSequence(); start != 6 || end != 8 {
+		t.Fatalf("sequence range mismatch: %d ~ %d", start, end)
+	}
+
+	for expect := 6; ; expect++ {
+		seq, v, err := log.ReadNext()
+		if errors.Is(err, io.EOF) {
+			if expect != 8 {
+				t.Fatal("records missing after seq:", expect)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if seq != uint64(expect) || v.(*Int).int != expect {
+			t.Fatalf("record mismatch at seq[%d]: %v", seq, v)
+		}
+	}
+}
+
+func TestSegmentRollFailed(t *testing.T) {
+	tid := chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	dir := t.TempDir()
+
+	log := chanio.NewSegmentLog(dir, chanio.RollPolicy{MaxRecords: 2})
+	if err := log.Open(os.O_RDWR | os.O_CREATE | os.O_APPEND); err != nil {
+		t.Fatal("log open failed:", err)
+	}
+	defer log.Close()
+
+	for idx := 0; idx < 2; idx++ {
+		if err := log.Write(tid, &Int{idx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// manifest can not be saved while its tmp file is a dir
+	blocker := filepath.Join(dir, "segments.manifest.tmp")
+	if err := os.Mkdir(blocker, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := log.Write(tid, &Int{2}); err == nil {
+		t.Fatal("write should fail if rolling failed")
+	}
+
+	if segments := log.Segments(); len(segments) != 1 || segments[0].Records != 2 {
+		t.Fatalf("failed rolling changed segments: %+v", segments)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(files) != 1 {
+		t.Fatal("failed rolling left segment file:", files)
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := log.Write(tid, &Int{2}); err != nil {
+		t.Fatal("write after rolling recovered failed:", err)
+	}
+
+	if segments := log.Segments(); len(segments) != 2 || segments[1].Base != 2 {
+		t.Fatalf("segments mismatch: %+v", segments)
+	}
+
+	for idx := 0; idx < 3; idx++ {
+		seq, v, err := log.ReadNext()
+		if err != nil || seq != uint64(idx) || v.(*Int).int != idx {
+			t.Fatal("read rolled log failed:", seq, v, err)
+		}
+	}
+}
+
+func TestSegmentCompact(t *testing.T) {
+	tid := chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	// 1 has empty key, so it's always kept
+	key := func(v chanio.PersistentData) string {
+		if v.(*Int).int == 1 {
+			return ""
+		}
+		return strconv.Itoa(v.(*Int).int % 3)
+	}
+
+	readAll := func(log *chanio.SegmentLog) (seqs []uint64) {
+		if err := log.Seek(log.StartSequence()); err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			seq, v, err := log.ReadNext()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uint64(v.(*Int).int) != seq {
+				t.Fatalf("record mismatch at seq[%d]: %v", seq, v)
+			}
+			seqs = append(seqs, seq)
+		}
+	}
+
+	dir := t.TempDir()
+
+	log := chanio.NewSegmentLog(dir, chanio.RollPolicy{MaxRecords: 4})
+	if err := log.Open(os.O_RDWR | os.O_CREATE | os.O_APPEND); err != nil {
+		t.Fatal("log open failed:", err)
+	}
+
+	for idx := 0; idx < 10; idx++ {
+		if err := log.Write(tid, &Int{idx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only sealed segments are compacted, [0, 4) keeps 1 with
+	// empty key, [4, 8) keeps 5, 6, 7 as latest of each key
+	if err := log.Compact(key); err != nil {
+		t.Fatal("compact failed:", err)
+	}
+
+	segments := log.Segments()
+	if len(segments) != 3 ||
+		segments[0].Base != 0 || segments[0].End != 2 || segments[0].Records != 1 ||
+		segments[1].Base != 4 || segments[1].End != 8 || segments[1].Records != 3 {
+		t.Fatalf("compacted segments mismatch: %+v", segments)
+	}
+
+	// nothing written since last compaction
+	if err := log.Compact(key); err != nil {
+		t.Fatal("compact again failed:", err)
+	}
+	if fmt.Sprint(log.Segments()) != fmt.Sprint(segments) {
+		t.Fatalf("segments changed without new records: %+v", log.Segments())
+	}
+
+	if seqs := readAll(log); fmt.Sprint(seqs) != "[1 5 6 7 8 9]" {
+		t.Fatal("compacted sequences mismatch:", seqs)
+	}
+
+	if v, err := log.ReadAt(7); err != nil || v.(*Int).int != 7 {
+		t.Fatal("read compacted seq failed:", v, err)
+	}
+	if _, err := log.ReadAt(4); !errors.Is(err, chanio.ErrSeekOutOfRange) {
+		t.Fatal("read removed seq should fail:", err)
+	}
+	if err := log.Seek(4); err != nil {
+		t.Fatal("seek removed seq failed:", err)
+	}
+	if seq, _, err := log.ReadNext(); err != nil || seq != 5 {
+		t.Fatal("seek removed seq should move to next record:", seq, err)
+	}
+
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(files) != 3 {
+		t.Fatal("replaced segment files remained:", files)
+	}
+
+	log = chanio.NewSegmentLog(dir, chanio.RollPolicy{})
+	if err := log.Open(os.O_RDWR | os.O_APPEND); err != nil {
+		t.Fatal("log reopen failed:", err)
+	}
+	defer log.Close()
+
+	if start, end := log.StartSequence(), log.EndSequence(); start != 1 || end != 10 {
+		t.Fatalf("sequence range mismatch: %d ~ %d", start, end)
+	}
+
+	for idx := 10; idx < 12; idx++ {
+		if err := log.Roll(); err != nil {
+			t.Fatal(err)
+		}
+		if err := log.Write(tid, &Int{idx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// compacted segment [4, 8) is compacted again and emptied by
+	// sealed 8, 9, 10, so it's removed, [8, 10) without removed record kept
+	if err := log.Compact(key); err != nil {
+		t.Fatal("compact again failed:", err)
+	}
+
+	if seqs := readAll(log); fmt.Sprint(seqs) != "[1 8 9 10 11]" {
+		t.Fatal("compacted sequences mismatch:", seqs)
+	}
+
+	segments = log.Segments()
+	if len(segments) != 4 || segments[0].Path != filepath.Join(dir, "00000000000000000000-1.seg") ||
+		segments[1].Path != filepath.Join(dir, "00000000000000000008.seg") {
+		t.Fatalf("compacted segments mismatch: %+v", segments)
+	}
+
+	// emptied segment after uncompacted one is kept, as records
+	// of uncompacted segment is counted by next segment's base
+	dir = t.TempDir()
+
+	log = chanio.NewSegmentLog(dir, chanio.RollPolicy{MaxRecords: 2})
+	if err := log.Open(os.O_RDWR | os.O_CREATE | os.O_APPEND); err != nil {
+		t.Fatal("log open failed:", err)
+	}
+
+	for idx := 0; idx < 7; idx++ {
+		if err := log.Write(tid, &Int{idx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := log.Compact(func(v chanio.PersistentData) string {
+		if v.(*Int).int < 2 {
+			return ""
+		}
+		return "k"
+	}); err != nil {
+		t.Fatal("compact failed:", err)
+	}
+
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	log = chanio.NewSegmentLog(dir, chanio.RollPolicy{})
+	if err := log.Open(os.O_RDWR | os.O_APPEND); err != nil {
+		t.Fatal("log reopen failed:", err)
+	}
+	defer log.Close()
+
+	segments = log.Segments()
+	if len(segments) != 4 || segments[0].Records != 2 || segments[1].Records != 0 {
+		t.Fatalf("compacted segments mismatch: %+v", segments)
+	}
+
+	if seqs := readAll(log); fmt.Sprint(seqs) != "[0 1 5 6]" {
+		t.Fatal("compacted sequences mismatch:", seqs)
+	}
+}
+
+func TestFileStoreIndex(t *testing.T) {
+	tid := chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	dir := t.TempDir()
+	flowFile := filepath.Join(dir, "index.dat")
+	count := 100
+
+	store := chanio.NewFileStore(flowFile, chanio.WithIndexInterval(10))
+	if err := store.Open(os.O_WRONLY | os.O_CREATE | os.O_TRUNC); err != nil {
+		t.Fatal("store open failed:", err)
+	}
+	for idx := 0; idx < count; idx++ {
+		if err := store.Write(tid, &Int{idx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSeek := func(mode int) {
+		store := chanio.NewFileStore(flowFile, chanio.WithIndexInterval(10))
+		if err := store.Open(mode); err != nil {
+			t.Fatal("store open failed:", err)
+		}
+		defer store.Close()
+
+		for _, seq := range []int{57, 3, 4, 99, 0, 30} {
+			if err := store.Seek(uint64(seq)); err != nil {
+				t.Fatal("seek failed:", seq, err)
+			}
+
+			if v, err := store.Read(); err != nil || v.(*Int).int != seq {
+				t.Fatalf("read after seek[%d] mismatch: %v %v", seq, v, err)
+			}
+		}
+
+		if err := store.Seek(uint64(count)); err != nil {
+			t.Fatal("seek to end failed:", err)
+		}
+		if _, err := store.Read(); !errors.Is(err, io.EOF) {
+			t.Fatal("read at end should be EOF:", err)
+		}
+
+		if err := store.Seek(uint64(count + 1)); !errors.Is(err, chanio.ErrSeekOutOfRange) {
+			t.Fatal("seek beyond end should fail:", err)
+		}
+	}
+
+	checkSeek(os.O_RDONLY)
+
+	idxFile := flowFile + ".idx"
+	info, err := os.Stat(idxFile)
+	if err != nil {
+		t.Fatal("index file missing:", err)
+	}
+	// entry every 10 records: seq(8) + offset(8)
+	if info.Size() != int64(count/10*16) {
+		t.Fatal("index file size mismatch:", info.Size())
+	}
+
+	// rebuilt in memory if missing or corrupted
+	if err := os.Remove(idxFile); err != nil {
+		t.Fatal(err)
+	}
+	checkSeek(os.O_RDONLY)
+
+	if err := os.WriteFile(idxFile, []byte("corrupted index data"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	checkSeek(os.O_RDWR | os.O_APPEND)
+
+	if info, err := os.Stat(idxFile); err != nil || info.Size() != int64(count/10*16) {
+		t.Fatal("index file not rebuilt:", info, err)
+	}
+
+	log := chanio.NewSegmentLog(filepath.Join(dir, "segments"), chanio.RollPolicy{MaxRecords: 30})
+	if err := log.Open(os.O_RDWR | os.O_CREATE | os.O_APPEND); err != nil {
+		t.Fatal("log open failed:", err)
+	}
+	defer log.Close()
+
+	for idx := 0; idx < count; idx++ {
+		if err := log.Write(tid, &Int{idx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, seq := range []int{75, 10, 30, 99, 29} {
+		if v, err := log.ReadAt(uint64(seq)); err != nil || v.(*Int).int != seq {
+			t.Fatalf("log read at seq[%d] mismatch: %v %v", seq, v, err)
+		}
+	}
+
+	if seq, v, err := log.ReadNext(); err != nil || seq != 30 || v.(*Int).int != 30 {
+		t.Fatal("log read next after read at mismatch:", seq, v, err)
+	}
+}
+
+func TestFileStoreSeekAppended(t *testing.T) {
+	tid := chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	flowFile := filepath.Join(t.TempDir(), "appended.dat")
+
+	writer := chanio.NewFileStore(flowFile, chanio.WithIndexInterval(10))
+	if err := writer.Open(os.O_WRONLY | os.O_CREATE | os.O_TRUNC); err != nil {
+		t.Fatal("store open failed:", err)
+	}
+	defer writer.Close()
+
+	reader := chanio.NewFileStore(flowFile, chanio.WithIndexInterval(10))
+	if err := reader.Open(os.O_RDONLY); err != nil {
+		t.Fatal("store open failed:", err)
+	}
+	defer reader.Close()
+
+	if err := reader.Seek(1); !errors.Is(err, chanio.ErrSeekOutOfRange) {
+		t.Fatal("seek beyond end should fail:", err)
+	}
+
+	for idx := 0; idx < 25; idx++ {
+		if err := writer.Write(tid, &Int{idx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, seq := range []int{22, 5, 24} {
+		if err := reader.Seek(uint64(seq)); err != nil {
+			t.Fatal("seek appended record failed:", seq, err)
+		}
+
+		if v, err := reader.Read(); err != nil || v.(*Int).int != seq {
+			t.Fatalf("read after seek[%d] mismatch: %v %v", seq, v, err)
+		}
+	}
+
+	if reader.Records() != 25 {
+		t.Fatal("records not refreshed:", reader.Records())
+	}
+
+	if err := reader.Seek(26); !errors.Is(err, chanio.ErrSeekOutOfRange) {
+		t.Fatal("seek beyond end should fail:", err)
+	}
+}
+
+func TestTail(t *testing.T) {
+	tid := chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	dir := t.TempDir()
+	flowFile := filepath.Join(dir, "tail.dat")
+
+	writer := chanio.NewFileStore(flowFile)
+	if err := writer.Open(os.O_WRONLY | os.O_CREATE | os.O_TRUNC); err != nil {
+		t.Fatal("store open failed:", err)
+	}
+	defer writer.Close()
+
+	if err := writer.Write(tid, &Int{0}); err != nil {
+		t.Fatal(err)
+	}
+
+	// frame of next record, appended by parts to simulate partial write
+	frameFile := filepath.Join(dir, "frame.dat")
+	frameStore := chanio.NewFileStore(frameFile)
+	if err := frameStore.Open(os.O_WRONLY | os.O_CREATE | os.O_TRUNC); err != nil {
+		t.Fatal("store open failed:", err)
+	}
+	if err := frameStore.Write(tid, &Int{1}); err != nil {
+		t.Fatal(err)
+	}
+	frameStore.Close()
+
+	frame, err := os.ReadFile(frameFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame = frame[8:]
+
+	reader := chanio.NewFileStore(flowFile, chanio.WithTailInterval(time.Millisecond*5))
+	if err := reader.Open(os.O_RDONLY); err != nil {
+		t.Fatal("store open failed:", err)
+	}
+	defer reader.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if v, err := reader.Tail(ctx); err != nil || v.(*Int).int != 0 {
+		t.Fatal("tail read failed:", v, err)
+	}
+
+	raw, err := os.OpenFile(flowFile, os.O_WRONLY|os.O_APPEND, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	if _, err := raw.Write(frame[:3]); err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(chan chanio.PersistentData)
+	go func() {
+		v, err := reader.Tail(ctx)
+		if err != nil {
+			t.Error("tail read failed:", err)
+		}
+		result <- v
+	}()
+
+	select {
+	case v := <-result:
+		t.Fatal("partial record should not be read:", v)
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	if _, err := raw.Write(frame[3:]); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := <-result; v == nil || v.(*Int).int != 1 {
+		t.Fatal("tail read completed record failed:", v)
+	}
+
+	cancelCtx, cancelFn := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*20, cancelFn)
+
+	if _, err := reader.Tail(cancelCtx); !errors.Is(err, context.Canceled) {
+		t.Fatal("tail should be canceled:", err)
+	}
+
+	// reader opened before log created, follows segments rolled by writer
+	logDIR := filepath.Join(dir, "segments")
+
+	logReader := chanio.NewSegmentLog(
+		logDIR, chanio.RollPolicy{},
+		chanio.WithTailInterval(time.Millisecond*5),
+	)
+	if err := logReader.Open(os.O_RDONLY); err != nil {
+		t.Fatal("log open failed:", err)
+	}
+	defer logReader.Close()
+
+	count := 10
+	done := make(chan error)
+
+	go func() {
+		for expect := 0; expect < count; expect++ {
+			seq, v, err := logReader.Tail(ctx)
+			if err != nil {
+				done <- err
+				return
+			}
+
+			if seq != uint64(expect) || v.(*Int).int != expect {
+				done <- fmt.Errorf("tail record mismatch: %d %v", seq, v)
+				return
+			}
+		}
+
+		done <- nil
+	}()
+
+	logWriter := chanio.NewSegmentLog(logDIR, chanio.RollPolicy{MaxRecords: 3})
+	if err := logWriter.Open(os.O_RDWR | os.O_CREATE | os.O_APPEND); err != nil {
+		t.Fatal("log open failed:", err)
+	}
+	defer logWriter.Close()
+
+	for idx := 0; idx < count; idx++ {
+		if err := logWriter.Write(tid, &Int{idx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if segments := logReader.Segments(); len(segments) != 4 {
+		t.Fatal("rolled segments not found:", segments)
+	}
+}
+
 func BenchmarkFileStoreWR(b *testing.B) {
 	tid := chanio.RegisterType(&Varaint{}, func() chanio.PersistentData {
 		return &Varaint{}
@@ -0,0 +1,95 @@
+package chanio
+
+import (
+	"encoding/binary"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	indexExt = ".idx"
+
+	// index entry: record sequence in file(uint64) + offset(int64)
+	indexEntryLen = 16
+
+	DefaultIndexInterval = 1024
+)
+
+var ErrSeekOutOfRange = errors.New("seek out of range")
+
+// indexEntry is offset of record with sequence seq in file,
+// sequence starts from 0 for first record in file
+type indexEntry struct {
+	seq    uint64
+	offset int64
+}
+
+func indexPath(path string) string {
+	return path + indexExt
+}
+
+// loadIndex reads index sidecar of data file, entries beyond size
+// or out of order are dropped, missing index returns empty result
+func loadIndex(path string, size int64) []indexEntry {
+	data, err := os.ReadFile(indexPath(path))
+	if err != nil {
+		return nil
+	}
+
+	index := make([]indexEntry, 0, len(data)/indexEntryLen)
+
+	// torn entry at tail is ignored
+	for offset := 0; offset+indexEntryLen <= len(data); offset += indexEntryLen {
+		entry := indexEntry{
+			seq:    binary.LittleEndian.Uint64(data[offset:]),
+			offset: int64(binary.LittleEndian.Uint64(data[offset+8:])),
+		}
+
+		if count := len(index); count == 0 {
+			if entry.seq != 0 || entry.offset != fileHeaderLen {
+				return nil
+			}
+		} else if pre := index[count-1]; entry.seq <= pre.seq || entry.offset <= pre.offset {
+			break
+		}
+
+		if entry.offset >= size {
+			break
+		}
+
+		index = append(index, entry)
+	}
+
+	return index
+}
+
+// saveIndex rewrites index sidecar with tmp file & rename,
+// returns index file opened for appending new entries
+func saveIndex(path string, index []indexEntry) (*os.File, error) {
+	data := make([]byte, 0, len(index)*indexEntryLen)
+
+	for _, entry := range index {
+		data = appendIndexEntry(data, entry)
+	}
+
+	idxPath := indexPath(path)
+	tmp := idxPath + ".tmp"
+
+	if err := os.WriteFile(tmp, data, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "write index file failed")
+	}
+
+	if err := os.Rename(tmp, idxPath); err != nil {
+		return nil, errors.Wrap(err, "replace index file failed")
+	}
+
+	file, err := os.OpenFile(idxPath, os.O_WRONLY|os.O_APPEND, os.ModePerm)
+
+	return file, errors.Wrap(err, "open index file failed")
+}
+
+func appendIndexEntry(data []byte, entry indexEntry) []byte {
+	data = binary.LittleEndian.AppendUint64(data, entry.seq)
+	return binary.LittleEndian.AppendUint64(data, uint64(entry.offset))
+}
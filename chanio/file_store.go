@@ -2,8 +2,16 @@ package chanio
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
 	"io"
+	"log/slog"
 	"os"
+	"slices"
+	"sort"
+	"time"
 
 	"github.com/frozenpine/msgqueue/core"
 	"github.com/pkg/errors"
@@ -15,6 +23,19 @@ const (
 	defaultBufferLen = 4096
 	commitSize       = 4096 * 10
 	batchSize        = 100
+
+	// file header: magic(4) + version(1) + reserved(3)
+	fileHeaderLen = 8
+	fileVersion   = 1
+	// record: varint TID + varint length + payload + crc32(4)
+	checksumLen = 4
+	// MaxRecordLen limits payload size, larger length means corrupted record
+	MaxRecordLen = 64 << 20
+)
+
+var (
+	fileMagic = [4]byte{'M', 'Q', 'F', 'S'}
+	crcTable  = crc32.MakeTable(crc32.Castagnoli)
 )
 
 var (
@@ -25,6 +46,9 @@ var (
 	ErrUnknownTag      = errors.New("unkonwn tag type")
 	ErrSizeMismatch    = errors.New("data size mismatch")
 	ErrVintOverflow    = errors.New("varint overflows a 64-bit integer")
+	ErrInvalidHeader   = errors.New("invalid file header")
+	ErrInvalidRecord   = errors.New("invalid record")
+	ErrChecksum        = errors.New("record checksum mismatch")
 )
 
 type FileStorage struct {
@@ -33,14 +57,34 @@ type FileStorage struct {
 	file         *os.File
 	rd           *bufio.Reader
 	wr           *bufio.Writer
+	fileSize     int64
 	wrSize       int
 	uncommitSize int
 	batchSize    int
+	frame        bytes.Buffer
+	records      uint64
+	readSeq      uint64
+	// readOffset is file offset of next record to read
+	readOffset int64
+
+	options storeOptions
+	index   []indexEntry
+	idxFile *os.File
 }
 
-func NewFileStore(path string) *FileStorage {
+// NewFileStore create store with offset index sidecar file,
+// which will be rebuilt from data file if missing
+func NewFileStore(path string, opts ...StoreOption) *FileStorage {
 	store := FileStorage{
 		filePath: path,
+		options: storeOptions{
+			indexInterval: DefaultIndexInterval,
+			tailInterval:  defaultTailInterval,
+		},
+	}
+
+	for _, opt := range opts {
+		opt(&store.options)
 	}
 
 	return &store
@@ -51,10 +95,39 @@ func (stor *FileStorage) Open(mode int) (err error) {
 		return ErrFSAlreadyOpened
 	}
 
-	stor.file, err = os.OpenFile(stor.filePath, mode, os.ModePerm)
+	if stor.file, err = os.OpenFile(stor.filePath, mode, os.ModePerm); err != nil {
+		return
+	}
+
+	if err = stor.recover(mode); err != nil {
+		stor.file.Close()
+		stor.file = nil
+		return
+	}
 
+	stor.wrSize = 0
+	stor.readSeq = 0
+	stor.readOffset = fileHeaderLen
 	stor.mode = mode
 
+	// reads start after header, write only store appends to file end
+	offset, whence := int64(fileHeaderLen), io.SeekStart
+	if mode&(os.O_WRONLY|os.O_RDWR) == os.O_WRONLY {
+		offset, whence = 0, io.SeekEnd
+	}
+
+	if stor.fileSize >= fileHeaderLen {
+		if _, err = stor.file.Seek(offset, whence); err != nil {
+			stor.file.Close()
+			stor.file = nil
+			if stor.idxFile != nil {
+				stor.idxFile.Close()
+				stor.idxFile = nil
+			}
+			return errors.Wrap(err, "seek file failed")
+		}
+	}
+
 	switch stor.mode & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
 	case os.O_RDONLY:
 		stor.rd = bufio.NewReaderSize(stor.file, defaultBufferLen)
@@ -70,12 +143,235 @@ func (stor *FileStorage) Open(mode int) (err error) {
 	return
 }
 
+// recover checks file header and scans records after last index entry,
+// torn or corrupted tail will be truncated to last valid record
+// if store opened writable, header will be written to empty file
+// and index file will be rewritten
+func (stor *FileStorage) recover(mode int) (err error) {
+	writable := mode&(os.O_WRONLY|os.O_RDWR) != 0
+
+	info, err := stor.file.Stat()
+	if err != nil {
+		return err
+	}
+	stor.fileSize = info.Size()
+
+	stor.records = 0
+	stor.index = nil
+
+	if stor.fileSize < fileHeaderLen {
+		if !writable {
+			// treat as empty file, header may be writing
+			return nil
+		}
+
+		if stor.fileSize > 0 {
+			slog.Warn(
+				"truncating torn file header",
+				slog.String("path", stor.filePath),
+				slog.Int64("size", stor.fileSize),
+			)
+
+			if err := stor.file.Truncate(0); err != nil {
+				return errors.Wrap(err, "truncate file failed")
+			}
+		}
+
+		header := make([]byte, fileHeaderLen)
+		copy(header, fileMagic[:])
+		header[len(fileMagic)] = fileVersion
+
+		if _, err := stor.file.Write(header); err != nil {
+			return errors.Wrap(err, "write file header failed")
+		}
+
+		stor.fileSize = fileHeaderLen
+
+		stor.idxFile, err = saveIndex(stor.filePath, nil)
+
+		return
+	}
+
+	valid, records, index, err := scanFile(
+		stor.filePath, loadIndex(stor.filePath, stor.fileSize),
+		stor.options.indexInterval,
+	)
+	if err != nil {
+		return err
+	}
+	stor.records = records
+	stor.index = index
+
+	if valid < stor.fileSize && writable {
+		slog.Warn(
+			"truncating invalid file tail",
+			slog.String("path", stor.filePath),
+			slog.Int64("size", stor.fileSize),
+			slog.Int64("valid", valid),
+		)
+
+		if err := stor.file.Truncate(valid); err != nil {
+			return errors.Wrap(err, "truncate file failed")
+		}
+
+		stor.fileSize = valid
+	}
+
+	if writable {
+		stor.idxFile, err = saveIndex(stor.filePath, stor.index)
+	}
+
+	return
+}
+
+// scanFile checks file header and scans records from last valid index entry,
+// returns size & count of all valid records and index extended to file end,
+// records before last index entry are not verified
+func scanFile(path string, index []indexEntry, interval uint64) (int64, uint64, []indexEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, nil, errors.Wrap(err, "open file for scan failed")
+	}
+	defer file.Close()
+
+	header := make([]byte, fileHeaderLen)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return 0, 0, nil, errors.Wrap(err, "read file header failed")
+	}
+
+	if !bytes.Equal(header[:len(fileMagic)], fileMagic[:]) {
+		return 0, 0, nil, errors.Wrap(ErrInvalidHeader, "file magic mismatch")
+	}
+
+	if version := header[len(fileMagic)]; version != fileVersion {
+		return 0, 0, nil, errors.Wrapf(ErrInvalidHeader, "unsupported version %d", version)
+	}
+
+	rd := bufio.NewReaderSize(file, defaultBufferLen)
+
+scanLoop:
+	for {
+		start := indexEntry{offset: fileHeaderLen}
+		if count := len(index); count > 0 {
+			start = index[count-1]
+		}
+
+		if _, err := file.Seek(start.offset, io.SeekStart); err != nil {
+			return 0, 0, nil, errors.Wrap(err, "seek file for scan failed")
+		}
+		rd.Reset(file)
+
+		valid, records := start.offset, start.seq
+
+		for {
+			_, _, n, err := readRecord(rd)
+
+			if err != nil {
+				if records == start.seq && len(index) > 0 {
+					// index entry points to invalid record, scan from previous one
+					index = index[:len(index)-1]
+					continue scanLoop
+				}
+
+				if !errors.Is(err, io.EOF) {
+					slog.Warn(
+						"invalid record found",
+						slog.String("path", path),
+						slog.Int64("offset", valid),
+						slog.Any("error", err),
+					)
+				}
+
+				return valid, records, index, nil
+			}
+
+			if count := len(index); records%interval == 0 &&
+				(count == 0 || records > index[count-1].seq) {
+				index = append(index, indexEntry{seq: records, offset: valid})
+			}
+
+			valid += int64(n)
+			records++
+		}
+	}
+}
+
+// crcReader updates checksum with all bytes read
+type crcReader struct {
+	rd  *bufio.Reader
+	crc uint32
+	n   int
+	buf [1]byte
+}
+
+func (r *crcReader) ReadByte() (byte, error) {
+	b, err := r.rd.ReadByte()
+
+	if err == nil {
+		r.buf[0] = b
+		r.crc = crc32.Update(r.crc, crcTable, r.buf[:])
+		r.n++
+	}
+
+	return b, err
+}
+
+// readRecord reads one record, returns frame size with payload,
+// io.EOF means no more record, torn record returns io.ErrUnexpectedEOF
+func readRecord(rd *bufio.Reader) (tid TID, payload []byte, n int, err error) {
+	crcRd := crcReader{rd: rd}
+
+	if tid, err = core.DeserializeVint[TID](&crcRd); err != nil {
+		if errors.Is(err, io.EOF) && crcRd.n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	var dataLen int
+	if dataLen, err = core.DeserializeVint[int](&crcRd); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	if tid < 0 || dataLen < 0 || dataLen > MaxRecordLen {
+		err = errors.Wrapf(ErrInvalidRecord, "tid %d, length %d", tid, dataLen)
+		return
+	}
+
+	payload = make([]byte, dataLen+checksumLen)
+	if _, err = io.ReadFull(rd, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	checksum := binary.LittleEndian.Uint32(payload[dataLen:])
+	payload = payload[:dataLen]
+
+	if crc32.Update(crcRd.crc, crcTable, payload) != checksum {
+		err = ErrChecksum
+		return
+	}
+
+	n = crcRd.n + dataLen + checksumLen
+
+	return
+}
+
 func (stor *FileStorage) Flush() error {
 	if stor.file == nil {
 		return ErrFSAlreadyClosed
 	}
 
 	if stor.wr != nil {
+		if err := stor.wr.Flush(); err != nil {
+			return err
+		}
+
 		return stor.file.Sync()
 	}
 
@@ -91,7 +387,33 @@ func (stor *FileStorage) Close() (err error) {
 		return
 	}
 
-	return stor.file.Close()
+	err = stor.file.Close()
+
+	if stor.idxFile != nil {
+		stor.idxFile.Close()
+		stor.idxFile = nil
+	}
+
+	stor.file = nil
+	stor.rd = nil
+	stor.wr = nil
+
+	return
+}
+
+// Size get file size include data written after open
+func (stor *FileStorage) Size() int64 {
+	return stor.fileSize + int64(stor.wrSize)
+}
+
+// Records get valid records count in file when opened,
+// include records written, read or found by Seek after open
+func (stor *FileStorage) Records() uint64 {
+	return stor.records
+}
+
+func (stor *FileStorage) Path() string {
+	return stor.filePath
 }
 
 func (stor *FileStorage) Write(tid TID, data PersistentData) error {
@@ -102,37 +424,53 @@ func (stor *FileStorage) Write(tid TID, data PersistentData) error {
 		return ErrEmptyData
 	}
 
-	v := data.Serialize()
+	v, err := Serialize(data)
+	if err != nil {
+		return errors.Wrap(err, "serialize data failed")
+	}
 
-	if n, err := core.SerializeVint(tid, stor.wr); err != nil {
-		return errors.Wrap(err, "write TID failed")
-	} else {
-		stor.wrSize += n
-		stor.uncommitSize += n
+	entry := indexEntry{seq: stor.records, offset: stor.Size()}
+
+	stor.frame.Reset()
+	core.SerializeVint(tid, &stor.frame)
+	core.SerializeVint(len(v), &stor.frame)
+	stor.frame.Write(v)
+
+	checksum := make([]byte, checksumLen)
+	binary.LittleEndian.PutUint32(
+		checksum, crc32.Checksum(stor.frame.Bytes(), crcTable),
+	)
+	stor.frame.Write(checksum)
+
+	// record torn by crash is truncated when store recovered
+	n, err := stor.wr.Write(stor.frame.Bytes())
+	stor.wrSize += n
+	stor.uncommitSize += n
+
+	if err != nil {
+		return errors.Wrap(err, "write data failed")
 	}
 
-	if n, err := core.SerializeVint(len(v), stor.wr); err != nil {
-		return errors.Wrap(err, "write data len failed")
+	stor.batchSize++
+	stor.records++
+
+	if stor.uncommitSize >= commitSize || stor.batchSize >= batchSize {
+		stor.uncommitSize = 0
+		stor.batchSize = 0
+		err = stor.Flush()
 	} else {
-		stor.wrSize += n
-		stor.uncommitSize += n
+		err = stor.wr.Flush()
 	}
 
-	if n, err := stor.wr.Write(v); err != nil {
-		return errors.Wrap(err, "write data failed")
-	} else {
-		stor.wrSize += n
-		stor.uncommitSize += n
-		stor.batchSize++
+	if err != nil {
+		return err
+	}
 
-		if stor.uncommitSize >= commitSize || stor.batchSize >= batchSize {
-			stor.uncommitSize = 0
-			stor.batchSize = 0
-			return stor.Flush()
-		}
+	// index entry is recorded after record flushed,
+	// so entry never points to record not written
+	stor.writeIndex(entry)
 
-		return stor.wr.Flush()
-	}
+	return nil
 }
 
 func (stor *FileStorage) Read() (v PersistentData, err error) {
@@ -140,31 +478,218 @@ func (stor *FileStorage) Read() (v PersistentData, err error) {
 		return nil, errors.Wrap(ErrInvalidMode, "can not read from write only store")
 	}
 
-	var (
-		tid     TID
-		dataLen int
-	)
+	if stor.fileSize < fileHeaderLen {
+		// file header not written when opened
+		if err = stor.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	tid, payload, n, err := readRecord(stor.rd)
 
-	if tid, err = core.DeserializeVint[TID](stor.rd); err != nil {
-		return nil, errors.Wrap(err, "decode TID failed")
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		// rewind to record start, so record can be read after completed
+		if err = stor.rewind(); err != nil {
+			return nil, err
+		}
+		return nil, errors.Wrap(io.EOF, "torn record at file end")
+	case err != nil:
+		return nil, errors.Wrap(err, "read record failed")
 	}
 
+	stor.readOffset += int64(n)
+
 	if v, err = NewTypeValue(tid); err != nil {
 		return nil, errors.Wrap(err, "create data failed")
 	}
 
-	if dataLen, err = core.DeserializeVint[int](stor.rd); err != nil {
-		return nil, errors.Wrap(err, "decode data len failed")
-	}
-
-	data := make([]byte, dataLen)
-	if _, err := io.ReadFull(stor.rd, data); err != nil {
-		return nil, errors.Wrap(err, "read data payload failed")
+	stor.readSeq++
+	if stor.readSeq > stor.records {
+		// records appended by other writer
+		stor.records = stor.readSeq
 	}
 
-	if err = v.Deserialize(data); err != nil {
+	if err = v.Deserialize(payload); err != nil {
 		return nil, errors.Wrap(err, "parse data payload failed")
 	}
 
 	return
 }
+
+// readHeader checks header written after store opened,
+// returns io.EOF if header still not completed
+func (stor *FileStorage) readHeader() error {
+	info, err := stor.file.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat file failed")
+	}
+
+	if info.Size() < fileHeaderLen {
+		return io.EOF
+	}
+
+	header := make([]byte, fileHeaderLen)
+	if _, err := stor.file.ReadAt(header, 0); err != nil {
+		return errors.Wrap(err, "read file header failed")
+	}
+
+	if !bytes.Equal(header[:len(fileMagic)], fileMagic[:]) {
+		return errors.Wrap(ErrInvalidHeader, "file magic mismatch")
+	}
+
+	if version := header[len(fileMagic)]; version != fileVersion {
+		return errors.Wrapf(ErrInvalidHeader, "unsupported version %d", version)
+	}
+
+	stor.fileSize = info.Size()
+	stor.readOffset = fileHeaderLen
+
+	return stor.rewind()
+}
+
+// rewind moves file to offset of next record to read
+func (stor *FileStorage) rewind() error {
+	if _, err := stor.file.Seek(stor.readOffset, io.SeekStart); err != nil {
+		return errors.Wrap(err, "seek file failed")
+	}
+
+	stor.rd.Reset(stor.file)
+
+	return nil
+}
+
+// Tail is same as Read, but waits until next complete record
+// flushed by writer, returns ctx's error if ctx done
+func (stor *FileStorage) Tail(ctx context.Context) (PersistentData, error) {
+	for {
+		v, err := stor.Read()
+
+		if !errors.Is(err, io.EOF) {
+			return v, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(stor.options.tailInterval):
+		}
+	}
+}
+
+// writeIndex appends index entry if record is at index interval,
+// index file is not synced as it can be rebuilt from data file,
+// so index file is dropped if write failed
+func (stor *FileStorage) writeIndex(entry indexEntry) {
+	if entry.seq%stor.options.indexInterval != 0 {
+		return
+	}
+
+	if count := len(stor.index); count > 0 && stor.index[count-1].seq >= entry.seq {
+		return
+	}
+
+	stor.index = append(stor.index, entry)
+
+	if stor.idxFile == nil {
+		return
+	}
+
+	if _, err := stor.idxFile.Write(appendIndexEntry(nil, entry)); err != nil {
+		slog.Warn(
+			"write index failed",
+			slog.String("path", stor.filePath),
+			slog.Any("error", err),
+		)
+
+		stor.idxFile.Close()
+		stor.idxFile = nil
+	}
+}
+
+// refresh scans records appended by other writer after opened
+// from last index entry, only read only store is refreshed
+// as records count of writable store is always up to date
+func (stor *FileStorage) refresh() error {
+	if stor.mode&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return nil
+	}
+
+	if stor.fileSize < fileHeaderLen {
+		// file header not written when opened
+		if err := stor.readHeader(); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// clipped so index entries dropped by scan not overwritten in place
+	valid, records, index, err := scanFile(
+		stor.filePath, slices.Clip(stor.index), stor.options.indexInterval,
+	)
+	if err != nil {
+		return errors.Wrap(err, "refresh records failed")
+	}
+
+	if records > stor.records {
+		stor.records = records
+		stor.index = index
+		stor.fileSize = valid
+	}
+
+	return nil
+}
+
+// Seek moves read position to record with sequence seq in file,
+// sequence starts from 0, seq equals to Records() moves to file end
+func (stor *FileStorage) Seek(seq uint64) error {
+	if stor.rd == nil {
+		return errors.Wrap(ErrInvalidMode, "can not seek write only store")
+	}
+
+	if seq > stor.records {
+		if err := stor.refresh(); err != nil {
+			return err
+		}
+	}
+
+	if seq > stor.records {
+		return errors.Wrapf(
+			ErrSeekOutOfRange, "seq[%d] beyond records %d", seq, stor.records,
+		)
+	}
+
+	idx := sort.Search(len(stor.index), func(i int) bool {
+		return stor.index[i].seq > seq
+	}) - 1
+
+	start := indexEntry{offset: fileHeaderLen}
+	if idx >= 0 {
+		start = stor.index[idx]
+	}
+
+	// skip from current position if it's nearer than index entry
+	if seq < stor.readSeq || stor.readSeq < start.seq {
+		if _, err := stor.file.Seek(start.offset, io.SeekStart); err != nil {
+			return errors.Wrap(err, "seek file failed")
+		}
+
+		stor.rd.Reset(stor.file)
+		stor.readSeq = start.seq
+		stor.readOffset = start.offset
+	}
+
+	for stor.readSeq < seq {
+		_, _, n, err := readRecord(stor.rd)
+		if err != nil {
+			stor.rewind()
+			return errors.Wrapf(err, "skip record at seq[%d] failed", stor.readSeq)
+		}
+
+		stor.readSeq++
+		stor.readOffset += int64(n)
+	}
+
+	return nil
+}
@@ -0,0 +1,350 @@
+package chanio
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/pkg/errors"
+)
+
+const compactFile = "compact" + segmentExt + ".tmp"
+
+// scanSegment reads all records in segment with their sequence
+func (sl *SegmentLog) scanSegment(seg *segment, fn func(uint64, PersistentData) error) error {
+	store := NewFileStore(sl.segmentPath(seg), sl.opts...)
+
+	if err := store.Open(os.O_RDONLY); err != nil {
+		return errors.Wrap(err, "open segment failed")
+	}
+	defer store.Close()
+
+	for pos := uint64(0); ; pos++ {
+		v, err := store.Read()
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "read segment %d failed", seg.base)
+		}
+
+		if seg.seqs != nil && pos >= seg.records {
+			return errors.Wrapf(ErrSizeMismatch, "compacted segment %d", seg.base)
+		}
+
+		if err = fn(seg.sequence(pos), v); err != nil {
+			return err
+		}
+	}
+}
+
+// Compact keeps only latest record of each key in sealed segments,
+// records with empty key are always kept and active segment is not
+// compacted. Only sealed segments losing records are rewritten, each
+// into a new generation of itself with original sequences, so removed
+// sequences leave gaps in log. Segments are scanned & rewritten out of
+// log's lock, which is only taken to swap segments, so writes are not
+// blocked by compaction. Compaction is skipped if no segment rolled
+// since last compaction, so key func should not change between calls.
+// Rewritten segments are listed in manifest before old ones deleted,
+// so crash in compaction leaves either old or new segments in manifest.
+func (sl *SegmentLog) Compact(key func(PersistentData) string) error {
+	sl.compactLock.Lock()
+	defer sl.compactLock.Unlock()
+
+	sealed, active, err := sl.sealedSegments()
+	if err != nil || len(sealed) == 0 || active == sl.compacted {
+		return err
+	}
+
+	type record struct {
+		seq uint64
+		idx int
+	}
+
+	latest := make(map[string]record)
+	// records superseded by later ones in each sealed segment
+	lost := make([]uint64, len(sealed))
+
+	for idx, seg := range sealed {
+		if err := sl.scanSegment(seg, func(seq uint64, v PersistentData) error {
+			k := key(v)
+			if k == "" {
+				return nil
+			}
+
+			if pre, exist := latest[k]; exist {
+				lost[pre.idx]++
+			}
+
+			latest[k] = record{seq: seq, idx: idx}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	rewritten := make(map[*segment]*segment)
+
+	// cleanup removes rewritten segments not listed in manifest
+	cleanup := func() {
+		for _, next := range rewritten {
+			removeFile(sl.segmentPath(next))
+		}
+	}
+
+	for idx, count := range lost {
+		if count == 0 {
+			continue
+		}
+
+		seg := sealed[idx]
+
+		next, err := sl.rewriteSegment(seg, func(seq uint64, v PersistentData) bool {
+			k := key(v)
+			return k == "" || latest[k].seq == seq
+		})
+		if err != nil {
+			cleanup()
+			return err
+		}
+
+		rewritten[seg] = next
+	}
+
+	if len(rewritten) > 0 {
+		if err := SyncDir(sl.dir); err != nil {
+			cleanup()
+			return errors.Wrap(err, "sync segment dir failed")
+		}
+	}
+
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if sl.segments == nil {
+		cleanup()
+		return ErrFSAlreadyClosed
+	}
+
+	if len(rewritten) > 0 {
+		if err := sl.swapCompacted(rewritten); err != nil {
+			cleanup()
+			return err
+		}
+	}
+
+	sl.compacted = active
+
+	return nil
+}
+
+// sealedSegments snapshots sealed segments & base of active segment,
+// sealed segments are not changed by writer, so they can be read out of lock
+func (sl *SegmentLog) sealedSegments() ([]*segment, uint64, error) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if sl.segments == nil {
+		return nil, 0, ErrFSAlreadyClosed
+	}
+
+	if sl.active == nil {
+		return nil, 0, errors.Wrap(ErrInvalidMode, "can not compact readonly log")
+	}
+
+	last := len(sl.segments) - 1
+
+	return append([]*segment{}, sl.segments[:last]...), sl.segments[last].base, nil
+}
+
+// swapCompacted replaces segments with their rewritten ones in log's lock,
+// rewritten segments removed from log in compaction are discarded,
+// all rewritten segments are left to caller if manifest not saved
+func (sl *SegmentLog) swapCompacted(rewritten map[*segment]*segment) error {
+	old := sl.segments
+	segments := make([]*segment, 0, len(old))
+	readIdx := -1
+
+	var (
+		swapped   []*segment
+		discarded []*segment
+		total     uint64
+		kept      uint64
+	)
+
+	for idx, seg := range old {
+		if idx == sl.readIdx {
+			readIdx = len(segments)
+		}
+
+		next, exist := rewritten[seg]
+		if !exist {
+			segments = append(segments, seg)
+			continue
+		}
+
+		swapped = append(swapped, seg)
+		total += seg.records
+
+		// empty segment is kept only if records of previous
+		// segment is counted by its base, see loadManifest
+		pre := len(segments) - 1
+		if next.records == 0 && (pre < 0 || segments[pre].gen > 0) {
+			discarded = append(discarded, next)
+			continue
+		}
+
+		kept += next.records
+		segments = append(segments, next)
+	}
+
+	// segments removed by others while compacting
+	for seg, next := range rewritten {
+		if !slices.Contains(swapped, seg) {
+			discarded = append(discarded, next)
+		}
+	}
+
+	// next sequence to read, as read position in rewritten segment is lost
+	var (
+		readSeq uint64
+		reseek  bool
+	)
+	if sl.reader != nil && sl.readIdx < len(old) {
+		if seg := old[sl.readIdx]; slices.Contains(swapped, seg) {
+			readSeq, reseek = seg.end(), true
+			if sl.readPos < seg.records {
+				readSeq = seg.sequence(sl.readPos)
+			}
+		}
+	}
+
+	sl.segments = segments
+
+	if err := sl.saveManifest(); err != nil {
+		sl.segments = old
+		return err
+	}
+
+	for _, next := range discarded {
+		removeFile(sl.segmentPath(next))
+	}
+
+	for _, seg := range swapped {
+		if err := removeFile(sl.segmentPath(seg)); err != nil {
+			slog.Warn(
+				"remove compacted segment failed",
+				slog.String("dir", sl.dir),
+				slog.Uint64("base", seg.base),
+				slog.Any("error", err),
+			)
+		}
+	}
+
+	if readIdx >= 0 {
+		sl.readIdx = readIdx
+	}
+
+	if reseek {
+		sl.reader.Close()
+		sl.reader = nil
+
+		if _, err := sl.seek(readSeq); err != nil {
+			slog.Warn(
+				"restore read position failed",
+				slog.String("dir", sl.dir),
+				slog.Uint64("seq", readSeq),
+				slog.Any("error", err),
+			)
+		}
+	}
+
+	slog.Info(
+		"segments compacted",
+		slog.String("dir", sl.dir),
+		slog.Int("segments", len(swapped)),
+		slog.Uint64("records", total),
+		slog.Uint64("kept", kept),
+	)
+
+	return nil
+}
+
+// rewriteSegment writes records in segment matched by keep into new
+// generation of segment, which has same base and is not listed in manifest
+func (sl *SegmentLog) rewriteSegment(seg *segment, keep func(uint64, PersistentData) bool) (*segment, error) {
+	tmpPath := filepath.Join(sl.dir, compactFile)
+	tmp := NewFileStore(tmpPath, sl.opts...)
+
+	if err := tmp.Open(os.O_WRONLY | os.O_CREATE | os.O_TRUNC); err != nil {
+		return nil, errors.Wrap(err, "create compact segment failed")
+	}
+
+	// empty seqs marks segment as compacted
+	seqs := []uint64{}
+
+	if err := sl.scanSegment(seg, func(seq uint64, v PersistentData) error {
+		if !keep(seq, v) {
+			return nil
+		}
+
+		tid, err := LookupType(v)
+		if err != nil {
+			return errors.Wrap(err, "lookup data type failed")
+		}
+
+		seqs = append(seqs, seq)
+
+		return tmp.Write(tid, v)
+	}); err != nil {
+		tmp.Close()
+		removeFile(tmpPath)
+		return nil, err
+	}
+
+	// data is synced to disk by close
+	if err := tmp.Close(); err != nil {
+		removeFile(tmpPath)
+		return nil, errors.Wrap(err, "close compact segment failed")
+	}
+
+	next := &segment{
+		base:    seg.base,
+		records: uint64(len(seqs)),
+		size:    tmp.Size(),
+		created: seg.created,
+		gen:     seg.gen + 1,
+		seqs:    seqs,
+	}
+
+	if err := sl.saveCompacted(tmpPath, next); err != nil {
+		removeFile(tmpPath)
+		removeFile(sl.segmentPath(next))
+		return nil, err
+	}
+
+	return next, nil
+}
+
+// saveCompacted moves compacted data from tmpPath to segment's path
+// with its seqs, dir should be synced before segment listed in manifest
+func (sl *SegmentLog) saveCompacted(tmpPath string, seg *segment) error {
+	path := sl.segmentPath(seg)
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return errors.Wrap(err, "rename compact segment failed")
+	}
+
+	if err := os.Rename(indexPath(tmpPath), indexPath(path)); err != nil {
+		// index will be rebuilt from data file
+		os.Remove(indexPath(tmpPath))
+	}
+
+	return saveSeqs(path, seg.seqs)
+}
@@ -0,0 +1,207 @@
+package chanio
+
+import (
+	"io"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Cursor reads log with its own segment file and read position,
+// so different cursors can read log concurrently, but one cursor
+// should not be used by multiple goroutines at same time
+type Cursor struct {
+	log    *SegmentLog
+	seg    *segment
+	reader *FileStorage
+	// pos is position of next record to read in segment
+	pos uint64
+}
+
+// location is segment & position of sequence, fields of segment
+// changed by writer are copied in log's lock
+type location struct {
+	seg     *segment
+	pos     uint64
+	records uint64
+	end     uint64
+	found   bool
+	last    bool
+}
+
+// NewCursor create cursor reading log, cursor should be closed after used
+func (sl *SegmentLog) NewCursor() *Cursor {
+	return &Cursor{log: sl}
+}
+
+// locate finds first record whose sequence not less than seq,
+// segment file is opened in log's lock, so it won't be removed
+func (c *Cursor) locate(seq uint64) (loc location, err error) {
+	sl := c.log
+
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if sl.segments == nil {
+		return loc, ErrFSAlreadyClosed
+	}
+
+	if !sl.readable() {
+		return loc, errors.Wrap(ErrInvalidMode, "can not read write only log")
+	}
+
+	idx := sort.Search(len(sl.segments), func(i int) bool {
+		return sl.segments[i].base > seq
+	}) - 1
+
+	if idx < 0 {
+		return loc, errors.Wrapf(ErrSeekOutOfRange, "seq[%d] before first segment", seq)
+	}
+
+	seg := sl.segments[idx]
+	if seg.seqs == nil && seq > seg.base+seg.records {
+		return loc, errors.Wrapf(ErrSeekOutOfRange, "seq[%d] not in segment %d", seq, seg.base)
+	}
+
+	pos, found := seg.position(seq)
+
+	// removed records at tail of compacted segment
+	if pos == seg.records && idx < len(sl.segments)-1 {
+		idx++
+		seg, pos = sl.segments[idx], 0
+	}
+
+	if c.seg != seg {
+		if err = c.open(seg); err != nil {
+			return loc, err
+		}
+	}
+
+	return location{
+		seg:     seg,
+		pos:     pos,
+		records: seg.records,
+		end:     seg.end(),
+		found:   found,
+		last:    idx == len(sl.segments)-1,
+	}, nil
+}
+
+// open replaces cursor's reader with segment's file
+func (c *Cursor) open(seg *segment) error {
+	if c.reader != nil {
+		c.reader.Close()
+		c.reader, c.seg = nil, nil
+	}
+
+	reader := NewFileStore(c.log.segmentPath(seg), c.log.opts...)
+
+	if err := reader.Open(os.O_RDONLY); err != nil {
+		return errors.Wrap(err, "open segment failed")
+	}
+
+	c.reader, c.seg, c.pos = reader, seg, 0
+
+	return nil
+}
+
+// seek moves reader to pos in located segment
+func (c *Cursor) seek(loc location) error {
+	if c.pos == loc.pos {
+		return nil
+	}
+
+	// records appended after segment opened
+	if loc.pos > c.reader.Records() {
+		if err := c.open(loc.seg); err != nil {
+			return err
+		}
+	}
+
+	if err := c.reader.Seek(loc.pos); err != nil {
+		c.reader.Close()
+		c.reader, c.seg = nil, nil
+		return err
+	}
+
+	c.pos = loc.pos
+
+	return nil
+}
+
+func (c *Cursor) read(loc location) (uint64, PersistentData, error) {
+	v, err := c.reader.Read()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if loc.seg.seqs != nil && c.pos >= loc.records {
+		return 0, nil, errors.Wrapf(
+			ErrSizeMismatch, "compacted segment %d has more than %d records",
+			loc.seg.base, loc.records,
+		)
+	}
+
+	seq := loc.seg.sequence(c.pos)
+	c.pos++
+
+	return seq, v, nil
+}
+
+// ReadAt reads record with sequence seq,
+// returns ErrSeekOutOfRange if seq removed by compaction
+func (c *Cursor) ReadAt(seq uint64) (PersistentData, error) {
+	loc, err := c.locate(seq)
+	if err != nil {
+		return nil, err
+	}
+
+	if !loc.found {
+		return nil, errors.Wrapf(ErrSeekOutOfRange, "seq[%d] removed by compaction", seq)
+	}
+
+	if err = c.seek(loc); err != nil {
+		return nil, err
+	}
+
+	_, v, err := c.read(loc)
+
+	return v, err
+}
+
+// ReadNext reads first record whose sequence not less than seq,
+// returns record's sequence, or io.EOF if no record after seq
+func (c *Cursor) ReadNext(seq uint64) (uint64, PersistentData, error) {
+	for {
+		loc, err := c.locate(seq)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if err = c.seek(loc); err != nil {
+			return 0, nil, err
+		}
+
+		next, v, err := c.read(loc)
+
+		if errors.Is(err, io.EOF) && !loc.last {
+			seq = loc.end
+			continue
+		}
+
+		return next, v, err
+	}
+}
+
+// Close closes cursor's segment file
+func (c *Cursor) Close() error {
+	if c.reader == nil {
+		return nil
+	}
+
+	err := c.reader.Close()
+	c.reader, c.seg = nil, nil
+
+	return err
+}
@@ -0,0 +1,1012 @@
+package chanio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	segmentManifest = "segments.manifest"
+	segmentExt      = ".seg"
+	// seqExt is sidecar of compacted segment, which lists
+	// sequence(uint64) of each record in segment
+	seqExt = ".seq"
+
+	// manifest header: magic(4) + version(1) + reserved(3)
+	manifestHeaderLen = 8
+	manifestVersion   = 1
+	// manifest entry: base sequence(uint64) + created unix nano(int64)
+	// + generation(uint64)
+	manifestEntryLen = 24
+)
+
+var manifestMagic = [4]byte{'M', 'Q', 'S', 'M'}
+
+var (
+	ErrSegmentActive   = errors.New("segment is active")
+	ErrSegmentNotFound = errors.New("segment not found")
+)
+
+// RollPolicy decides when segment log rolls to a new segment,
+// zero value field means no limit
+type RollPolicy struct {
+	MaxSize    int64
+	MaxRecords uint64
+	MaxAge     time.Duration
+}
+
+// SegmentInfo describes a segment in log
+type SegmentInfo struct {
+	// Base is first sequence of segment, records from Base
+	// may be removed if segment is compacted
+	Base uint64
+	// End is sequence after last record in segment, which is
+	// greater than Base + Records if segment is compacted
+	End     uint64
+	Records uint64
+	Size    int64
+	Created time.Time
+	Path    string
+}
+
+type segment struct {
+	base    uint64
+	records uint64
+	size    int64
+	created time.Time
+	// gen is compaction generation of segment, 0 for segment written
+	// by log, compacted segment has sequence of each record in seqs
+	gen  uint64
+	seqs []uint64
+}
+
+// sequence get sequence of record at pos in segment
+func (seg *segment) sequence(pos uint64) uint64 {
+	if seg.seqs != nil {
+		return seg.seqs[pos]
+	}
+
+	return seg.base + pos
+}
+
+// position get position of first record whose sequence not less than seq,
+// found is false if seq is removed by compaction
+func (seg *segment) position(seq uint64) (pos uint64, found bool) {
+	if seg.seqs == nil {
+		return seq - seg.base, true
+	}
+
+	idx := sort.Search(len(seg.seqs), func(i int) bool {
+		return seg.seqs[i] >= seq
+	})
+
+	return uint64(idx), idx < len(seg.seqs) && seg.seqs[idx] == seq
+}
+
+// first get sequence of first record in segment,
+// which is base if segment is empty
+func (seg *segment) first() uint64 {
+	if len(seg.seqs) > 0 {
+		return seg.seqs[0]
+	}
+
+	return seg.base
+}
+
+func (seg *segment) end() uint64 {
+	if count := len(seg.seqs); count > 0 {
+		return seg.seqs[count-1] + 1
+	}
+
+	return seg.base + seg.records
+}
+
+// SegmentLog is append only log splitted into segment files under dir,
+// segments are listed in manifest, so segments except active one
+// can be deleted or archived while log is written
+type SegmentLog struct {
+	dir    string
+	policy RollPolicy
+	mode   int
+	opts   []StoreOption
+	// tailInterval is polling interval of Tail
+	tailInterval time.Duration
+
+	lock     sync.Mutex
+	segments []*segment
+	active   *FileStorage
+
+	reader  *FileStorage
+	readIdx int
+	// readPos is position of next record to read in segment
+	readPos uint64
+
+	// manifest stat when loaded, for finding segments changed by other process
+	manifestMod  time.Time
+	manifestSize int64
+
+	// compactLock serializes compactions, which read segments out of lock
+	compactLock sync.Mutex
+	// active segment's base when last compacted
+	compacted uint64
+}
+
+// NewSegmentLog create log in dir, opts are applied to all segments
+func NewSegmentLog(dir string, policy RollPolicy, opts ...StoreOption) *SegmentLog {
+	options := storeOptions{tailInterval: defaultTailInterval}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return &SegmentLog{
+		dir:          dir,
+		policy:       policy,
+		opts:         opts,
+		tailInterval: options.tailInterval,
+	}
+}
+
+func (sl *SegmentLog) segmentPath(seg *segment) string {
+	if seg.gen > 0 {
+		return filepath.Join(sl.dir, fmt.Sprintf("%020d-%d%s", seg.base, seg.gen, segmentExt))
+	}
+
+	return filepath.Join(sl.dir, fmt.Sprintf("%020d%s", seg.base, segmentExt))
+}
+
+func (sl *SegmentLog) writable() bool {
+	return sl.mode&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+func (sl *SegmentLog) readable() bool {
+	return sl.mode&(os.O_WRONLY|os.O_RDWR) != os.O_WRONLY
+}
+
+// Open loads segments from manifest, O_RDWR or O_WRONLY opens
+// last segment for appending, O_TRUNC will remove all segments
+func (sl *SegmentLog) Open(mode int) error {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if sl.segments != nil {
+		return ErrFSAlreadyOpened
+	}
+
+	sl.mode = mode
+
+	if sl.writable() {
+		if err := os.MkdirAll(sl.dir, os.ModePerm); err != nil {
+			return errors.Wrap(err, "create segment dir failed")
+		}
+	}
+
+	if err := sl.loadManifest(); err != nil {
+		return err
+	}
+
+	if mode&os.O_TRUNC != 0 && sl.writable() {
+		for _, seg := range sl.segments {
+			if err := removeFile(sl.segmentPath(seg)); err != nil {
+				return errors.Wrap(err, "remove segment failed")
+			}
+		}
+
+		sl.segments = sl.segments[:0]
+	}
+
+	created := len(sl.segments) == 0
+
+	if created {
+		if !sl.writable() {
+			sl.segments = []*segment{}
+			return nil
+		}
+
+		sl.segments = append(sl.segments, &segment{created: time.Now()})
+	}
+
+	last := sl.segments[len(sl.segments)-1]
+	store := NewFileStore(sl.segmentPath(last), sl.opts...)
+
+	storeMode := os.O_RDONLY
+	if sl.writable() {
+		storeMode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	if err := store.Open(storeMode); err != nil {
+		sl.segments = nil
+		return errors.Wrap(err, "open active segment failed")
+	}
+
+	last.records = store.Records()
+	last.size = store.Size()
+
+	// segment file created before listed in manifest,
+	// so readers of other process always find listed segment
+	if created {
+		if err := sl.saveManifest(); err != nil {
+			store.Close()
+			sl.segments = nil
+			return err
+		}
+	}
+
+	if sl.writable() {
+		sl.active = store
+	} else {
+		store.Close()
+	}
+
+	sl.readIdx = 0
+
+	return nil
+}
+
+func (sl *SegmentLog) loadManifest() error {
+	path := filepath.Join(sl.dir, segmentManifest)
+
+	// stat before read, so manifest replaced after read will be reloaded
+	if info, err := os.Stat(path); err == nil {
+		sl.manifestMod, sl.manifestSize = info.ModTime(), info.Size()
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		sl.segments = []*segment{}
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "read segment manifest failed")
+	}
+
+	if len(data) < manifestHeaderLen || !bytes.Equal(data[:4], manifestMagic[:]) {
+		return errors.Wrap(ErrInvalidHeader, "segment manifest magic mismatch")
+	}
+
+	if data[4] != manifestVersion {
+		return errors.Wrapf(ErrInvalidHeader, "segment manifest version %d", data[4])
+	}
+
+	data = data[manifestHeaderLen:]
+
+	if len(data)%manifestEntryLen != 0 {
+		return errors.Wrapf(ErrSizeMismatch, "segment manifest size %d", len(data))
+	}
+
+	entries := make([]*segment, 0, len(data)/manifestEntryLen)
+
+	for offset := 0; offset < len(data); offset += manifestEntryLen {
+		seg := segment{
+			base: binary.LittleEndian.Uint64(data[offset:]),
+			created: time.Unix(0, int64(
+				binary.LittleEndian.Uint64(data[offset+8:]),
+			)),
+			gen: binary.LittleEndian.Uint64(data[offset+16:]),
+		}
+
+		// records of compacted segment is loaded from its seqs
+		if count := len(entries); count > 0 && entries[count-1].gen == 0 {
+			pre := entries[count-1]
+			pre.records = seg.base - pre.base
+		}
+
+		entries = append(entries, &seg)
+	}
+
+	sl.segments = make([]*segment, 0, len(entries))
+
+	for idx, seg := range entries {
+		info, err := os.Stat(sl.segmentPath(seg))
+
+		switch {
+		case err == nil:
+			seg.size = info.Size()
+		case !os.IsNotExist(err):
+			return errors.Wrap(err, "stat segment failed")
+		case idx < len(entries)-1:
+			// segment moved away without updating manifest
+			slog.Warn(
+				"segment in manifest missing",
+				slog.String("dir", sl.dir),
+				slog.Uint64("base", seg.base),
+			)
+			continue
+		}
+
+		if seg.gen > 0 {
+			if seg.seqs, err = loadSeqs(sl.segmentPath(seg)); err != nil {
+				return err
+			}
+			seg.records = uint64(len(seg.seqs))
+		}
+
+		sl.segments = append(sl.segments, seg)
+	}
+
+	return nil
+}
+
+// saveManifest rewrites manifest with tmp file & rename,
+// so manifest won't be torn by crash
+func (sl *SegmentLog) saveManifest() error {
+	data := make([]byte, 0, manifestHeaderLen+len(sl.segments)*manifestEntryLen)
+	data = append(data, manifestMagic[:]...)
+	data = append(data, manifestVersion, 0, 0, 0)
+
+	for _, seg := range sl.segments {
+		data = binary.LittleEndian.AppendUint64(data, seg.base)
+		data = binary.LittleEndian.AppendUint64(data, uint64(seg.created.UnixNano()))
+		data = binary.LittleEndian.AppendUint64(data, seg.gen)
+	}
+
+	path := filepath.Join(sl.dir, segmentManifest)
+	tmp := path + ".tmp"
+
+	if err := WriteFileSync(tmp, data); err != nil {
+		return errors.Wrap(err, "write segment manifest failed")
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrap(err, "replace segment manifest failed")
+	}
+
+	return errors.Wrap(SyncDir(sl.dir), "sync segment dir failed")
+}
+
+func (sl *SegmentLog) Flush() error {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if sl.segments == nil {
+		return ErrFSAlreadyClosed
+	}
+
+	if sl.active != nil {
+		return sl.active.Flush()
+	}
+
+	return nil
+}
+
+func (sl *SegmentLog) Close() (err error) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if sl.segments == nil {
+		return ErrFSAlreadyClosed
+	}
+
+	if sl.reader != nil {
+		sl.reader.Close()
+		sl.reader = nil
+	}
+
+	if sl.active != nil {
+		err = sl.active.Close()
+		sl.active = nil
+	}
+
+	sl.segments = nil
+
+	return
+}
+
+// StartSequence get sequence of first record in log
+func (sl *SegmentLog) StartSequence() uint64 {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if len(sl.segments) == 0 {
+		return 0
+	}
+
+	return sl.segments[0].first()
+}
+
+// EndSequence get sequence which will be assigned to next write
+func (sl *SegmentLog) EndSequence() uint64 {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if len(sl.segments) == 0 {
+		return 0
+	}
+
+	return sl.segments[len(sl.segments)-1].end()
+}
+
+// Size get total size of all segments
+func (sl *SegmentLog) Size() (size int64) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	for _, seg := range sl.segments {
+		size += seg.size
+	}
+
+	return
+}
+
+func (sl *SegmentLog) Segments() []SegmentInfo {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	return sl.segmentInfos()
+}
+
+func (sl *SegmentLog) segmentInfos() []SegmentInfo {
+	result := make([]SegmentInfo, len(sl.segments))
+
+	for idx, seg := range sl.segments {
+		result[idx] = SegmentInfo{
+			Base:    seg.base,
+			End:     seg.end(),
+			Records: seg.records,
+			Size:    seg.size,
+			Created: seg.created,
+			Path:    sl.segmentPath(seg),
+		}
+	}
+
+	return result
+}
+
+func (sl *SegmentLog) shouldRoll() bool {
+	seg := sl.segments[len(sl.segments)-1]
+
+	if seg.records == 0 {
+		return false
+	}
+
+	return (sl.policy.MaxSize > 0 && seg.size >= sl.policy.MaxSize) ||
+		(sl.policy.MaxRecords > 0 && seg.records >= sl.policy.MaxRecords) ||
+		(sl.policy.MaxAge > 0 && time.Since(seg.created) >= sl.policy.MaxAge)
+}
+
+// Roll starts a new segment, such as new trading day or epoch,
+// rolling is skipped if active segment is empty
+func (sl *SegmentLog) Roll() error {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	return sl.roll()
+}
+
+func (sl *SegmentLog) roll() error {
+	if sl.active == nil {
+		return errors.Wrap(ErrInvalidMode, "can not roll readonly log")
+	}
+
+	last := sl.segments[len(sl.segments)-1]
+	if last.records == 0 {
+		return nil
+	}
+
+	if err := sl.active.Flush(); err != nil {
+		return errors.Wrap(err, "flush active segment failed")
+	}
+
+	seg := segment{
+		base:    last.end(),
+		created: time.Now(),
+	}
+	store := NewFileStore(sl.segmentPath(&seg), sl.opts...)
+
+	// new segment is opened before old one closed,
+	// so log keeps writing to old segment if rolling failed
+	if err := store.Open(os.O_WRONLY | os.O_CREATE | os.O_APPEND); err != nil {
+		return errors.Wrap(err, "create segment failed")
+	}
+	seg.size = store.Size()
+
+	sl.segments = append(sl.segments, &seg)
+
+	if err := sl.saveManifest(); err != nil {
+		sl.segments = sl.segments[:len(sl.segments)-1]
+		store.Close()
+		removeFile(sl.segmentPath(&seg))
+		return err
+	}
+
+	old := sl.active
+	sl.active = store
+
+	if err := old.Close(); err != nil {
+		return errors.Wrap(err, "close rolled segment failed")
+	}
+
+	slog.Info(
+		"segment rolled",
+		slog.String("dir", sl.dir),
+		slog.Uint64("base", seg.base),
+	)
+
+	return nil
+}
+
+func (sl *SegmentLog) Write(tid TID, data PersistentData) error {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if sl.active == nil {
+		return errors.Wrap(ErrInvalidMode, "can not write to readonly log")
+	}
+
+	if sl.shouldRoll() {
+		if err := sl.roll(); err != nil {
+			return err
+		}
+	}
+
+	if err := sl.active.Write(tid, data); err != nil {
+		return err
+	}
+
+	seg := sl.segments[len(sl.segments)-1]
+	seg.records = sl.active.Records()
+	seg.size = sl.active.Size()
+
+	return nil
+}
+
+// Read reads records sequentially from first segment
+func (sl *SegmentLog) Read() (PersistentData, error) {
+	_, v, err := sl.ReadNext()
+
+	return v, err
+}
+
+// Seek moves read position to record with sequence seq,
+// seq equals to EndSequence moves to log end, seq removed
+// by compaction moves to next record after it
+func (sl *SegmentLog) Seek(seq uint64) error {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	_, err := sl.seek(seq)
+
+	return err
+}
+
+func (sl *SegmentLog) seek(seq uint64) (bool, error) {
+	if !sl.readable() {
+		return false, errors.Wrap(ErrInvalidMode, "can not seek write only log")
+	}
+
+	idx := sort.Search(len(sl.segments), func(i int) bool {
+		return sl.segments[i].base > seq
+	}) - 1
+
+	if idx < 0 {
+		return false, errors.Wrapf(ErrSeekOutOfRange, "seq[%d] before first segment", seq)
+	}
+
+	seg := sl.segments[idx]
+	if seg.seqs == nil && seq > seg.base+seg.records {
+		return false, errors.Wrapf(ErrSeekOutOfRange, "seq[%d] not in segment %d", seq, seg.base)
+	}
+
+	pos, found := seg.position(seq)
+
+	// removed records at tail of compacted segment
+	if pos == seg.records && idx < len(sl.segments)-1 {
+		idx++
+		seg, pos = sl.segments[idx], 0
+	}
+
+	if sl.reader != nil && sl.readIdx == idx && sl.readPos == pos {
+		return found, nil
+	}
+
+	// reopen reader for another segment or records appended after opened
+	if sl.reader != nil && (sl.readIdx != idx || pos > sl.reader.Records()) {
+		sl.reader.Close()
+		sl.reader = nil
+	}
+
+	if sl.reader == nil {
+		sl.reader = NewFileStore(sl.segmentPath(seg), sl.opts...)
+
+		if err := sl.reader.Open(os.O_RDONLY); err != nil {
+			sl.reader = nil
+			return false, errors.Wrap(err, "open segment failed")
+		}
+	}
+
+	sl.readIdx = idx
+
+	if err := sl.reader.Seek(pos); err != nil {
+		sl.reader.Close()
+		sl.reader = nil
+		return false, err
+	}
+
+	sl.readPos = pos
+
+	return found, nil
+}
+
+// ReadAt reads record with sequence seq, following Read
+// continues from next sequence
+func (sl *SegmentLog) ReadAt(seq uint64) (PersistentData, error) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	found, err := sl.seek(seq)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, errors.Wrapf(ErrSeekOutOfRange, "seq[%d] removed by compaction", seq)
+	}
+
+	_, v, err := sl.readNext()
+
+	return v, err
+}
+
+// ReadNext is same as Read, also returns record's sequence,
+// sequence may not be continuous if segments removed or compacted
+func (sl *SegmentLog) ReadNext() (uint64, PersistentData, error) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	return sl.readNext()
+}
+
+func (sl *SegmentLog) readNext() (uint64, PersistentData, error) {
+	if !sl.readable() {
+		return 0, nil, errors.Wrap(ErrInvalidMode, "can not read from write only log")
+	}
+
+	for {
+		if sl.readIdx >= len(sl.segments) {
+			return 0, nil, io.EOF
+		}
+
+		seg := sl.segments[sl.readIdx]
+
+		if sl.reader == nil {
+			sl.reader = NewFileStore(sl.segmentPath(seg), sl.opts...)
+
+			if err := sl.reader.Open(os.O_RDONLY); err != nil {
+				sl.reader = nil
+				return 0, nil, errors.Wrap(err, "open segment failed")
+			}
+
+			sl.readPos = 0
+		}
+
+		v, err := sl.reader.Read()
+
+		if errors.Is(err, io.EOF) && sl.readIdx < len(sl.segments)-1 {
+			sl.reader.Close()
+			sl.reader = nil
+			sl.readIdx++
+			continue
+		}
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if seg.seqs != nil && sl.readPos >= seg.records {
+			return 0, nil, errors.Wrapf(
+				ErrSizeMismatch, "compacted segment %d has more than %d records",
+				seg.base, seg.records,
+			)
+		}
+
+		seq := seg.sequence(sl.readPos)
+		sl.readPos++
+
+		// records appended after log opened
+		if sl.readPos > seg.records {
+			seg.records = sl.readPos
+		}
+
+		return seq, v, nil
+	}
+}
+
+// Tail is same as ReadNext, but waits until next record written,
+// returns ctx's error if ctx done. Rolled segments are followed,
+// segments of log written by other process are found from manifest
+func (sl *SegmentLog) Tail(ctx context.Context) (uint64, PersistentData, error) {
+	for {
+		seq, v, err := sl.tailNext()
+
+		if !errors.Is(err, io.EOF) {
+			return seq, v, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-time.After(sl.tailInterval):
+		}
+	}
+}
+
+func (sl *SegmentLog) tailNext() (uint64, PersistentData, error) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if sl.segments == nil {
+		return 0, nil, ErrFSAlreadyClosed
+	}
+
+	for {
+		seq, v, err := sl.readNext()
+
+		// segments of writable log are always up to date
+		if !errors.Is(err, io.EOF) || sl.writable() {
+			return seq, v, err
+		}
+
+		if changed, rErr := sl.refresh(); rErr != nil {
+			return 0, nil, rErr
+		} else if !changed {
+			return seq, v, err
+		}
+	}
+}
+
+// refresh reloads manifest if changed by other process,
+// read position is kept in same segment if it still exists
+func (sl *SegmentLog) refresh() (bool, error) {
+	info, err := os.Stat(filepath.Join(sl.dir, segmentManifest))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "stat segment manifest failed")
+	}
+
+	if info.ModTime().Equal(sl.manifestMod) && info.Size() == sl.manifestSize {
+		return false, nil
+	}
+
+	var curr *segment
+	if sl.readIdx < len(sl.segments) {
+		curr = sl.segments[sl.readIdx]
+	}
+
+	if err := sl.loadManifest(); err != nil {
+		return false, err
+	}
+
+	if curr == nil {
+		sl.readIdx = 0
+		return true, nil
+	}
+
+	idx := sort.Search(len(sl.segments), func(i int) bool {
+		return sl.segments[i].base >= curr.base
+	})
+
+	switch {
+	case idx < len(sl.segments) && sl.segments[idx].base == curr.base &&
+		sl.segments[idx].gen == curr.gen:
+		// records known by reader is more accurate for last segment
+		if sl.segments[idx].records < curr.records {
+			sl.segments[idx].records = curr.records
+		}
+	case sl.reader != nil:
+		// reading segment removed, continue from next segment
+		sl.reader.Close()
+		sl.reader = nil
+	}
+
+	if idx >= len(sl.segments) {
+		idx = len(sl.segments) - 1
+	}
+	sl.readIdx = max(idx, 0)
+
+	return true, nil
+}
+
+func (sl *SegmentLog) removeSegment(base uint64) (string, error) {
+	for idx, seg := range sl.segments {
+		if seg.base != base {
+			continue
+		}
+
+		if idx == len(sl.segments)-1 {
+			return "", errors.Wrapf(ErrSegmentActive, "segment %d", base)
+		}
+
+		sl.segments = append(sl.segments[:idx], sl.segments[idx+1:]...)
+
+		switch {
+		case sl.readIdx > idx:
+			sl.readIdx--
+		case sl.readIdx == idx && sl.reader != nil:
+			sl.reader.Close()
+			sl.reader = nil
+		}
+
+		if err := sl.saveManifest(); err != nil {
+			return "", err
+		}
+
+		return sl.segmentPath(seg), nil
+	}
+
+	return "", errors.Wrapf(ErrSegmentNotFound, "segment %d", base)
+}
+
+// DeleteSegment removes segment from manifest and deletes its file,
+// active segment can not be deleted
+func (sl *SegmentLog) DeleteSegment(base uint64) error {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	path, err := sl.removeSegment(base)
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(removeFile(path), "delete segment failed")
+}
+
+// RemoveOldest removes oldest segments in one manifest update, count of
+// segments to remove is selected from all segments in log's lock,
+// active segment is never removed. Removed segments are returned.
+func (sl *SegmentLog) RemoveOldest(selector func([]SegmentInfo) int) ([]SegmentInfo, error) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if sl.segments == nil {
+		return nil, ErrFSAlreadyClosed
+	}
+
+	if len(sl.segments) < 2 {
+		return nil, nil
+	}
+
+	infos := sl.segmentInfos()
+
+	count := min(selector(infos), len(sl.segments)-1)
+	if count <= 0 {
+		return nil, nil
+	}
+
+	old := sl.segments
+	sl.segments = append([]*segment{}, old[count:]...)
+
+	if err := sl.saveManifest(); err != nil {
+		sl.segments = old
+		return nil, err
+	}
+
+	if sl.readIdx < count {
+		if sl.reader != nil {
+			sl.reader.Close()
+			sl.reader = nil
+		}
+		sl.readIdx = 0
+	} else {
+		sl.readIdx -= count
+	}
+
+	for _, seg := range old[:count] {
+		if err := removeFile(sl.segmentPath(seg)); err != nil {
+			return infos[:count], errors.Wrapf(err, "delete segment %d failed", seg.base)
+		}
+	}
+
+	return infos[:count], nil
+}
+
+// ArchiveSegment removes segment from manifest and moves its file to dir
+func (sl *SegmentLog) ArchiveSegment(base uint64, dir string) (string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", errors.Wrap(err, "create archive dir failed")
+	}
+
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	path, err := sl.removeSegment(base)
+	if err != nil {
+		return "", err
+	}
+
+	dst := filepath.Join(dir, filepath.Base(path))
+
+	if err := os.Rename(path, dst); err != nil {
+		return "", errors.Wrap(err, "archive segment failed")
+	}
+
+	// index can be rebuilt from segment, so it's moved only if exists
+	if err := os.Rename(indexPath(path), indexPath(dst)); err != nil && !os.IsNotExist(err) {
+		return dst, errors.Wrap(err, "archive segment index failed")
+	}
+
+	// only compacted segment has seqs
+	if err := os.Rename(path+seqExt, dst+seqExt); err != nil && !os.IsNotExist(err) {
+		return dst, errors.Wrap(err, "archive segment seqs failed")
+	}
+
+	return dst, nil
+}
+
+// removeFile removes data file with its sidecars, missing file is ignored
+func removeFile(path string) error {
+	for _, name := range []string{path, indexPath(path), path + seqExt} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func loadSeqs(path string) ([]uint64, error) {
+	data, err := os.ReadFile(path + seqExt)
+	if err != nil {
+		return nil, errors.Wrap(err, "read segment seqs failed")
+	}
+
+	if len(data)%8 != 0 {
+		return nil, errors.Wrapf(ErrSizeMismatch, "segment seqs size %d", len(data))
+	}
+
+	seqs := make([]uint64, len(data)/8)
+
+	for idx := range seqs {
+		seqs[idx] = binary.LittleEndian.Uint64(data[idx*8:])
+	}
+
+	return seqs, nil
+}
+
+func saveSeqs(path string, seqs []uint64) error {
+	data := make([]byte, 0, len(seqs)*8)
+
+	for _, seq := range seqs {
+		data = binary.LittleEndian.AppendUint64(data, seq)
+	}
+
+	return errors.Wrap(WriteFileSync(path+seqExt, data), "write segment seqs failed")
+}
+
+// WriteFileSync writes data to file and syncs it to disk
+func WriteFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+
+	if cErr := file.Close(); err == nil {
+		err = cErr
+	}
+
+	return err
+}
+
+// SyncDir syncs dir, so files created or renamed in it survive crash
+func SyncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
@@ -0,0 +1,31 @@
+package chanio
+
+import "time"
+
+const defaultTailInterval = time.Millisecond * 100
+
+type storeOptions struct {
+	indexInterval uint64
+	tailInterval  time.Duration
+}
+
+type StoreOption func(*storeOptions)
+
+// WithIndexInterval writes offset index every n records,
+// smaller n makes Seek faster with larger index file
+func WithIndexInterval(n uint64) StoreOption {
+	return func(opts *storeOptions) {
+		if n > 0 {
+			opts.indexInterval = n
+		}
+	}
+}
+
+// WithTailInterval sets interval of polling new records while tailing
+func WithTailInterval(d time.Duration) StoreOption {
+	return func(opts *storeOptions) {
+		if d > 0 {
+			opts.tailInterval = d
+		}
+	}
+}
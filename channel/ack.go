@@ -0,0 +1,166 @@
+package channel
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultAckTimeout  = 30 * time.Second
+	defaultMaxInflight = 1024
+)
+
+// Delivery is data delivered with its seq in channel, in ack mode
+// Seq should be acked after data processed, or data
+// will be redelivered after ack timeout
+type Delivery[T any] struct {
+	Seq  uint64
+	Data T
+}
+
+type inflight[T any] struct {
+	data     T
+	deadline time.Time
+}
+
+// sendAck waits for inflight slot before sending, so subscriber's queue
+// fills and slow policy applies if too many data unacked,
+// expired data is still redelivered while waiting
+func (sub *sub[T]) sendAck(seq uint64, v T) bool {
+	for waiting := true; waiting; {
+		select {
+		case <-sub.done:
+			return false
+		case <-sub.redeliverTick:
+			if !sub.redeliver() {
+				return false
+			}
+		case sub.slots <- struct{}{}:
+			waiting = false
+		}
+	}
+
+	sub.ackLock.Lock()
+	sub.inflight[seq] = &inflight[T]{
+		data:     v,
+		deadline: time.Now().Add(sub.ackTimeout),
+	}
+	sub.unacked = append(sub.unacked, seq)
+	sub.sentSeq = seq + 1
+	sub.ackLock.Unlock()
+
+	select {
+	case <-sub.done:
+		return false
+	case sub.deliveries <- Delivery[T]{Seq: seq, Data: v}:
+		return true
+	}
+}
+
+// redeliver sends unacked data which ack timeout expired again
+func (sub *sub[T]) redeliver() bool {
+	now := time.Now()
+	expired := []Delivery[T]{}
+
+	sub.ackLock.Lock()
+	for _, seq := range sub.unacked {
+		if data, exist := sub.inflight[seq]; exist && now.After(data.deadline) {
+			data.deadline = now.Add(sub.ackTimeout)
+			expired = append(expired, Delivery[T]{Seq: seq, Data: data.data})
+		}
+	}
+	sub.ackLock.Unlock()
+
+	for _, v := range expired {
+		select {
+		case <-sub.done:
+			return false
+		case sub.deliveries <- v:
+		}
+	}
+
+	return true
+}
+
+// ack removes seq from inflight data, committed offset moves to
+// the first unacked seq, or next seq if all data acked, and is
+// persisted by committer if subscriber has commit
+func (sub *sub[T]) ack(seq uint64) error {
+	sub.ackLock.Lock()
+
+	if _, exist := sub.inflight[seq]; !exist {
+		sub.ackLock.Unlock()
+		return errors.Wrapf(ErrInvalidAck, "seq[%d] not inflight", seq)
+	}
+
+	delete(sub.inflight, seq)
+	<-sub.slots
+
+	for len(sub.unacked) > 0 {
+		if _, exist := sub.inflight[sub.unacked[0]]; exist {
+			break
+		}
+
+		sub.unacked = sub.unacked[1:]
+	}
+
+	committed := sub.sentSeq
+	if len(sub.unacked) > 0 {
+		committed = sub.unacked[0]
+	}
+
+	advanced := committed > sub.offset.Load()
+	if advanced {
+		sub.offset.Store(committed)
+	}
+
+	sub.ackLock.Unlock()
+
+	if advanced && sub.commit != nil {
+		select {
+		case sub.commitReq <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// committer persists committed offset after acked until stop closed,
+// acks arrived while committing are batched into next commit,
+// so offset is persisted asynchronously & at least once per batch
+func (sub *sub[T]) committer(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	for {
+		select {
+		case <-stop:
+			sub.persist()
+			return
+		case <-sub.commitReq:
+			sub.persist()
+		}
+	}
+}
+
+// persist commits latest offset, only called by committer
+func (sub *sub[T]) persist() {
+	committed := sub.offset.Load()
+	if committed <= sub.persisted {
+		return
+	}
+
+	if err := sub.commit(committed); err != nil {
+		slog.Error(
+			"commit subscriber offset failed",
+			slog.String("sub_id", sub.id.String()),
+			slog.Uint64("offset", committed),
+			slog.Any("error", err),
+		)
+		return
+	}
+
+	sub.persisted = committed
+}
@@ -3,16 +3,25 @@ package channel
 import (
 	"context"
 
+	"github.com/frozenpine/msgqueue/chanio"
 	"github.com/frozenpine/msgqueue/core"
+	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
 )
 
 const (
 	defaultChanSize = 1
+	// defaultQueueSize is size of subscriber's queue
+	// if channel's buffer size not set
+	defaultQueueSize = 128
 )
 
 var (
-	ErrChanClosed = errors.New("channel closed")
+	ErrChanClosed    = errors.New("channel closed")
+	ErrNotPersistent = chanio.ErrNotPersistent
+	ErrNoFlowDir     = errors.New("flow dir missing")
+	ErrInvalidFilter = errors.New("invalid filter")
+	ErrInvalidAck    = errors.New("invalid ack")
 
 	ChannelTypeKey = "HubType"
 )
@@ -25,6 +34,22 @@ type Channel[T any] interface {
 	core.Downstream[T]
 }
 
+// OptionConsumer is consumer subscribing with options
+type OptionConsumer[T any] interface {
+	SubscribeWith(name string, resumeType core.ResumeType, opts ...SubOption) (uuid.UUID, <-chan T, error)
+}
+
+// SeqConsumer is consumer delivering data with its seq in channel
+type SeqConsumer[T any] interface {
+	SubscribeSeq(name string, resumeType core.ResumeType, opts ...SubOption) (uuid.UUID, <-chan Delivery[T], error)
+}
+
+// AckConsumer is consumer delivering data to be acked by its seq
+type AckConsumer[T any] interface {
+	SubscribeAck(name string, resumeType core.ResumeType, opts ...SubOption) (uuid.UUID, <-chan Delivery[T], error)
+	Ack(subID uuid.UUID, seq uint64) error
+}
+
 func NewChannel[T any](ctx context.Context, name string, bufSize int) (Channel[T], error) {
 	var typ core.Type = core.Memory
 
@@ -39,6 +64,17 @@ func NewChannel[T any](ctx context.Context, name string, bufSize int) (Channel[T
 	switch typ {
 	case core.Memory:
 		return NewMemoChannel[T](ctx, name, bufSize), nil
+	case core.Persistent:
+		dir, _ := ctx.Value(core.CtxFlowDir).(string)
+		if dir == "" {
+			return nil, ErrNoFlowDir
+		}
+
+		if ch, err := NewPersistentChannel[T](ctx, name, dir, bufSize); err != nil {
+			return nil, err
+		} else {
+			return ch, nil
+		}
 	}
 
 	return nil, core.ErrInvalidType
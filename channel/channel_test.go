@@ -2,13 +2,17 @@ package channel_test
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"strconv"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/frozenpine/msgqueue/chanio"
 	"github.com/frozenpine/msgqueue/channel"
 	"github.com/frozenpine/msgqueue/core"
+	"github.com/gofrs/uuid"
 )
 
 func TestChanType(t *testing.T) {
@@ -47,7 +51,12 @@ func TestMemoCh(t *testing.T) {
 
 	for idx := 0; idx < subCount; idx++ {
 		go func(seq int) {
-			subID, subCh := ch.Subscribe(strconv.Itoa(seq), core.Quick)
+			subID, subCh, err := ch.Subscribe(strconv.Itoa(seq), core.Quick)
+			if err != nil {
+				t.Errorf("subscriber[%d] subscribe failed: %+v", seq, err)
+				wg.Done()
+				return
+			}
 
 			defer func() {
 				if err := ch.UnSubscribe(subID); err != nil {
@@ -94,3 +103,673 @@ func TestMemoCh(t *testing.T) {
 	t.Log("waiting for subscriber exit")
 	wg.Wait()
 }
+
+type Int struct {
+	int
+}
+
+func (v Int) Serialize() []byte {
+	result := make([]byte, 4)
+
+	binary.LittleEndian.PutUint32(result, uint32(v.int))
+
+	return result
+}
+
+func (v *Int) Deserialize(data []byte) error {
+	v.int = int(binary.LittleEndian.Uint32(data))
+	return nil
+}
+
+func TestResumeType(t *testing.T) {
+	memoCh := channel.NewMemoChannel[int](context.TODO(), "", 1)
+	defer memoCh.Release()
+
+	for _, typ := range []core.ResumeType{core.Restart, core.Resume} {
+		if _, _, err := memoCh.Subscribe("memo", typ); !errors.Is(err, core.ErrNoHistory) {
+			t.Fatal("memo channel should have no history:", err)
+		}
+	}
+
+	if _, _, err := memoCh.Subscribe("memo", 100); !errors.Is(err, core.ErrInvalidResumeType) {
+		t.Fatal("resume type should be invalid:", err)
+	}
+
+	chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	ch, err := channel.NewPersistentChannel[*Int](context.TODO(), "", t.TempDir(), 10)
+	if err != nil {
+		t.Fatal("create persistent channel failed:", err)
+	}
+
+	publish := func(from, to int) {
+		for idx := from; idx < to; idx++ {
+			if err := ch.Publish(&Int{idx}, -1); err != nil {
+				t.Fatal("publish failed:", err)
+			}
+		}
+	}
+
+	receive := func(data <-chan *Int, from, to int) {
+		for idx := from; idx < to; idx++ {
+			select {
+			case v := <-data:
+				if v.int != idx {
+					t.Fatalf("data mismatch: %d %d", v.int, idx)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("receive timeout:", idx)
+			}
+		}
+	}
+
+	publish(0, 10)
+
+	subID, data, err := ch.Subscribe("resume", core.Restart)
+	if err != nil {
+		t.Fatal("restart failed:", err)
+	}
+	receive(data, 0, 10)
+
+	if err := ch.UnSubscribe(subID); err != nil {
+		t.Fatal("unsubscribe failed:", err)
+	}
+
+	publish(10, 15)
+
+	if _, data, err = ch.Subscribe("resume", core.Resume); err != nil {
+		t.Fatal("resume failed:", err)
+	}
+	receive(data, 10, 15)
+
+	_, quick, err := ch.Subscribe("quick", core.Quick)
+	if err != nil {
+		t.Fatal("quick failed:", err)
+	}
+
+	publish(15, 20)
+	receive(data, 15, 20)
+	receive(quick, 15, 20)
+
+	// unsubscribe in replay stops history reader,
+	// and resumes from the first undelivered data
+	subID, replay, err := ch.Subscribe("replay", core.Restart)
+	if err != nil {
+		t.Fatal("restart failed:", err)
+	}
+	receive(replay, 0, 5)
+
+	if err := ch.UnSubscribe(subID); err != nil {
+		t.Fatal("unsubscribe in replay failed:", err)
+	}
+
+	if _, replay, err = ch.Subscribe("replay", core.Resume); err != nil {
+		t.Fatal("resume replay failed:", err)
+	}
+	receive(replay, 5, 20)
+
+	ch.Release()
+	ch.Join()
+}
+
+func TestSlowPolicy(t *testing.T) {
+	ctx := context.WithValue(context.TODO(), core.CtxSlowPolicy, channel.Block)
+	ch := channel.NewMemoChannel[int](ctx, "", 2)
+
+	vCount := 10
+	// data in subscriber's queue and the one being delivered
+	buffered := 3
+	noWait := channel.WithSlowTimeout(0)
+
+	_, blockData, _ := ch.Subscribe("block", core.Quick)
+	newestID, newestData, _ := ch.SubscribeWith(
+		"newest", core.Quick,
+		channel.WithSlowPolicy(channel.DropNewest), noWait,
+	)
+	oldestID, oldestData, _ := ch.SubscribeWith(
+		"oldest", core.Quick, channel.WithSlowPolicy(channel.DropOldest),
+	)
+	disconnectID, disconnectData, _ := ch.SubscribeWith(
+		"disconnect", core.Quick,
+		channel.WithSlowPolicy(channel.Disconnect), noWait,
+	)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for idx := 0; idx < vCount; idx++ {
+			if v := <-blockData; v != idx {
+				t.Errorf("block data mismatch: %d %d", v, idx)
+				return
+			}
+		}
+	}()
+
+	for idx := 0; idx < vCount; idx++ {
+		if err := ch.Publish(idx, -1); err != nil {
+			t.Fatal("publish failed:", err)
+		}
+	}
+
+	wg.Wait()
+
+	waitDropped := func(subID uuid.UUID, count uint64) {
+		deadline := time.After(time.Second)
+
+		for {
+			if dropped, _ := ch.Dropped(subID); dropped == count {
+				return
+			}
+
+			select {
+			case <-deadline:
+				dropped, err := ch.Dropped(subID)
+				t.Fatalf("dropped count mismatch: %d %d %v", dropped, count, err)
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+
+	waitDropped(newestID, uint64(vCount-buffered))
+	waitDropped(oldestID, uint64(vCount-buffered))
+
+	receive := func(data <-chan int) []int {
+		values := make([]int, buffered)
+
+		for idx := range values {
+			values[idx] = <-data
+
+			if idx > 0 && values[idx] <= values[idx-1] {
+				t.Fatal("data out of order:", values)
+			}
+		}
+
+		return values
+	}
+
+	if values := receive(newestData); values[0] != 0 {
+		t.Fatal("drop newest should keep first data:", values)
+	}
+
+	if values := receive(oldestData); values[buffered-1] != vCount-1 {
+		t.Fatal("drop oldest should keep last data:", values)
+	}
+
+	// data not delivered will be discarded after disconnected
+	for range disconnectData {
+	}
+
+	if _, err := ch.Dropped(disconnectID); !errors.Is(err, core.ErrNoSubcriber) {
+		t.Fatal("disconnected subscriber should be removed:", err)
+	}
+
+	ch.Release()
+	ch.Join()
+}
+
+func TestMaxBlock(t *testing.T) {
+	ctx := context.WithValue(context.TODO(), core.CtxSlowPolicy, channel.Block)
+	ch := channel.NewMemoChannel[int](ctx, "", 2)
+
+	subID, data, _ := ch.SubscribeWith(
+		"block", core.Quick, channel.WithMaxBlock(50*time.Millisecond),
+	)
+
+	// 0 being delivered, 1 & 2 queued, dispatcher blocked by 3
+	for idx := 0; idx < 4; idx++ {
+		if err := ch.Publish(idx, -1); err != nil {
+			t.Fatal("publish failed:", err)
+		}
+	}
+
+	// blocked subscriber disconnected after max block
+	deadline := time.After(time.Second)
+	for {
+		if _, err := ch.Dropped(subID); errors.Is(err, core.ErrNoSubcriber) {
+			break
+		}
+
+		select {
+		case <-deadline:
+			t.Fatal("blocked subscriber not disconnected")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	for range data {
+	}
+
+	ch.Release()
+	ch.Join()
+}
+
+func benchmarkFanOut(b *testing.B, slowSubs int) {
+	ctx := context.WithValue(context.TODO(), core.CtxSlowPolicy, channel.Block)
+	ch := channel.NewMemoChannel[int](ctx, "", 1024)
+
+	fastSubs := 4
+	wg := sync.WaitGroup{}
+
+	for idx := 0; idx < fastSubs; idx++ {
+		_, data, _ := ch.Subscribe("fast"+strconv.Itoa(idx), core.Quick)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for count := 0; count < b.N; count++ {
+				<-data
+			}
+		}()
+	}
+
+	for idx := 0; idx < slowSubs; idx++ {
+		_, data, _ := ch.SubscribeWith(
+			"slow"+strconv.Itoa(idx), core.Quick,
+			channel.WithSlowPolicy(channel.DropOldest),
+		)
+
+		go func() {
+			for range data {
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+
+	b.ResetTimer()
+
+	for idx := 0; idx < b.N; idx++ {
+		if err := ch.Publish(idx, -1); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	wg.Wait()
+
+	b.StopTimer()
+
+	ch.Release()
+	ch.Join()
+}
+
+func BenchmarkFanOut(b *testing.B) {
+	b.Run("fast", func(b *testing.B) { benchmarkFanOut(b, 0) })
+	b.Run("slow", func(b *testing.B) { benchmarkFanOut(b, 1) })
+}
+
+func TestSubOptions(t *testing.T) {
+	memoCh := channel.NewMemoChannel[int](context.TODO(), "", 1)
+
+	if _, _, err := memoCh.SubscribeWith(
+		"start", core.Quick, channel.WithStartSeq(0),
+	); !errors.Is(err, core.ErrNoHistory) {
+		t.Fatal("memo channel should have no history:", err)
+	}
+
+	if _, _, err := memoCh.SubscribeWith(
+		"filter", core.Quick, channel.WithFilter(func(v string) bool { return true }),
+	); !errors.Is(err, channel.ErrInvalidFilter) {
+		t.Fatal("filter type should be invalid:", err)
+	}
+
+	bufSize := 5
+	subID, _, err := memoCh.SubscribeWith(
+		"buffer", core.Quick,
+		channel.WithBufferSize(bufSize), channel.WithSlowTimeout(0),
+	)
+	if err != nil {
+		t.Fatal("subscribe with buffer size failed:", err)
+	}
+
+	for idx := 0; idx < 10; idx++ {
+		if err := memoCh.Publish(idx, -1); err != nil {
+			t.Fatal("publish failed:", err)
+		}
+	}
+
+	// data in subscriber's queue and the one being delivered
+	expectDropped := uint64(10 - bufSize - 1)
+	deadline := time.After(time.Second)
+
+	for dropped, _ := memoCh.Dropped(subID); dropped != expectDropped; dropped, _ = memoCh.Dropped(subID) {
+		select {
+		case <-deadline:
+			t.Fatalf("dropped count mismatch: %d %d", dropped, expectDropped)
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	memoCh.Release()
+
+	chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	ch, err := channel.NewPersistentChannel[*Int](context.TODO(), "", t.TempDir(), 10)
+	if err != nil {
+		t.Fatal("create persistent channel failed:", err)
+	}
+
+	for idx := 0; idx < 10; idx++ {
+		if err := ch.Publish(&Int{idx}, -1); err != nil {
+			t.Fatal("publish failed:", err)
+		}
+	}
+
+	_, data, err := ch.SubscribeWith(
+		"even", core.Quick,
+		channel.WithStartSeq(5),
+		channel.WithFilter(func(v *Int) bool { return v.int%2 == 0 }),
+	)
+	if err != nil {
+		t.Fatal("subscribe with options failed:", err)
+	}
+
+	for idx := 10; idx < 14; idx++ {
+		if err := ch.Publish(&Int{idx}, -1); err != nil {
+			t.Fatal("publish failed:", err)
+		}
+	}
+
+	for _, expect := range []int{6, 8, 10, 12} {
+		select {
+		case v := <-data:
+			if v.int != expect {
+				t.Fatalf("data mismatch: %d %d", v.int, expect)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("receive timeout:", expect)
+		}
+	}
+
+	ch.Release()
+	ch.Join()
+}
+
+func TestAckMode(t *testing.T) {
+	chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	dir := t.TempDir()
+	vCount := 5
+
+	ch, err := channel.NewPersistentChannel[*Int](context.TODO(), "", dir, 10)
+	if err != nil {
+		t.Fatal("create persistent channel failed:", err)
+	}
+
+	for idx := 0; idx < vCount; idx++ {
+		if err := ch.Publish(&Int{idx}, -1); err != nil {
+			t.Fatal("publish failed:", err)
+		}
+	}
+
+	normalID, _, _ := ch.Subscribe("normal", core.Quick)
+	if err := ch.Ack(normalID, 0); !errors.Is(err, channel.ErrInvalidAck) {
+		t.Fatal("ack should be invalid for normal subscriber:", err)
+	}
+	ch.UnSubscribe(normalID)
+
+	subID, deliveries, err := ch.SubscribeAck(
+		"ack", core.Restart, channel.WithAckTimeout(50*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal("subscribe ack failed:", err)
+	}
+
+	receive := func(deliveries <-chan channel.Delivery[*Int], seq uint64) {
+		select {
+		case d := <-deliveries:
+			if d.Seq != seq || d.Data.int != int(seq) {
+				t.Fatalf("delivery mismatch: %d %d %d", d.Seq, d.Data.int, seq)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("receive timeout:", seq)
+		}
+	}
+
+	for seq := 0; seq < vCount; seq++ {
+		receive(deliveries, uint64(seq))
+	}
+
+	for _, seq := range []uint64{0, 1, 3} {
+		if err := ch.Ack(subID, seq); err != nil {
+			t.Fatal("ack failed:", err)
+		}
+	}
+
+	if err := ch.Ack(subID, 1); !errors.Is(err, channel.ErrInvalidAck) {
+		t.Fatal("duplicated ack should be invalid:", err)
+	}
+
+	// unacked data redelivered after ack timeout
+	receive(deliveries, 2)
+	receive(deliveries, 4)
+
+	if err := ch.UnSubscribe(subID); err != nil {
+		t.Fatal("unsubscribe failed:", err)
+	}
+
+	ch.Release()
+	ch.Join()
+
+	// committed offset is the first unacked seq after restart
+	ch, err = channel.NewPersistentChannel[*Int](context.TODO(), "", dir, 10)
+	if err != nil {
+		t.Fatal("reopen persistent channel failed:", err)
+	}
+
+	subID, deliveries, err = ch.SubscribeAck("ack", core.Resume)
+	if err != nil {
+		t.Fatal("resume ack failed:", err)
+	}
+
+	for seq := 2; seq < vCount; seq++ {
+		receive(deliveries, uint64(seq))
+
+		if err := ch.Ack(subID, uint64(seq)); err != nil {
+			t.Fatal("ack failed:", err)
+		}
+	}
+
+	ch.Release()
+	ch.Join()
+}
+
+func TestMaxInflight(t *testing.T) {
+	ctx := context.WithValue(context.TODO(), core.CtxSlowPolicy, channel.Block)
+	ch := channel.NewMemoChannel[int](ctx, "", 10)
+
+	subID, deliveries, err := ch.SubscribeAck(
+		"inflight", core.Quick, channel.WithMaxInflight(2),
+	)
+	if err != nil {
+		t.Fatal("subscribe ack failed:", err)
+	}
+
+	for idx := 0; idx < 5; idx++ {
+		if err := ch.Publish(idx, -1); err != nil {
+			t.Fatal("publish failed:", err)
+		}
+	}
+
+	receive := func(seq uint64) {
+		select {
+		case d := <-deliveries:
+			if d.Seq != seq {
+				t.Fatalf("delivery mismatch: %d %d", d.Seq, seq)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("receive timeout:", seq)
+		}
+	}
+
+	receive(0)
+	receive(1)
+
+	select {
+	case d := <-deliveries:
+		t.Fatal("delivered over max inflight:", d.Seq)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := ch.Ack(subID, 1); err != nil {
+		t.Fatal("ack failed:", err)
+	}
+
+	receive(2)
+
+	if err := ch.Ack(subID, 0); err != nil {
+		t.Fatal("ack failed:", err)
+	}
+
+	receive(3)
+
+	ch.UnSubscribe(subID)
+	ch.Release()
+	ch.Join()
+}
+
+func TestDuplicateSubscriber(t *testing.T) {
+	ch := channel.NewMemoChannel[int](context.TODO(), "", 1)
+
+	subID, _, err := ch.Subscribe("dup", core.Quick)
+	if err != nil {
+		t.Fatal("subscribe failed:", err)
+	}
+
+	if _, _, err := ch.Subscribe("dup", core.Quick); !errors.Is(err, core.ErrAlreadySubscribed) {
+		t.Fatal("duplicated subscriber should fail:", err)
+	}
+
+	if _, _, err := ch.SubscribeSeq("dup", core.Quick); !errors.Is(err, core.ErrAlreadySubscribed) {
+		t.Fatal("duplicated seq subscriber should fail:", err)
+	}
+
+	if err := ch.UnSubscribe(subID); err != nil {
+		t.Fatal("unsubscribe failed:", err)
+	}
+
+	// name can be subscribed again after unsubscribed
+	if _, _, err := ch.Subscribe("dup", core.Quick); err != nil {
+		t.Fatal("subscribe again failed:", err)
+	}
+
+	ch.Release()
+	ch.Join()
+}
+
+func TestRedeliverInflightFull(t *testing.T) {
+	ctx := context.WithValue(context.TODO(), core.CtxSlowPolicy, channel.Block)
+	ch := channel.NewMemoChannel[int](ctx, "", 10)
+
+	subID, deliveries, err := ch.SubscribeAck(
+		"full", core.Quick,
+		channel.WithMaxInflight(2),
+		channel.WithAckTimeout(50*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal("subscribe ack failed:", err)
+	}
+
+	for idx := 0; idx < 5; idx++ {
+		if err := ch.Publish(idx, -1); err != nil {
+			t.Fatal("publish failed:", err)
+		}
+	}
+
+	receive := func(seq uint64) {
+		select {
+		case d := <-deliveries:
+			if d.Seq != seq {
+				t.Fatalf("delivery mismatch: %d %d", d.Seq, seq)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("receive timeout:", seq)
+		}
+	}
+
+	receive(0)
+	receive(1)
+
+	// inflight window is full and nothing acked,
+	// expired data still redelivered
+	redelivered := map[uint64]int{}
+	for len(redelivered) < 2 || redelivered[0] < 2 || redelivered[1] < 2 {
+		select {
+		case d := <-deliveries:
+			if d.Seq > 1 {
+				t.Fatal("delivered over max inflight:", d.Seq)
+			}
+			redelivered[d.Seq]++
+		case <-time.After(time.Second):
+			t.Fatal("redeliver timeout:", redelivered)
+		}
+	}
+
+	if err := ch.Ack(subID, 0); err != nil {
+		t.Fatal("ack failed:", err)
+	}
+
+	// seq 1 may be redelivered again before seq 2
+	for {
+		select {
+		case d := <-deliveries:
+			if d.Seq == 1 {
+				continue
+			}
+
+			if d.Seq != 2 {
+				t.Fatal("delivery mismatch:", d.Seq)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("receive timeout: 2")
+		}
+
+		break
+	}
+
+	ch.UnSubscribe(subID)
+	ch.Release()
+	ch.Join()
+}
+
+func TestDefaultQueue(t *testing.T) {
+	ch := channel.NewMemoChannel[int](context.TODO(), "", 0)
+
+	vCount := 100
+	subID, data, err := ch.Subscribe("default", core.Quick)
+	if err != nil {
+		t.Fatal("subscribe failed:", err)
+	}
+
+	// subscriber not reading while publishing loses nothing
+	for idx := 0; idx < vCount; idx++ {
+		if err := ch.Publish(idx, -1); err != nil {
+			t.Fatal("publish failed:", err)
+		}
+	}
+
+	for idx := 0; idx < vCount; idx++ {
+		select {
+		case v := <-data:
+			if v != idx {
+				t.Fatalf("data mismatch: %d %d", v, idx)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("receive timeout:", idx)
+		}
+	}
+
+	if dropped, _ := ch.Dropped(subID); dropped != 0 {
+		t.Fatal("default queue dropped data:", dropped)
+	}
+
+	ch.Release()
+	ch.Join()
+}
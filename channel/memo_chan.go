@@ -3,7 +3,9 @@ package channel
 import (
 	"context"
 	"log/slog"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/frozenpine/msgqueue/core"
@@ -11,17 +13,319 @@ import (
 	"github.com/pkg/errors"
 )
 
+type seqData[T any] struct {
+	seq  uint64
+	data T
+}
+
+// subMode decides how data is delivered to subscriber
+type subMode uint8
+
+const (
+	// modeData delivers data only
+	modeData subMode = iota
+	// modeSeq delivers data with its seq in channel
+	modeSeq
+	// modeAck delivers data with its seq, which should be acked
+	modeAck
+)
+
+// sub owns a bounded queue filled by channel's dispatcher and
+// a delivery goroutine moving data from queue to subscriber,
+// so a slow subscriber only fills its own queue
 type sub[T any] struct {
+	id   uuid.UUID
 	once sync.Once
 	data chan T
+
+	// queue is only written & closed by channel's dispatcher,
+	// slow policy is applied when queue is full
+	queue       chan seqData[T]
+	policy      SlowPolicy
+	slowTimeout time.Duration
+	maxBlock    time.Duration
+	dropped     atomic.Uint64
+	filter      func(T) bool
+
+	// offset is the next sequence to be delivered, or the committed
+	// sequence in ack mode, it's kept for subscriber resuming with same sub id
+	offset *atomic.Uint64
+
+	// data is delivered to deliveries in ack & seq mode, unacked data
+	// is kept inflight & redelivered after ack timeout in ack mode,
+	// slots bounds count of inflight data, redeliverTick
+	// is served while waiting for slots
+	mode          subMode
+	ackMode       bool
+	ackTimeout    time.Duration
+	deliveries    chan Delivery[T]
+	ackLock       sync.Mutex
+	slots         chan struct{}
+	redeliverTick <-chan time.Time
+	inflight      map[uint64]*inflight[T]
+	unacked       []uint64
+	sentSeq       uint64
+	commit        func(seq uint64) error
+	commitReq     chan struct{}
+	persisted     uint64
+
+	// live data will be cached in pending while
+	// subscriber is replaying from history
+	mu        sync.Mutex
+	replaying bool
+	closed    bool
+	pending   []seqData[T]
+	done      chan struct{}
+	exited    chan struct{}
 }
 
+func newSub[T any](offset *atomic.Uint64, opts subOptions, filter func(T) bool, mode subMode) *sub[T] {
+	sub := sub[T]{
+		queue:       make(chan seqData[T], opts.bufSize),
+		done:        make(chan struct{}),
+		exited:      make(chan struct{}),
+		offset:      offset,
+		policy:      opts.policy,
+		slowTimeout: opts.slowTimeout,
+		maxBlock:    opts.maxBlock,
+		filter:      filter,
+		mode:        mode,
+		ackMode:     mode == modeAck,
+		ackTimeout:  opts.ackTimeout,
+	}
+
+	switch mode {
+	case modeAck:
+		sub.deliveries = make(chan Delivery[T])
+		sub.inflight = make(map[uint64]*inflight[T])
+		sub.slots = make(chan struct{}, opts.maxInflight)
+		sub.commitReq = make(chan struct{}, 1)
+	case modeSeq:
+		sub.deliveries = make(chan Delivery[T])
+	default:
+		sub.data = make(chan T)
+	}
+
+	return &sub
+}
+
+// close stops subscriber immediately, data in queue will be discarded
 func (sub *sub[T]) close() {
-	sub.once.Do(func() { close(sub.data) })
+	sub.once.Do(func() {
+		sub.mu.Lock()
+		defer sub.mu.Unlock()
+
+		sub.closed = true
+		close(sub.done)
+	})
+}
+
+// finish closes queue, subscriber stops after all queued data delivered,
+// must be called by dispatcher
+func (sub *sub[T]) finish() {
+	close(sub.queue)
+}
+
+// cache returns true if v is cached or dropped
+// and needn't to be sent to queue
+func (sub *sub[T]) cache(seq uint64, v T) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.replaying {
+		sub.pending = append(sub.pending, seqData[T]{seq: seq, data: v})
+		return true
+	}
+
+	return sub.closed
+}
+
+func (sub *sub[T]) accept(v T) bool {
+	return sub.filter == nil || sub.filter(v)
+}
+
+func (sub *sub[T]) delivered(seq uint64) {
+	sub.offset.Store(seq + 1)
+}
+
+// enqueue puts live data to subscriber's queue according to
+// subscriber's slow policy, returns false if subscriber should
+// be disconnected
+func (sub *sub[T]) enqueue(seq uint64, v T) bool {
+	if !sub.accept(v) || sub.cache(seq, v) {
+		return true
+	}
+
+	item := seqData[T]{seq: seq, data: v}
+
+	switch sub.policy {
+	case Block:
+		select {
+		case sub.queue <- item:
+			return true
+		default:
+		}
+
+		// nil expired waits forever
+		var expired <-chan time.Time
+		if sub.maxBlock > 0 {
+			timer := time.NewTimer(sub.maxBlock)
+			defer timer.Stop()
+
+			expired = timer.C
+		}
+
+		select {
+		case <-sub.done:
+		case sub.queue <- item:
+		case <-expired:
+			sub.dropped.Add(1)
+			return false
+		}
+	case DropOldest:
+		for {
+			select {
+			case sub.queue <- item:
+				return true
+			default:
+			}
+
+			select {
+			case <-sub.queue:
+				sub.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case sub.queue <- item:
+			return true
+		default:
+		}
+
+		if sub.slowTimeout > 0 {
+			timer := time.NewTimer(sub.slowTimeout)
+			defer timer.Stop()
+
+			select {
+			case <-sub.done:
+				return true
+			case sub.queue <- item:
+				return true
+			case <-timer.C:
+			}
+		}
+
+		sub.dropped.Add(1)
+
+		return sub.policy != Disconnect
+	}
+
+	return true
 }
 
-func (sub *sub[T]) ch() <-chan T {
-	return sub.data
+func (sub *sub[T]) send(seq uint64, v T) bool {
+	switch sub.mode {
+	case modeAck:
+		return sub.sendAck(seq, v)
+	case modeSeq:
+		select {
+		case <-sub.done:
+			return false
+		case sub.deliveries <- Delivery[T]{Seq: seq, Data: v}:
+			sub.delivered(seq)
+			return true
+		}
+	}
+
+	select {
+	case <-sub.done:
+		return false
+	case sub.data <- v:
+		sub.delivered(seq)
+		return true
+	}
+}
+
+// replay send history & cached live data to subscriber,
+// switch subscriber to live mode after all data sent,
+// history reader stops by itself when subscriber closed
+func (sub *sub[T]) replay(history <-chan seqData[T]) bool {
+	for v := range history {
+		if sub.accept(v.data) && !sub.send(v.seq, v.data) {
+			return false
+		}
+	}
+
+	for {
+		sub.mu.Lock()
+
+		if len(sub.pending) == 0 {
+			sub.replaying = false
+			sub.mu.Unlock()
+			return true
+		}
+
+		pending := sub.pending
+		sub.pending = nil
+		sub.mu.Unlock()
+
+		for _, v := range pending {
+			if !sub.send(v.seq, v.data) {
+				return false
+			}
+		}
+	}
+}
+
+// run is subscriber's delivery goroutine, history is nil
+// if subscriber starts from live data
+func (sub *sub[T]) run(history <-chan seqData[T]) {
+	defer func() {
+		if sub.deliveries != nil {
+			close(sub.deliveries)
+		} else {
+			close(sub.data)
+		}
+		close(sub.exited)
+	}()
+
+	if sub.ackMode {
+		ticker := time.NewTicker(sub.ackTimeout / 2)
+		defer ticker.Stop()
+
+		sub.redeliverTick = ticker.C
+	}
+
+	if sub.commit != nil {
+		stop, committed := make(chan struct{}), make(chan struct{})
+		go sub.committer(stop, committed)
+
+		// offset acked before exit is persisted before exited closed
+		defer func() {
+			close(stop)
+			<-committed
+		}()
+	}
+
+	if history != nil && !sub.replay(history) {
+		return
+	}
+
+	for {
+		select {
+		case <-sub.done:
+			return
+		case <-sub.redeliverTick:
+			if !sub.redeliver() {
+				return
+			}
+		case v, ok := <-sub.queue:
+			if !ok || !sub.send(v.seq, v.data) {
+				return
+			}
+		}
+	}
 }
 
 type MemoChannel[T any] struct {
@@ -35,8 +339,24 @@ type MemoChannel[T any] struct {
 
 	chanLen int
 
+	// slowPolicy is the default policy of subscribers
+	slowPolicy SlowPolicy
+
+	// history is nil for memory channel
+	history      history[T]
+	dispatchLock sync.Mutex
+	seq          uint64
+	// dispatching is subscribers taken in dispatch lock,
+	// data is enqueued to them out of lock by dispatcher
+	dispatching []*sub[T]
+
+	// sub id => *atomic.Uint64, offset kept after unsubscribe if channel
+	// has history, so subscriber can resume with same name
+	subOffsets sync.Map
+
 	input        chan T
 	waitInfinite <-chan time.Time
+	dispatchDone chan struct{}
 
 	subscriberCache sync.Map
 	subscriberWg    sync.WaitGroup
@@ -47,10 +367,6 @@ type MemoChannel[T any] struct {
 func NewMemoChannel[T any](ctx context.Context, name string, bufSize int) *MemoChannel[T] {
 	channel := MemoChannel[T]{}
 
-	if bufSize <= 0 {
-		bufSize = defaultChanSize
-	}
-
 	channel.Init(ctx, name, func() {
 		channel.chanLen = bufSize
 	})
@@ -73,6 +389,11 @@ func (ch *MemoChannel[T]) Init(ctx context.Context, name string, extraInit func(
 		ch.id = core.GenID(ch.name)
 		ch.input = ch.makeChan()
 		ch.waitInfinite = make(chan time.Time)
+		ch.dispatchDone = make(chan struct{})
+
+		if policy, ok := ctx.Value(core.CtxSlowPolicy).(SlowPolicy); ok {
+			ch.slowPolicy = policy
+		}
 
 		if extraInit != nil {
 			extraInit()
@@ -152,11 +473,11 @@ func (ch *MemoChannel[T]) disconnectUpstream() {
 }
 
 func (ch *MemoChannel[T]) closeSubs() {
+	ch.dispatchLock.Lock()
+	defer ch.dispatchLock.Unlock()
+
 	ch.subscriberCache.Range(func(subscriber, subData any) bool {
-		defer func() {
-			ch.subscriberCache.Delete(subscriber)
-			ch.subscriberWg.Done()
-		}()
+		defer ch.subscriberCache.Delete(subscriber)
 
 		if pubCh, ok := subData.(*sub[T]); ok {
 			slog.Info(
@@ -164,28 +485,46 @@ func (ch *MemoChannel[T]) closeSubs() {
 				slog.Any("sub", subscriber),
 			)
 
-			pubCh.close()
+			pubCh.finish()
 		}
 
 		return true
 	})
 }
 
+// Join waits until channel closed and all subscribers
+// received remaining data or unsubscribed
 func (ch *MemoChannel[T]) Join() {
 	<-ch.runCtx.Done()
+	<-ch.dispatchDone
 
 	ch.subscriberWg.Wait()
 }
 
-func (ch *MemoChannel[T]) makeChan() chan T {
+func (ch *MemoChannel[T]) subChanLen() int {
 	if ch.chanLen > 0 {
-		return make(chan T, ch.chanLen)
+		return ch.chanLen
 	}
 
-	return make(chan T, defaultChanSize)
+	return defaultChanSize
+}
+
+// queueLen is default size of subscriber's queue
+func (ch *MemoChannel[T]) queueLen() int {
+	if ch.chanLen > 0 {
+		return ch.chanLen
+	}
+
+	return defaultQueueSize
+}
+
+func (ch *MemoChannel[T]) makeChan() chan T {
+	return make(chan T, ch.subChanLen())
 }
 
 func (ch *MemoChannel[T]) inputDispatcher() {
+	defer close(ch.dispatchDone)
+
 	for {
 		select {
 		case <-ch.runCtx.Done():
@@ -193,48 +532,269 @@ func (ch *MemoChannel[T]) inputDispatcher() {
 		case v, ok := <-ch.input:
 			if !ok {
 				ch.closeSubs()
+				ch.closeHistory()
 				return
 			}
 
-			ch.subscriberCache.Range(func(subcriber, subData any) bool {
-				sub := subData.(*sub[T]).data
+			ch.dispatch(v)
+		}
+	}
+}
 
-				select {
-				case <-ch.timeout(500 * time.Millisecond):
-					slog.Warn(
-						"publish timeout to subscriber",
-						slog.Any("subscriber", subcriber),
-					)
-				case sub <- v:
-				}
+func (ch *MemoChannel[T]) dispatch(v T) {
+	ch.dispatchLock.Lock()
 
-				return true
-			})
+	seq := ch.seq
+
+	if ch.history != nil {
+		var err error
+
+		if seq, err = ch.history.write(v); err != nil {
+			ch.dispatchLock.Unlock()
+
+			slog.Error(
+				"write channel history failed",
+				slog.Any("error", err),
+				slog.String("identity", core.QueueIdentity(ch)),
+			)
+			return
 		}
 	}
+
+	ch.seq = seq + 1
+
+	subs := ch.dispatching[:0]
+	ch.subscriberCache.Range(func(_, subData any) bool {
+		subs = append(subs, subData.(*sub[T]))
+		return true
+	})
+	ch.dispatching = subs
+
+	ch.dispatchLock.Unlock()
+
+	// subscribers created after unlock will replay data from history,
+	// so enqueue out of lock won't lose or duplicate data
+	for idx, sub := range subs {
+		if !sub.enqueue(seq, v) {
+			slog.Warn(
+				"disconnecting slow subscriber",
+				slog.String("sub_id", sub.id.String()),
+				slog.Uint64("dropped", sub.dropped.Load()),
+			)
+
+			ch.UnSubscribe(sub.id)
+		}
+
+		subs[idx] = nil
+	}
 }
 
-func (ch *MemoChannel[T]) Subscribe(name string, resumeType core.ResumeType) (uuid.UUID, <-chan T) {
+func (ch *MemoChannel[T]) closeHistory() {
+	if ch.history == nil {
+		return
+	}
+
+	if err := ch.history.close(); err != nil {
+		slog.Error(
+			"close channel history failed",
+			slog.Any("error", err),
+			slog.String("identity", core.QueueIdentity(ch)),
+		)
+	}
+}
+
+func (ch *MemoChannel[T]) subOffset(subID uuid.UUID) *atomic.Uint64 {
+	offset, _ := ch.subOffsets.LoadOrStore(subID, &atomic.Uint64{})
+
+	return offset.(*atomic.Uint64)
+}
+
+func (ch *MemoChannel[T]) Subscribe(name string, resumeType core.ResumeType) (uuid.UUID, <-chan T, error) {
+	return ch.SubscribeWith(name, resumeType)
+}
+
+// SubscribeWith create subscriber's data chan, resumeType decides the first data:
+// core.Quick starts from the live tail,
+// core.Restart replays from the first data retained in history,
+// core.Resume continues from the last delivered data of same subscriber name,
+// or from the first retained if subscriber has no delivery before.
+// Restart & Resume is only available for channel with history.
+// Subscriber name is unique in channel, core.ErrAlreadySubscribed is
+// returned if name subscribed, offset is kept after UnSubscribe so
+// subscriber can resume with same name.
+// Options can override slow policy, buffer size & start position of subscriber,
+// or only deliver data matching filter.
+func (ch *MemoChannel[T]) SubscribeWith(name string, resumeType core.ResumeType, opts ...SubOption) (uuid.UUID, <-chan T, error) {
+	subID, sub, err := ch.subscribe(name, resumeType, modeData, opts)
+	if err != nil {
+		return subID, nil, err
+	}
+
+	return subID, sub.data, nil
+}
+
+// SubscribeSeq is same as SubscribeWith, but delivers data with its seq
+// in channel, so same data has same seq for all subscribers.
+// Deliveries needn't to be acked.
+func (ch *MemoChannel[T]) SubscribeSeq(name string, resumeType core.ResumeType, opts ...SubOption) (uuid.UUID, <-chan Delivery[T], error) {
+	subID, sub, err := ch.subscribe(name, resumeType, modeSeq, opts)
+	if err != nil {
+		return subID, nil, err
+	}
+
+	return subID, sub.deliveries, nil
+}
+
+// SubscribeAck create subscriber in ack mode, each delivery should be acked
+// by Ack with delivery's seq, unacked data will be redelivered after ack timeout.
+// Offset of ack mode subscriber is the first unacked seq, which is used by
+// core.Resume and persisted in channel's history if any, acks are batched
+// and persisted asynchronously, offset is persisted when subscriber exits.
+func (ch *MemoChannel[T]) SubscribeAck(name string, resumeType core.ResumeType, opts ...SubOption) (uuid.UUID, <-chan Delivery[T], error) {
+	subID, sub, err := ch.subscribe(name, resumeType, modeAck, opts)
+	if err != nil {
+		return subID, nil, err
+	}
+
+	return subID, sub.deliveries, nil
+}
+
+// Ack acknowledges delivered seq of ack mode subscriber
+func (ch *MemoChannel[T]) Ack(subID uuid.UUID, seq uint64) error {
+	subData, subExist := ch.subscriberCache.Load(subID)
+
+	if !subExist {
+		return core.ErrNoSubcriber
+	}
+
+	sub := subData.(*sub[T])
+
+	if !sub.ackMode {
+		return errors.Wrap(ErrInvalidAck, "subscriber not in ack mode")
+	}
+
+	return sub.ack(seq)
+}
+
+func (ch *MemoChannel[T]) subscribe(name string, resumeType core.ResumeType, mode subMode, opts []SubOption) (uuid.UUID, *sub[T], error) {
+	options := subOptions{
+		policy:      ch.slowPolicy,
+		slowTimeout: defaultSlowTimeout,
+		bufSize:     ch.queueLen(),
+		ackTimeout:  defaultAckTimeout,
+		maxInflight: defaultMaxInflight,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	switch resumeType {
+	case core.Quick, core.Restart, core.Resume:
+	default:
+		return uuid.Nil, nil, errors.Wrapf(
+			core.ErrInvalidResumeType, "%d", resumeType,
+		)
+	}
+
+	replay := resumeType != core.Quick || options.hasStart
+
+	if replay && ch.history == nil {
+		return uuid.Nil, nil, errors.Wrapf(
+			core.ErrNoHistory, "%s", core.QueueIdentity(ch),
+		)
+	}
+
+	var filter func(T) bool
+
+	if options.filter != nil {
+		fn, ok := options.filter.(func(T) bool)
+		if !ok {
+			return uuid.Nil, nil, errors.Wrapf(
+				ErrInvalidFilter, "%T for %s",
+				options.filter, reflect.TypeFor[T]().String(),
+			)
+		}
+
+		filter = fn
+	}
+
 	subID := core.GenID(name)
+	newSub := newSub(ch.subOffset(subID), options, filter, mode)
+	newSub.id = subID
+
+	if mode == modeAck && ch.history != nil {
+		newSub.commit = func(seq uint64) error {
+			return ch.history.commit(subID, seq)
+		}
+	}
+
+	var history <-chan seqData[T]
+
+	// history reader & subscriber must be created in dispatch lock,
+	// so that no data will be lost or duplicated between history & live
+	ch.dispatchLock.Lock()
+
+	if ch.runCtx.Err() != nil {
+		ch.dispatchLock.Unlock()
+		return uuid.Nil, nil, ErrChanClosed
+	}
+
+	var from uint64
+
+	if replay {
+		from = ch.history.startSeq()
+
+		if options.hasStart {
+			from = options.startSeq
+		} else if offset := newSub.offset.Load(); resumeType == core.Resume && offset > from {
+			from = offset
+		}
 
-	subData, subExist := ch.subscriberCache.LoadOrStore(subID, &sub[T]{data: ch.makeChan()})
+		rd, err := ch.history.readFrom(newSub.done, from)
+		if err != nil {
+			ch.dispatchLock.Unlock()
+			return uuid.Nil, nil, errors.Wrap(err, "read channel history failed")
+		}
+
+		history = rd
+		newSub.replaying = true
+	}
+
+	_, subExist := ch.subscriberCache.LoadOrStore(subID, newSub)
+	if !subExist {
+		ch.subscriberWg.Add(1)
+	}
+
+	ch.dispatchLock.Unlock()
 
 	if subExist {
 		slog.Warn(
-			"channel exist for subscriber",
+			"duplicated subscriber rejected",
 			slog.String("name", name),
 			slog.String("sub_id", subID.String()),
 		)
-	} else {
-		ch.subscriberWg.Add(1)
-		slog.Info(
-			"new subscriber add",
-			slog.String("name", name),
-			slog.String("sub_id", subID.String()),
+
+		// stop history reader of rejected subscriber
+		newSub.close()
+
+		return uuid.Nil, nil, errors.Wrapf(
+			core.ErrAlreadySubscribed, "subscriber %q", name,
 		)
 	}
 
-	return subID, subData.(*sub[T]).ch()
+	slog.Info(
+		"new subscriber add",
+		slog.String("name", name),
+		slog.String("sub_id", subID.String()),
+	)
+
+	go func() {
+		defer ch.subscriberWg.Done()
+
+		newSub.run(history)
+	}()
+
+	return subID, newSub, nil
 }
 
 func (ch *MemoChannel[T]) UnSubscribe(subID uuid.UUID) error {
@@ -244,12 +804,32 @@ func (ch *MemoChannel[T]) UnSubscribe(subID uuid.UUID) error {
 		return core.ErrNoSubcriber
 	}
 
-	subData.(*sub[T]).close()
-	ch.subscriberWg.Done()
+	sub := subData.(*sub[T])
+	sub.close()
+
+	// wait delivery goroutine exit, so that offset is
+	// up to date for subscriber resuming with same name
+	<-sub.exited
+
+	// offset is only used for resuming from history
+	if ch.history == nil {
+		ch.subOffsets.Delete(subID)
+	}
 
 	return nil
 }
 
+// Dropped returns count of data dropped for subscriber by slow policy
+func (ch *MemoChannel[T]) Dropped(subID uuid.UUID) (uint64, error) {
+	subData, subExist := ch.subscriberCache.Load(subID)
+
+	if !subExist {
+		return 0, core.ErrNoSubcriber
+	}
+
+	return subData.(*sub[T]).dropped.Load(), nil
+}
+
 func (ch *MemoChannel[T]) timeout(timeout time.Duration) <-chan time.Time {
 	if timeout > 0 {
 		return time.After(timeout)
@@ -282,7 +862,11 @@ func (ch *MemoChannel[T]) PipelineUpStream(src core.Consumer[T]) error {
 		return errors.Wrap(core.ErrPipeline, "upstream empty")
 	}
 
-	subID, subCh := src.Subscribe(ch.name, core.Quick)
+	subID, subCh, err := src.Subscribe(ch.name, core.Quick)
+	if err != nil {
+		return errors.Wrap(err, "subscribe upstream failed")
+	}
+
 	if _, exist := ch.upstreamCache.LoadOrStore(subID, src); !exist {
 		ch.upstreamWg.Add(1)
 
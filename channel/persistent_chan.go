@@ -0,0 +1,237 @@
+package channel
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/frozenpine/msgqueue/chanio"
+	"github.com/frozenpine/msgqueue/flow"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+)
+
+var persistentType = reflect.TypeFor[chanio.PersistentData]()
+
+// offsetDIR is the dir in channel's flow dir
+// where committed offsets are persisted
+const offsetDIR = "offsets"
+
+// history is the persistent backend which channel data
+// will be written to and replayed from
+type history[T any] interface {
+	write(T) (uint64, error)
+	// readFrom reads data with its sequence, sequences may not be
+	// continuous if history is compacted, reader stops if done closed
+	readFrom(done <-chan struct{}, seq uint64) (<-chan seqData[T], error)
+	startSeq() uint64
+	close() error
+
+	// commit persists committed offset of ack mode subscriber
+	commit(subID uuid.UUID, seq uint64) error
+	offsets() (map[uuid.UUID]uint64, error)
+}
+
+type flowHistory[T any] struct {
+	flow      flow.Flow[chanio.PersistentData]
+	offsetDIR string
+}
+
+func (h *flowHistory[T]) write(v T) (uint64, error) {
+	data, ok := any(v).(chanio.PersistentData)
+	if !ok {
+		return 0, ErrNotPersistent
+	}
+
+	return h.flow.Write(data)
+}
+
+func (h *flowHistory[T]) readFrom(done <-chan struct{}, seq uint64) (<-chan seqData[T], error) {
+	start, end := h.flow.StartSequence(), h.flow.EndSequence()
+
+	if seq < start || seq > end {
+		return nil, errors.Wrapf(
+			flow.ErrSeqOutOfRange, "seq[%d] not in [%d, %d]", seq, start, end,
+		)
+	}
+
+	result := make(chan seqData[T], defaultChanSize)
+
+	go func() {
+		defer close(result)
+
+		for seq < end {
+			next, data, err := h.flow.ReadNext(seq)
+
+			if errors.Is(err, flow.ErrSeqOutOfRange) {
+				if start := h.flow.StartSequence(); seq < start {
+					slog.Warn(
+						"history removed by retention while replaying",
+						slog.Uint64("from", seq),
+						slog.Uint64("to", start),
+					)
+
+					seq = start
+					continue
+				}
+			}
+
+			if err != nil {
+				slog.Error(
+					"read history failed",
+					slog.Any("error", err),
+					slog.Uint64("seq", seq),
+				)
+				return
+			}
+
+			v, ok := data.(T)
+			if !ok {
+				slog.Error(
+					"history data type mismatch",
+					slog.Any("data", data),
+				)
+				return
+			}
+
+			select {
+			case <-done:
+				return
+			case result <- seqData[T]{seq: next, data: v}:
+			}
+
+			seq = next + 1
+		}
+	}()
+
+	return result, nil
+}
+
+func (h *flowHistory[T]) startSeq() uint64 {
+	return h.flow.StartSequence()
+}
+
+func (h *flowHistory[T]) close() error {
+	return h.flow.Close()
+}
+
+func (h *flowHistory[T]) commit(subID uuid.UUID, seq uint64) error {
+	buf := binary.LittleEndian.AppendUint64(nil, seq)
+	path := filepath.Join(h.offsetDIR, subID.String())
+
+	// write to synced temp file and rename, so offset file is always
+	// complete, dir is synced so renamed offset survives crash
+	if err := chanio.WriteFileSync(path+".tmp", buf); err != nil {
+		return errors.Wrap(err, "write offset failed")
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return errors.Wrap(err, "commit offset failed")
+	}
+
+	return errors.Wrap(chanio.SyncDir(h.offsetDIR), "sync offset dir failed")
+}
+
+func (h *flowHistory[T]) offsets() (map[uuid.UUID]uint64, error) {
+	entries, err := os.ReadDir(h.offsetDIR)
+	if err != nil {
+		return nil, errors.Wrap(err, "read offset dir failed")
+	}
+
+	offsets := make(map[uuid.UUID]uint64)
+
+	for _, entry := range entries {
+		subID, err := uuid.FromString(entry.Name())
+		if err != nil || entry.IsDir() {
+			continue
+		}
+
+		buf, err := os.ReadFile(filepath.Join(h.offsetDIR, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "read offset failed")
+		}
+
+		if len(buf) != 8 {
+			slog.Error(
+				"invalid offset file",
+				slog.String("file", entry.Name()),
+				slog.Int("size", len(buf)),
+			)
+			continue
+		}
+
+		offsets[subID] = binary.LittleEndian.Uint64(buf)
+	}
+
+	return offsets, nil
+}
+
+// PersistentChannel is a MemoChannel which writes all published data
+// to a file flow, subscribers can replay data from flow with core.Restart
+// or core.Resume
+type PersistentChannel[T any] struct {
+	MemoChannel[T]
+
+	flow flow.Flow[chanio.PersistentData]
+}
+
+// NewPersistentChannel create channel with flow in dir, opts such as
+// retention or compaction are applied to channel's flow
+func NewPersistentChannel[T any](
+	ctx context.Context, name string, dir string, bufSize int,
+	opts ...flow.FlowOption,
+) (*PersistentChannel[T], error) {
+	if !reflect.TypeFor[T]().Implements(persistentType) {
+		return nil, errors.Wrapf(
+			ErrNotPersistent, "%s", reflect.TypeFor[T]().String(),
+		)
+	}
+
+	f, err := flow.NewFileFlow[chanio.PersistentData](dir, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "create channel flow failed")
+	}
+
+	history := flowHistory[T]{
+		flow:      f,
+		offsetDIR: filepath.Join(dir, offsetDIR),
+	}
+
+	if err := os.MkdirAll(history.offsetDIR, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "create offset dir failed")
+	}
+
+	offsets, err := history.offsets()
+	if err != nil {
+		return nil, err
+	}
+
+	channel := PersistentChannel[T]{flow: f}
+
+	if name == "" {
+		name = "PersistentChan"
+	}
+
+	channel.Init(ctx, name, func() {
+		channel.chanLen = bufSize
+		channel.history = &history
+
+		for subID, seq := range offsets {
+			offset := &atomic.Uint64{}
+			offset.Store(seq)
+
+			channel.subOffsets.Store(subID, offset)
+		}
+	})
+
+	return &channel, nil
+}
+
+// Flow get underlying flow of channel
+func (ch *PersistentChannel[T]) Flow() flow.BaseFlow {
+	return ch.flow
+}
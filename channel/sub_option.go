@@ -0,0 +1,131 @@
+package channel
+
+import "time"
+
+// SlowPolicy decides what dispatcher does when
+// subscriber's queue is full
+type SlowPolicy uint8
+
+const (
+	// DropNewest drops the new data if subscriber's queue still full
+	// after slow timeout
+	DropNewest SlowPolicy = iota
+	// Block waits until subscriber's queue available, no data will be lost,
+	// but dispatching to other subscribers is blocked either
+	Block
+	// DropOldest drops the oldest data in subscriber's queue,
+	// making subscriber's queue a ring buffer
+	DropOldest
+	// Disconnect unsubscribes subscriber if subscriber's queue still full
+	// after slow timeout, queued data will be discarded
+	Disconnect
+)
+
+const defaultSlowTimeout = 500 * time.Millisecond
+
+func (p SlowPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "DropNewest"
+	case Block:
+		return "Block"
+	case DropOldest:
+		return "DropOldest"
+	case Disconnect:
+		return "Disconnect"
+	default:
+		return "Unknown"
+	}
+}
+
+type subOptions struct {
+	policy      SlowPolicy
+	slowTimeout time.Duration
+	maxBlock    time.Duration
+	bufSize     int
+
+	// filter is func(T) bool, checked when subscribing
+	filter any
+
+	hasStart bool
+	startSeq uint64
+
+	ackTimeout  time.Duration
+	maxInflight int
+}
+
+type SubOption func(*subOptions)
+
+// WithSlowPolicy overrides channel's slow policy for subscriber
+func WithSlowPolicy(policy SlowPolicy) SubOption {
+	return func(opts *subOptions) {
+		opts.policy = policy
+	}
+}
+
+// WithSlowTimeout overrides how long DropNewest & Disconnect
+// policy waits for subscriber, default is 500ms,
+// non-positive timeout means no waiting
+func WithSlowTimeout(timeout time.Duration) SubOption {
+	return func(opts *subOptions) {
+		opts.slowTimeout = timeout
+	}
+}
+
+// WithMaxBlock limits how long Block policy waits for subscriber,
+// subscriber is disconnected if its queue still full after maxBlock,
+// default is 0 which means waiting forever
+func WithMaxBlock(maxBlock time.Duration) SubOption {
+	return func(opts *subOptions) {
+		opts.maxBlock = maxBlock
+	}
+}
+
+// WithBufferSize overrides channel's buffer size for subscriber's queue
+func WithBufferSize(size int) SubOption {
+	return func(opts *subOptions) {
+		if size > 0 {
+			opts.bufSize = size
+		}
+	}
+}
+
+// WithFilter only delivers data which filter returns true,
+// filter is evaluated in channel's dispatcher so it should be fast,
+// T must be same as channel's data type
+func WithFilter[T any](filter func(T) bool) SubOption {
+	return func(opts *subOptions) {
+		if filter != nil {
+			opts.filter = filter
+		}
+	}
+}
+
+// WithStartSeq replays data from seq in channel's history,
+// overrides start position decided by resume type
+func WithStartSeq(seq uint64) SubOption {
+	return func(opts *subOptions) {
+		opts.hasStart = true
+		opts.startSeq = seq
+	}
+}
+
+// WithAckTimeout overrides how long unacked data will be
+// redelivered in ack mode, default is 30s
+func WithAckTimeout(timeout time.Duration) SubOption {
+	return func(opts *subOptions) {
+		if timeout > 0 {
+			opts.ackTimeout = timeout
+		}
+	}
+}
+
+// WithMaxInflight overrides how many data can be unacked in ack mode,
+// default is 1024, data is held in subscriber's queue if limit reached
+func WithMaxInflight(n int) SubOption {
+	return func(opts *subOptions) {
+		if n > 0 {
+			opts.maxInflight = n
+		}
+	}
+}
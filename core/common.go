@@ -64,7 +64,11 @@ type Type uint8
 
 type CtxTypeKey string
 
-const CtxQueueType CtxTypeKey = "queue_type"
+const (
+	CtxQueueType  CtxTypeKey = "queue_type"
+	CtxFlowDir    CtxTypeKey = "flow_dir"
+	CtxSlowPolicy CtxTypeKey = "slow_policy"
+)
 
 var ErrInvalidType = errors.New("invalid type")
 
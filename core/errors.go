@@ -7,4 +7,6 @@ var (
 	ErrPubTimeout        = errors.New("pub timeout")
 	ErrPipeline          = errors.New("pipeline upstream is nil")
 	ErrAlreadySubscribed = errors.New("already subscribed")
+	ErrNoHistory         = errors.New("no history to restart or resume")
+	ErrInvalidResumeType = errors.New("invalid resume type")
 )
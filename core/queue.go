@@ -26,7 +26,7 @@ type QueueBase interface {
 
 type Consumer[T any] interface {
 	QueueBase
-	Subscribe(name string, resumeType ResumeType) (uuid.UUID, <-chan T)
+	Subscribe(name string, resumeType ResumeType) (uuid.UUID, <-chan T, error)
 	UnSubscribe(subID uuid.UUID) error
 }
 
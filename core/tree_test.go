@@ -4,10 +4,17 @@ import (
 	"testing"
 
 	"github.com/frozenpine/msgqueue/core"
-	"github.com/frozenpine/msgqueue/flow"
 )
 
+type treeItem int
+
+func (v treeItem) Less(than core.Item) bool {
+	right, ok := than.(treeItem)
+
+	return ok && v < right
+}
+
 func TestTreeItem(t *testing.T) {
-	var v core.Item = &flow.FlowItem{}
+	var v core.Item = treeItem(0)
 	t.Log(v)
 }
@@ -1,21 +1,454 @@
 package flow
 
 import (
+	"encoding/binary"
+	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
 
 	"github.com/frozenpine/msgqueue/chanio"
-	"github.com/frozenpine/msgqueue/core"
+	"github.com/pkg/errors"
 )
 
+const (
+	flowEpochFile = "flow.epoch"
+
+	// epoch record: epoch(uint64) + start sequence(uint64)
+	epochRecordLen = 16
+
+	flowReadBuffer = 1
+	// flowIdleCursors is max count of cursors kept for ReadAt & ReadNext
+	flowIdleCursors = 4
+)
+
+// flowEpoch is the first sequence written in an epoch,
+// each FileFlow instance starts a new epoch on its first write
+type flowEpoch struct {
+	epoch    uint64
+	startSeq uint64
+}
+
 type FileFlow[T chanio.PersistentData] struct {
-	flowDIR os.DirEntry
+	flowDIR   string
+	options   flowOptions
+	store     *chanio.SegmentLog
+	epochFile *os.File
+	epochList []flowEpoch
+
+	rwLock sync.RWMutex
+	closed bool
+
+	// cursors are idle cursors, each read uses its own cursor,
+	// so reads only take flow's read lock
+	cursorLock sync.Mutex
+	cursors    []*chanio.Cursor
+
+	compactKey    func(chanio.PersistentData) string
+	stopRetention chan struct{}
+	retentionDone sync.WaitGroup
+	// retaining counts running retentions, which hold no lock
+	retaining sync.WaitGroup
+
+	epochStarted bool
+	flowEpoch    uint64
+	flowSeq      uint64
+}
+
+// NewFileFlow create flow storing data in segments under dir,
+// returns error if compaction key's type mismatch with T, or
+// retention or compaction configured without roll policy
+func NewFileFlow[T chanio.PersistentData](dir string, opts ...FlowOption) (*FileFlow[T], error) {
+	flow := FileFlow[T]{
+		flowDIR: dir,
+	}
+
+	for _, opt := range opts {
+		opt(&flow.options)
+	}
+
+	if flow.options.compactKey != nil {
+		key, ok := flow.options.compactKey.(func(T) string)
+		if !ok {
+			return nil, errors.Wrapf(
+				ErrCompactKeyType, "%T for %s",
+				flow.options.compactKey, reflect.TypeFor[T]().String(),
+			)
+		}
+
+		flow.compactKey = compactKeyOf(key)
+	}
+
+	// retention & compaction only work on sealed segments
+	if (flow.options.retention.enabled() || flow.compactKey != nil) &&
+		flow.options.roll == (chanio.RollPolicy{}) && !flow.options.rollOnEpoch {
+		return nil, errors.Wrap(ErrNoRollPolicy, "retention or compaction configured")
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "create flow dir failed")
+	}
+
+	if err := flow.loadEpoch(); err != nil {
+		return nil, err
+	}
+
+	if err := flow.loadData(); err != nil {
+		flow.epochFile.Close()
+		return nil, err
+	}
+
+	if flow.options.retention.enabled() || flow.compactKey != nil {
+		flow.stopRetention = make(chan struct{})
+		flow.retentionDone.Add(1)
+
+		go flow.runRetention()
+	}
+
+	return &flow, nil
+}
+
+func (f *FileFlow[T]) loadEpoch() (err error) {
+	if f.epochFile, err = os.OpenFile(
+		filepath.Join(f.flowDIR, flowEpochFile),
+		os.O_RDWR|os.O_CREATE|os.O_APPEND, os.ModePerm,
+	); err != nil {
+		return errors.Wrap(err, "open epoch file failed")
+	}
+
+	buf := make([]byte, epochRecordLen)
+
+	for {
+		if _, err = io.ReadFull(f.epochFile, buf); err != nil {
+			break
+		}
+
+		f.epochList = append(f.epochList, flowEpoch{
+			epoch:    binary.LittleEndian.Uint64(buf),
+			startSeq: binary.LittleEndian.Uint64(buf[8:]),
+		})
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// torn record written by crash, new records are appended
+		// after last whole record
+		valid := int64(len(f.epochList) * epochRecordLen)
+
+		slog.Warn(
+			"truncating torn epoch record",
+			slog.String("dir", f.flowDIR),
+			slog.Int64("valid", valid),
+		)
+
+		if err = f.epochFile.Truncate(valid); err == nil {
+			err = io.EOF
+		}
+	}
+
+	if !errors.Is(err, io.EOF) {
+		f.epochFile.Close()
+		return errors.Wrap(err, "read epoch file failed")
+	}
+
+	if count := len(f.epochList); count > 0 {
+		f.flowEpoch = f.epochList[count-1].epoch + 1
+	}
+
+	return nil
+}
+
+// loadData opens flow segments, data is read from disk on demand
+func (f *FileFlow[T]) loadData() error {
+	f.store = chanio.NewSegmentLog(f.flowDIR, f.options.roll)
+
+	if err := f.store.Open(os.O_RDWR | os.O_CREATE | os.O_APPEND); err != nil {
+		return errors.Wrap(err, "open flow data failed")
+	}
+
+	f.flowSeq = f.store.EndSequence()
+
+	return nil
+}
+
+func (f *FileFlow[T]) startEpoch() error {
+	buf := make([]byte, epochRecordLen)
+
+	binary.LittleEndian.PutUint64(buf, f.flowEpoch)
+	binary.LittleEndian.PutUint64(buf[8:], f.flowSeq)
+
+	if _, err := f.epochFile.Write(buf); err != nil {
+		return errors.Wrap(err, "write epoch failed")
+	}
+
+	if err := f.epochFile.Sync(); err != nil {
+		return errors.Wrap(err, "sync epoch failed")
+	}
+
+	f.epochList = append(f.epochList, flowEpoch{
+		epoch:    f.flowEpoch,
+		startSeq: f.flowSeq,
+	})
+	f.epochStarted = true
+
+	return nil
+}
+
+// Epoch get current epoch, which will be used by next write
+func (f *FileFlow[T]) Epoch() uint64 {
+	f.rwLock.RLock()
+	defer f.rwLock.RUnlock()
+
+	return f.flowEpoch
+}
+
+// StartSequence get first sequence retained in flow
+func (f *FileFlow[T]) StartSequence() uint64 {
+	f.rwLock.RLock()
+	defer f.rwLock.RUnlock()
+
+	return f.store.StartSequence()
+}
+
+// EndSequence get sequence which will be assigned to next write,
+// flow is empty if EndSequence equals StartSequence
+func (f *FileFlow[T]) EndSequence() uint64 {
+	f.rwLock.RLock()
+	defer f.rwLock.RUnlock()
+
+	return f.flowSeq
+}
+
+// TotalDataSize get flow data size on disk
+func (f *FileFlow[T]) TotalDataSize() uint64 {
+	f.rwLock.RLock()
+	defer f.rwLock.RUnlock()
+
+	return uint64(f.store.Size())
+}
+
+func (f *FileFlow[T]) Close() error {
+	f.rwLock.Lock()
+	if f.closed {
+		f.rwLock.Unlock()
+		return ErrFlowClosed
+	}
+	f.closed = true
+	f.rwLock.Unlock()
+
+	if f.stopRetention != nil {
+		close(f.stopRetention)
+		f.retentionDone.Wait()
+	}
+	// no retention started after flow closed
+	f.retaining.Wait()
+
+	f.rwLock.Lock()
+	defer f.rwLock.Unlock()
+
+	f.cursorLock.Lock()
+	for _, c := range f.cursors {
+		c.Close()
+	}
+	f.cursors = nil
+	f.cursorLock.Unlock()
+
+	if err := f.epochFile.Close(); err != nil {
+		return errors.Wrap(err, "close epoch file failed")
+	}
+
+	return f.store.Close()
+}
+
+func (f *FileFlow[T]) Write(data T) (seq uint64, err error) {
+	tid, err := chanio.LookupType(data)
+	if err != nil {
+		return 0, errors.Wrap(err, "lookup data type failed")
+	}
+
+	f.rwLock.Lock()
+	defer f.rwLock.Unlock()
+
+	if f.closed {
+		return 0, ErrFlowClosed
+	}
+
+	if !f.epochStarted {
+		if f.options.rollOnEpoch {
+			if err = f.store.Roll(); err != nil {
+				return 0, errors.Wrap(err, "roll epoch segment failed")
+			}
+		}
+
+		if err = f.startEpoch(); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = f.store.Write(tid, data); err != nil {
+		return 0, errors.Wrap(err, "write flow data failed")
+	}
+
+	seq = f.flowSeq
+	f.flowSeq++
+
+	return
+}
+
+// getCursor get idle cursor or create new one,
+// cursor should be put back in same read lock
+func (f *FileFlow[T]) getCursor() *chanio.Cursor {
+	f.cursorLock.Lock()
+	defer f.cursorLock.Unlock()
+
+	if count := len(f.cursors); count > 0 {
+		c := f.cursors[count-1]
+		f.cursors = f.cursors[:count-1]
+		return c
+	}
+
+	return f.store.NewCursor()
+}
+
+func (f *FileFlow[T]) putCursor(c *chanio.Cursor) {
+	f.cursorLock.Lock()
+	defer f.cursorLock.Unlock()
+
+	if len(f.cursors) < flowIdleCursors {
+		f.cursors = append(f.cursors, c)
+		return
+	}
+
+	c.Close()
+}
+
+// ReadAt reads data from disk with segment's offset index,
+// each read uses its own cursor, so reads can run concurrently
+func (f *FileFlow[T]) ReadAt(seq uint64) (result T, err error) {
+	f.rwLock.RLock()
+	defer f.rwLock.RUnlock()
+
+	if f.closed {
+		return result, ErrFlowClosed
+	}
+
+	if seq < f.store.StartSequence() || seq >= f.flowSeq {
+		return result, errors.Wrapf(ErrSeqOutOfRange, "seq[%d] not in flow", seq)
+	}
+
+	c := f.getCursor()
+	defer f.putCursor(c)
+
+	data, err := c.ReadAt(seq)
+	if errors.Is(err, chanio.ErrSeekOutOfRange) {
+		return result, errors.Wrapf(ErrSeqOutOfRange, "seq[%d] removed from flow", seq)
+	} else if err != nil {
+		return result, errors.Wrapf(err, "read seq[%d] failed", seq)
+	}
+
+	if v, ok := data.(T); ok {
+		return v, nil
+	}
+
+	return result, errors.Wrapf(ErrTypeMismatch, "seq[%d] data type mismatch", seq)
+}
+
+// ReadNext reads first data whose sequence not less than seq, returns
+// data's sequence, which is greater than seq if seq removed by compaction
+func (f *FileFlow[T]) ReadNext(seq uint64) (next uint64, result T, err error) {
+	f.rwLock.RLock()
+	defer f.rwLock.RUnlock()
+
+	c := f.getCursor()
+	defer f.putCursor(c)
+
+	return f.readNext(c, seq)
+}
+
+// readNext reads with cursor c, flow's read lock should be held
+func (f *FileFlow[T]) readNext(c *chanio.Cursor, seq uint64) (next uint64, result T, err error) {
+	if f.closed {
+		return 0, result, ErrFlowClosed
+	}
+
+	if seq < f.store.StartSequence() || seq >= f.flowSeq {
+		return 0, result, errors.Wrapf(ErrSeqOutOfRange, "seq[%d] not in flow", seq)
+	}
+
+	next, data, err := c.ReadNext(seq)
+	if errors.Is(err, io.EOF) {
+		return 0, result, errors.Wrapf(ErrSeqOutOfRange, "no data after seq[%d]", seq)
+	} else if errors.Is(err, chanio.ErrSeekOutOfRange) {
+		return 0, result, errors.Wrapf(ErrSeqOutOfRange, "seq[%d] removed from flow", seq)
+	} else if err != nil {
+		return 0, result, errors.Wrapf(err, "read seq[%d] failed", seq)
+	}
+
+	if v, ok := data.(T); ok {
+		return next, v, nil
+	}
+
+	return 0, result, errors.Wrapf(ErrTypeMismatch, "seq[%d] data type mismatch", next)
+}
+
+// ReadFrom reads data from seq to flow's current end, sequences removed
+// by compaction are skipped, if segments being read are removed by retention,
+// reading continues from flow's new start with a warning of the gap
+func (f *FileFlow[T]) ReadFrom(seq uint64) (<-chan T, error) {
+	start, end := f.StartSequence(), f.EndSequence()
+
+	if seq < start || seq > end {
+		return nil, errors.Wrapf(
+			ErrSeqOutOfRange, "seq[%d] not in [%d, %d]", seq, start, end,
+		)
+	}
+
+	result := make(chan T, flowReadBuffer)
+
+	go func() {
+		defer close(result)
+
+		// sequential reads continue from cursor's position without seek
+		c := f.store.NewCursor()
+		defer c.Close()
+
+		for seq < end {
+			f.rwLock.RLock()
+			next, v, err := f.readNext(c, seq)
+			f.rwLock.RUnlock()
+
+			if errors.Is(err, ErrSeqOutOfRange) {
+				if start := f.StartSequence(); seq < start {
+					slog.Warn(
+						"flow data removed by retention while reading",
+						slog.String("dir", f.flowDIR),
+						slog.Uint64("from", seq),
+						slog.Uint64("to", start),
+					)
+
+					seq = start
+					continue
+				}
+			}
+
+			if err != nil {
+				slog.Error(
+					"read flow data failed",
+					slog.Any("error", err),
+					slog.String("dir", f.flowDIR),
+				)
+				return
+			}
 
-	flowCache *core.RBTree
+			result <- v
+			seq = next + 1
+		}
+	}()
 
-	flowEpoch uint64
-	flowSeq   uint64
+	return result, nil
 }
 
-func NewFileFlow[T chanio.PersistentData](dir string) *FileFlow[T] {
-	return &FileFlow[T]{}
+func (f *FileFlow[T]) ReadAll() (<-chan T, error) {
+	return f.ReadFrom(f.StartSequence())
 }
@@ -2,37 +2,22 @@ package flow
 
 import (
 	"github.com/frozenpine/msgqueue/chanio"
-	"github.com/frozenpine/msgqueue/core"
+	"github.com/pkg/errors"
 )
 
-type FlowItem struct {
-	Epoch    uint64
-	Sequence uint64
-	Data     chanio.PersistentData
-}
-
-func (v *FlowItem) Less(than core.Item) bool {
-	right, ok := than.(*FlowItem)
-
-	if !ok {
-		return false
-	}
-
-	if v.Epoch < right.Epoch {
-		return true
-	}
-
-	if v.Epoch > right.Epoch {
-		return false
-	}
-
-	return v.Sequence < right.Sequence
-}
+var (
+	ErrFlowClosed     = errors.New("flow closed")
+	ErrSeqOutOfRange  = errors.New("sequence out of range")
+	ErrTypeMismatch   = errors.New("flow data type mismatch")
+	ErrCompactKeyType = errors.New("compaction key type mismatch")
+	ErrNoRollPolicy   = errors.New("flow has no roll policy")
+)
 
 type BaseFlow interface {
 	StartSequence() uint64
 	EndSequence() uint64
 	TotalDataSize() uint64
+	Close() error
 }
 
 type Flow[T chanio.PersistentData] interface {
@@ -40,6 +25,7 @@ type Flow[T chanio.PersistentData] interface {
 
 	Write(data T) (seq uint64, err error)
 	ReadAt(seq uint64) (T, error)
+	ReadNext(seq uint64) (uint64, T, error)
 	ReadFrom(seq uint64) (<-chan T, error)
 	ReadAll() (<-chan T, error)
 }
@@ -1,7 +1,18 @@
 package flow_test
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/frozenpine/msgqueue/chanio"
+	"github.com/frozenpine/msgqueue/flow"
 )
 
 func TestValue(t *testing.T) {
@@ -11,3 +22,497 @@ func TestValue(t *testing.T) {
 
 	t.Log(v1, v2, int(v2))
 }
+
+type Int struct {
+	int
+}
+
+func (v Int) Serialize() []byte {
+	result := make([]byte, 4)
+
+	binary.LittleEndian.PutUint32(result, uint32(v.int))
+
+	return result
+}
+
+func (v *Int) Deserialize(data []byte) error {
+	v.int = int(binary.LittleEndian.Uint32(data))
+	return nil
+}
+
+func TestFileFlow(t *testing.T) {
+	chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	dir := t.TempDir()
+	count := 100
+
+	f, err := flow.NewFileFlow[*Int](dir)
+	if err != nil {
+		t.Fatal("create flow failed:", err)
+	}
+
+	for idx := 0; idx < count; idx++ {
+		if seq, err := f.Write(&Int{idx}); err != nil {
+			t.Fatal("write flow failed:", err)
+		} else if seq != uint64(idx) {
+			t.Fatalf("flow seq mismatch: %d %d", seq, idx)
+		}
+	}
+
+	size := f.TotalDataSize()
+
+	if err := f.Close(); err != nil {
+		t.Fatal("close flow failed:", err)
+	}
+
+	if f, err = flow.NewFileFlow[*Int](dir); err != nil {
+		t.Fatal("reopen flow failed:", err)
+	}
+	defer f.Close()
+
+	if f.StartSequence() != 0 || f.EndSequence() != uint64(count) {
+		t.Fatalf("flow range mismatch: [%d, %d)", f.StartSequence(), f.EndSequence())
+	}
+
+	if f.TotalDataSize() != size {
+		t.Fatalf("flow size mismatch: %d %d", f.TotalDataSize(), size)
+	}
+
+	if f.Epoch() != 1 {
+		t.Fatal("flow epoch mismatch:", f.Epoch())
+	}
+
+	if v, err := f.ReadAt(50); err != nil || v.int != 50 {
+		t.Fatal("read at failed:", v, err)
+	}
+
+	if _, err := f.ReadAt(uint64(count)); !errors.Is(err, flow.ErrSeqOutOfRange) {
+		t.Fatal("read out of range should fail:", err)
+	}
+
+	if seq, err := f.Write(&Int{count}); err != nil || seq != uint64(count) {
+		t.Fatal("write after reopen failed:", seq, err)
+	}
+
+	ch, err := f.ReadAll()
+	if err != nil {
+		t.Fatal("read all failed:", err)
+	}
+
+	expect := 0
+	for v := range ch {
+		if v.int != expect {
+			t.Fatalf("flow data mismatch: %d %d", v.int, expect)
+		}
+		expect++
+	}
+
+	if expect != count+1 {
+		t.Fatal("flow data missing:", expect)
+	}
+}
+
+func TestFileFlowTornEpoch(t *testing.T) {
+	chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	dir := t.TempDir()
+
+	for epoch := 0; epoch < 3; epoch++ {
+		f, err := flow.NewFileFlow[*Int](dir)
+		if err != nil {
+			t.Fatal("create flow failed:", err)
+		}
+
+		if f.Epoch() != uint64(epoch) {
+			t.Fatal("flow epoch mismatch:", f.Epoch(), epoch)
+		}
+
+		if _, err := f.Write(&Int{epoch}); err != nil {
+			t.Fatal("write flow failed:", err)
+		}
+
+		if err := f.Close(); err != nil {
+			t.Fatal("close flow failed:", err)
+		}
+
+		// epoch record torn by crash
+		file, err := os.OpenFile(
+			filepath.Join(dir, "flow.epoch"), os.O_WRONLY|os.O_APPEND, os.ModePerm,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write([]byte{0xff, 0xff, 0xff})
+		file.Close()
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "flow.epoch"))
+	if err != nil || info.Size() != 3*16+3 {
+		t.Fatal("epoch file size mismatch:", info.Size(), err)
+	}
+}
+
+func TestFileFlowRoll(t *testing.T) {
+	chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	dir := t.TempDir()
+
+	for epoch := 0; epoch < 2; epoch++ {
+		f, err := flow.NewFileFlow[*Int](
+			dir, flow.WithEpochRoll(),
+			flow.WithRollPolicy(chanio.RollPolicy{MaxRecords: 4}),
+		)
+		if err != nil {
+			t.Fatal("create flow failed:", err)
+		}
+
+		for idx := 0; idx < 6; idx++ {
+			if _, err := f.Write(&Int{idx}); err != nil {
+				t.Fatal("write flow failed:", err)
+			}
+		}
+
+		if err := f.Close(); err != nil {
+			t.Fatal("close flow failed:", err)
+		}
+	}
+
+	// epoch 0: [0, 4) [4, 6), epoch 1: [6, 10) [10, 12)
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if err != nil || len(segments) != 4 {
+		t.Fatal("flow segments mismatch:", segments, err)
+	}
+
+	f, err := flow.NewFileFlow[*Int](dir)
+	if err != nil {
+		t.Fatal("reopen flow failed:", err)
+	}
+	defer f.Close()
+
+	if f.EndSequence() != 12 {
+		t.Fatal("flow end sequence mismatch:", f.EndSequence())
+	}
+}
+
+func TestFileFlowConcurrentRead(t *testing.T) {
+	chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	count := 200
+
+	f, err := flow.NewFileFlow[*Int](
+		t.TempDir(), flow.WithRollPolicy(chanio.RollPolicy{MaxRecords: 30}),
+	)
+	if err != nil {
+		t.Fatal("create flow failed:", err)
+	}
+	defer f.Close()
+
+	for idx := 0; idx < count/2; idx++ {
+		if _, err := f.Write(&Int{idx}); err != nil {
+			t.Fatal("write flow failed:", err)
+		}
+	}
+
+	wg := sync.WaitGroup{}
+
+	// readers with own cursors read different positions while writing
+	for reader := 0; reader < 4; reader++ {
+		wg.Add(1)
+		go func(reader int) {
+			defer wg.Done()
+
+			for round := 0; round < count/2; round++ {
+				seq := uint64((round*7 + reader*13) % (count / 2))
+
+				if v, err := f.ReadAt(seq); err != nil || v.int != int(seq) {
+					t.Error("concurrent read at failed:", seq, v, err)
+					return
+				}
+
+				if next, v, err := f.ReadNext(seq); err != nil || next != seq || v.int != int(seq) {
+					t.Error("concurrent read next failed:", seq, v, err)
+					return
+				}
+			}
+		}(reader)
+	}
+
+	for idx := count / 2; idx < count; idx++ {
+		if _, err := f.Write(&Int{idx}); err != nil {
+			t.Fatal("write flow failed:", err)
+		}
+	}
+
+	ch, err := f.ReadAll()
+	if err != nil {
+		t.Fatal("read all failed:", err)
+	}
+
+	expect := 0
+	for v := range ch {
+		if v.int != expect {
+			t.Fatalf("flow data mismatch: %d %d", v.int, expect)
+		}
+		expect++
+	}
+
+	wg.Wait()
+
+	if expect != count {
+		t.Fatal("flow data missing:", expect)
+	}
+}
+
+func TestFileFlowRetention(t *testing.T) {
+	chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	writeFlow := func(f *flow.FileFlow[*Int], from, to int) {
+		for idx := from; idx < to; idx++ {
+			if _, err := f.Write(&Int{idx}); err != nil {
+				t.Fatal("write flow failed:", err)
+			}
+		}
+	}
+
+	// keep last 2 epochs, each epoch has 10 records
+	dir := t.TempDir()
+	for epoch := 0; epoch < 3; epoch++ {
+		f, err := flow.NewFileFlow[*Int](
+			dir, flow.WithEpochRoll(),
+			flow.WithRollPolicy(chanio.RollPolicy{MaxRecords: 5}),
+			flow.WithRetention(flow.RetentionPolicy{KeepEpochs: 2}),
+		)
+		if err != nil {
+			t.Fatal("create flow failed:", err)
+		}
+
+		writeFlow(f, epoch*10, epoch*10+10)
+
+		if err := f.ApplyRetention(); err != nil {
+			t.Fatal("apply retention failed:", err)
+		}
+
+		if start := f.StartSequence(); epoch == 2 && start != 10 {
+			t.Fatal("flow start mismatch after retention:", start)
+		}
+
+		if err := f.Close(); err != nil {
+			t.Fatal("close flow failed:", err)
+		}
+	}
+
+	// size limit enforced in background, only active segment retained
+	f, err := flow.NewFileFlow[*Int](
+		t.TempDir(),
+		flow.WithRollPolicy(chanio.RollPolicy{MaxRecords: 5}),
+		flow.WithRetention(flow.RetentionPolicy{
+			MaxSize: 1, CheckInterval: time.Millisecond * 10,
+		}),
+	)
+	if err != nil {
+		t.Fatal("create flow failed:", err)
+	}
+
+	writeFlow(f, 0, 18)
+
+	deadline := time.Now().Add(time.Second * 5)
+	for f.StartSequence() != 15 {
+		if time.Now().After(deadline) {
+			t.Fatal("flow retention not enforced:", f.StartSequence())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if _, err := f.ReadAt(14); !errors.Is(err, flow.ErrSeqOutOfRange) {
+		t.Fatal("read removed seq should fail:", err)
+	}
+	if v, err := f.ReadAt(15); err != nil || v.int != 15 {
+		t.Fatal("read retained seq failed:", v, err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal("close flow failed:", err)
+	}
+
+	// retention races with writing & closing
+	f, err = flow.NewFileFlow[*Int](
+		t.TempDir(),
+		flow.WithRollPolicy(chanio.RollPolicy{MaxRecords: 2}),
+		flow.WithRetention(flow.RetentionPolicy{MaxSize: 1}),
+	)
+	if err != nil {
+		t.Fatal("create flow failed:", err)
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan error, 4)
+
+	for idx := 0; idx < 4; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				err := f.ApplyRetention()
+				if err == nil {
+					_, err = f.Write(&Int{idx})
+				}
+				if err != nil {
+					results <- err
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(time.Millisecond * 50)
+
+	if err := f.Close(); err != nil {
+		t.Fatal("close flow failed:", err)
+	}
+
+	wg.Wait()
+	close(results)
+
+	for err := range results {
+		if !errors.Is(err, flow.ErrFlowClosed) {
+			t.Fatal("retention should only fail by closed flow:", err)
+		}
+	}
+
+	if _, err := flow.NewFileFlow[*Int](
+		t.TempDir(),
+		flow.WithCompaction(func(chanio.PersistentData) string { return "" }),
+	); !errors.Is(err, flow.ErrCompactKeyType) {
+		t.Fatal("compaction key type should mismatch:", err)
+	}
+
+	for _, opt := range []flow.FlowOption{
+		flow.WithRetention(flow.RetentionPolicy{MaxSize: 1}),
+		flow.WithCompaction(func(*Int) string { return "" }),
+	} {
+		if _, err := flow.NewFileFlow[*Int](t.TempDir(), opt); !errors.Is(err, flow.ErrNoRollPolicy) {
+			t.Fatal("retention without roll policy should fail:", err)
+		}
+	}
+
+	// compaction keeps latest of each key in sealed segments: 0..7
+	// with key v%3, so [0, 4) is emptied and [4, 8) keeps 5, 6 & 7
+	f, err = flow.NewFileFlow[*Int](
+		t.TempDir(),
+		flow.WithRollPolicy(chanio.RollPolicy{MaxRecords: 4}),
+		flow.WithCompaction(func(v *Int) string {
+			return strconv.Itoa(v.int % 3)
+		}),
+	)
+	if err != nil {
+		t.Fatal("create flow failed:", err)
+	}
+	defer f.Close()
+
+	writeFlow(f, 0, 10)
+
+	if err := f.ApplyRetention(); err != nil {
+		t.Fatal("compact flow failed:", err)
+	}
+
+	if f.StartSequence() != 5 || f.EndSequence() != 10 {
+		t.Fatalf("compacted flow range mismatch: [%d, %d)", f.StartSequence(), f.EndSequence())
+	}
+
+	ch, err := f.ReadAll()
+	if err != nil {
+		t.Fatal("read all failed:", err)
+	}
+
+	expect := 5
+	for v := range ch {
+		if v.int != expect {
+			t.Fatalf("compacted data mismatch: %d %d", v.int, expect)
+		}
+		expect++
+	}
+
+	if expect != 10 {
+		t.Fatal("compacted data missing:", expect)
+	}
+
+	if seq, err := f.Write(&Int{10}); err != nil || seq != 10 {
+		t.Fatal("write after compaction failed:", seq, err)
+	}
+
+	// sealed 8 ~ 11 replace 5 ~ 8, so [4, 8) is emptied
+	// and [8, 12) keeps 9 ~ 11, 12 in active segment is kept
+	writeFlow(f, 11, 13)
+
+	if err := f.ApplyRetention(); err != nil {
+		t.Fatal("compact flow again failed:", err)
+	}
+
+	if seq, v, err := f.ReadNext(10); err != nil || seq != 10 || v.int != 10 {
+		t.Fatal("read next of compacted flow failed:", seq, v, err)
+	}
+	if f.StartSequence() != 9 {
+		t.Fatal("compacted flow start mismatch:", f.StartSequence())
+	}
+	if _, _, err := f.ReadNext(8); !errors.Is(err, flow.ErrSeqOutOfRange) {
+		t.Fatal("read removed seq should fail:", err)
+	}
+}
+
+func TestFileFlowReadRemoved(t *testing.T) {
+	chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	f, err := flow.NewFileFlow[*Int](
+		t.TempDir(),
+		flow.WithRollPolicy(chanio.RollPolicy{MaxRecords: 5}),
+		flow.WithRetention(flow.RetentionPolicy{MaxSize: 1, CheckInterval: time.Hour}),
+	)
+	if err != nil {
+		t.Fatal("create flow failed:", err)
+	}
+	defer f.Close()
+
+	for idx := 0; idx < 18; idx++ {
+		if _, err := f.Write(&Int{idx}); err != nil {
+			t.Fatal("write flow failed:", err)
+		}
+	}
+
+	ch, err := f.ReadAll()
+	if err != nil {
+		t.Fatal("read all failed:", err)
+	}
+
+	// reader is blocked with 1 & 2 read before sealed segments removed
+	if v := <-ch; v.int != 0 {
+		t.Fatal("first data mismatch:", v.int)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if err := f.ApplyRetention(); err != nil {
+		t.Fatal("apply retention failed:", err)
+	}
+
+	var values []int
+	for v := range ch {
+		values = append(values, v.int)
+	}
+
+	// reading continues from new start of flow
+	if fmt.Sprint(values) != "[1 2 15 16 17]" {
+		t.Fatal("data read across removed segments mismatch:", values)
+	}
+}
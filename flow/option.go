@@ -0,0 +1,47 @@
+package flow
+
+import "github.com/frozenpine/msgqueue/chanio"
+
+type flowOptions struct {
+	roll        chanio.RollPolicy
+	rollOnEpoch bool
+	retention   RetentionPolicy
+	// compactKey is func(T) string, checked when flow created
+	compactKey any
+}
+
+type FlowOption func(*flowOptions)
+
+// WithRollPolicy rolls flow data to new segment by size,
+// record count or segment age
+func WithRollPolicy(policy chanio.RollPolicy) FlowOption {
+	return func(opts *flowOptions) {
+		opts.roll = policy
+	}
+}
+
+// WithEpochRoll starts new segment for each epoch's data
+func WithEpochRoll() FlowOption {
+	return func(opts *flowOptions) {
+		opts.rollOnEpoch = true
+	}
+}
+
+// WithRetention removes old segments by policy in background,
+// flow must be rolled by WithRollPolicy or WithEpochRoll
+func WithRetention(policy RetentionPolicy) FlowOption {
+	return func(opts *flowOptions) {
+		opts.retention = policy
+	}
+}
+
+// WithCompaction keeps only latest record of each key in sealed segments,
+// T must be same as flow's data type, empty key means record is always kept,
+// flow must be rolled by WithRollPolicy or WithEpochRoll
+func WithCompaction[T chanio.PersistentData](key func(T) string) FlowOption {
+	return func(opts *flowOptions) {
+		if key != nil {
+			opts.compactKey = key
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package flow
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/frozenpine/msgqueue/chanio"
+	"github.com/pkg/errors"
+)
+
+const defaultRetentionInterval = time.Minute
+
+// RetentionPolicy decides which sealed segments of flow are removed,
+// zero value field means no limit, active segment is always retained
+type RetentionPolicy struct {
+	// MaxAge removes segment if its last record is older than MaxAge
+	MaxAge time.Duration
+	// MaxSize removes oldest segments until flow's size not exceeds MaxSize
+	MaxSize int64
+	// KeepEpochs removes segments only containing data before last N epochs
+	KeepEpochs int
+	// CheckInterval is period of background enforcement, default 1 minute
+	CheckInterval time.Duration
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.MaxAge > 0 || p.MaxSize > 0 || p.KeepEpochs > 0
+}
+
+// epochCutoff get first sequence of epochs to keep
+func (f *FileFlow[T]) epochCutoff() uint64 {
+	keep := f.options.retention.KeepEpochs
+
+	if keep <= 0 || len(f.epochList) <= keep {
+		return 0
+	}
+
+	return f.epochList[len(f.epochList)-keep].startSeq
+}
+
+// ApplyRetention enforces retention policy and compaction once,
+// which is also run periodically in background if configured
+func (f *FileFlow[T]) ApplyRetention() error {
+	// lock is not held in retention, so writes are not blocked
+	// by compaction, flow is closed after running ones finished
+	f.rwLock.RLock()
+	if f.closed {
+		f.rwLock.RUnlock()
+		return ErrFlowClosed
+	}
+	f.retaining.Add(1)
+	cutoff := f.epochCutoff()
+	f.rwLock.RUnlock()
+
+	defer f.retaining.Done()
+
+	policy := f.options.retention
+	now := time.Now()
+
+	removed, err := f.store.RemoveOldest(func(segments []chanio.SegmentInfo) int {
+		var total int64
+		for _, seg := range segments {
+			total += seg.Size
+		}
+
+		// remove from oldest, so retained sequences are continuous
+		for idx, seg := range segments[:len(segments)-1] {
+			// next segment created after last record of this one written
+			expired := policy.MaxAge > 0 && now.Sub(segments[idx+1].Created) >= policy.MaxAge
+			oversize := policy.MaxSize > 0 && total > policy.MaxSize
+			outdated := seg.End <= cutoff
+
+			if !expired && !oversize && !outdated {
+				return idx
+			}
+
+			total -= seg.Size
+		}
+
+		return len(segments) - 1
+	})
+
+	for _, seg := range removed {
+		slog.Info(
+			"flow segment removed by retention",
+			slog.String("dir", f.flowDIR),
+			slog.Uint64("base", seg.Base),
+			slog.Uint64("records", seg.Records),
+		)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "remove flow segments failed")
+	}
+
+	if f.compactKey != nil {
+		return errors.Wrap(f.store.Compact(f.compactKey), "compact flow failed")
+	}
+
+	return nil
+}
+
+func (f *FileFlow[T]) runRetention() {
+	defer f.retentionDone.Done()
+
+	interval := f.options.retention.CheckInterval
+	if interval <= 0 {
+		interval = defaultRetentionInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stopRetention:
+			return
+		case <-ticker.C:
+			if err := f.ApplyRetention(); err != nil {
+				slog.Error(
+					"apply flow retention failed",
+					slog.Any("error", err),
+					slog.String("dir", f.flowDIR),
+				)
+			}
+		}
+	}
+}
+
+func compactKeyOf[T chanio.PersistentData](key func(T) string) func(chanio.PersistentData) string {
+	return func(v chanio.PersistentData) string {
+		if data, ok := v.(T); ok {
+			return key(data)
+		}
+
+		return ""
+	}
+}
@@ -3,19 +3,32 @@ package hub
 import (
 	"context"
 	originErr "errors"
+	"reflect"
+	"time"
 
+	"github.com/frozenpine/msgqueue/chanio"
 	"github.com/frozenpine/msgqueue/channel"
 	"github.com/frozenpine/msgqueue/core"
+	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
 )
 
+var persistentType = reflect.TypeFor[chanio.PersistentData]()
+
 var (
 	ErrInvalidHub     = originErr.New("invalid hub")
 	ErrNoSubcriber    = originErr.New("no subscriber")
 	ErrNoTopic        = originErr.New("no topic")
 	ErrTopicExist     = originErr.New("topic already exist")
+	ErrInvalidTopic   = originErr.New("invalid topic")
 	ErrHubClosed      = originErr.New("hub closed")
 	ErrInvalidChannel = originErr.New("invalid channel")
+	ErrNotPersistent  = originErr.New("topic data not persistent")
+	ErrTypeMismatch   = originErr.New("topic data type mismatch")
+
+	// errTopicNotCreated means topic exists in hub's backend,
+	// but channel not created as topic's data type is unknown
+	errTopicNotCreated = originErr.New("topic channel not created")
 )
 
 type ChannelCreateWrapper func(context.Context, string, int) (core.QueueBase, error)
@@ -42,6 +55,8 @@ func GetHubTopicChannel[T any](hub Hub, topic string) (channel.Channel[T], error
 			return ch, nil
 		}
 		return nil, errors.Wrap(ErrInvalidChannel, "channel type mismatch")
+	} else if err == errTopicNotCreated {
+		return GetOrCreateTopicChannel[T](hub, topic)
 	} else {
 		return nil, err
 	}
@@ -55,7 +70,7 @@ func GetOrCreateTopicChannel[T any](hub Hub, topic string) (channel.Channel[T],
 	if ch, err := hub.createTopicChannel(
 		topic,
 		func(ctx context.Context, name string, bufSize int) (core.QueueBase, error) {
-			return channel.NewChannel[T](ctx, name, bufSize)
+			return newTopicChannel[T](ctx, topic, name, bufSize)
 		},
 	); err == nil {
 		return ch.(channel.Channel[T]), nil
@@ -69,3 +84,153 @@ func GetOrCreateTopicChannel[T any](hub Hub, topic string) (channel.Channel[T],
 		return nil, err
 	}
 }
+
+// rawChannel is the type erased view of topic channel,
+// used by transports which don't know topic's data type
+type rawChannel interface {
+	core.QueueBase
+
+	dataType() string
+	// subscribeRaw delivers data with topic's seq of data
+	subscribeRaw(name string, resumeType core.ResumeType) (uuid.UUID, <-chan channel.Delivery[chanio.PersistentData], error)
+	// publishRaw waits until published or ctx done
+	publishRaw(ctx context.Context, v chanio.PersistentData) error
+	UnSubscribe(subID uuid.UUID) error
+}
+
+func newTopicChannel[T any](ctx context.Context, topic, name string, bufSize int) (core.QueueBase, error) {
+	if typ, _ := ctx.Value(core.CtxQueueType).(core.Type); typ == core.Remote {
+		return newRemoteChannel[T](ctx, topic, name, bufSize)
+	}
+
+	if ch, err := channel.NewChannel[T](ctx, name, bufSize); err != nil {
+		return nil, err
+	} else {
+		return &topicChannel[T]{Channel: ch}, nil
+	}
+}
+
+type topicChannel[T any] struct {
+	channel.Channel[T]
+}
+
+func (ch *topicChannel[T]) dataType() string {
+	return reflect.TypeFor[T]().String()
+}
+
+// SubscribeWith subscribes topic channel with options,
+// topicChannel embeds channel interface, so it's forwarded here
+func (ch *topicChannel[T]) SubscribeWith(name string, resumeType core.ResumeType, opts ...channel.SubOption) (uuid.UUID, <-chan T, error) {
+	consumer, ok := ch.Channel.(channel.OptionConsumer[T])
+	if !ok {
+		return uuid.Nil, nil, errors.Wrapf(ErrInvalidChannel, "%T without options", ch.Channel)
+	}
+
+	return consumer.SubscribeWith(name, resumeType, opts...)
+}
+
+// SubscribeSeq subscribes topic channel with data's seq
+func (ch *topicChannel[T]) SubscribeSeq(name string, resumeType core.ResumeType, opts ...channel.SubOption) (uuid.UUID, <-chan channel.Delivery[T], error) {
+	consumer, ok := ch.Channel.(channel.SeqConsumer[T])
+	if !ok {
+		return uuid.Nil, nil, errors.Wrapf(ErrInvalidChannel, "%T without seq", ch.Channel)
+	}
+
+	return consumer.SubscribeSeq(name, resumeType, opts...)
+}
+
+// SubscribeAck subscribes topic channel in ack mode
+func (ch *topicChannel[T]) SubscribeAck(name string, resumeType core.ResumeType, opts ...channel.SubOption) (uuid.UUID, <-chan channel.Delivery[T], error) {
+	consumer, ok := ch.Channel.(channel.AckConsumer[T])
+	if !ok {
+		return uuid.Nil, nil, errors.Wrapf(ErrInvalidChannel, "%T without ack", ch.Channel)
+	}
+
+	return consumer.SubscribeAck(name, resumeType, opts...)
+}
+
+// Ack acknowledges seq of ack mode subscriber
+func (ch *topicChannel[T]) Ack(subID uuid.UUID, seq uint64) error {
+	consumer, ok := ch.Channel.(channel.AckConsumer[T])
+	if !ok {
+		return errors.Wrapf(ErrInvalidChannel, "%T without ack", ch.Channel)
+	}
+
+	return consumer.Ack(subID, seq)
+}
+
+func (ch *topicChannel[T]) subscribeRaw(name string, resumeType core.ResumeType) (uuid.UUID, <-chan channel.Delivery[chanio.PersistentData], error) {
+	return subscribeRaw(func(name string, resumeType core.ResumeType) (uuid.UUID, <-chan channel.Delivery[T], error) {
+		return ch.SubscribeSeq(name, resumeType)
+	}, name, resumeType)
+}
+
+func (ch *topicChannel[T]) publishRaw(ctx context.Context, v chanio.PersistentData) error {
+	return publishRaw[T](ctx, ch, v)
+}
+
+// publishWaitSlice is the max timeout of each publish try,
+// so ctx is checked while waiting for channel
+const publishWaitSlice = 100 * time.Millisecond
+
+func publishRaw[T any](ctx context.Context, ch core.Producer[T], v chanio.PersistentData) error {
+	data, ok := v.(T)
+	if !ok {
+		return errors.Wrapf(
+			ErrTypeMismatch, "%T to %s",
+			v, reflect.TypeFor[T]().String(),
+		)
+	}
+
+	for {
+		timeout := publishWaitSlice
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = min(timeout, time.Until(deadline))
+		}
+
+		// non-positive timeout means waiting forever in Publish
+		if timeout <= 0 {
+			return errors.Wrap(core.ErrPubTimeout, "publish deadline exceeded")
+		}
+
+		err := ch.Publish(data, timeout)
+		if !errors.Is(err, core.ErrPubTimeout) {
+			return err
+		}
+
+		if err := ctx.Err(); errors.Is(err, context.DeadlineExceeded) {
+			return errors.Wrap(core.ErrPubTimeout, "publish deadline exceeded")
+		} else if err != nil {
+			return errors.Wrap(err, "publish canceled")
+		}
+	}
+}
+
+func subscribeRaw[T any](
+	subscribe func(string, core.ResumeType) (uuid.UUID, <-chan channel.Delivery[T], error),
+	name string, resumeType core.ResumeType,
+) (uuid.UUID, <-chan channel.Delivery[chanio.PersistentData], error) {
+	if !reflect.TypeFor[T]().Implements(persistentType) {
+		return uuid.Nil, nil, errors.Wrap(ErrNotPersistent, reflect.TypeFor[T]().String())
+	}
+
+	subID, src, err := subscribe(name, resumeType)
+	if err != nil {
+		return subID, nil, err
+	}
+
+	result := make(chan channel.Delivery[chanio.PersistentData], cap(src))
+
+	go func() {
+		defer close(result)
+
+		for v := range src {
+			result <- channel.Delivery[chanio.PersistentData]{
+				Seq:  v.Seq,
+				Data: any(v.Data).(chanio.PersistentData),
+			}
+		}
+	}()
+
+	return subID, result, nil
+}
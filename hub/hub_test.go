@@ -2,10 +2,23 @@ package hub
 
 import (
 	"context"
+	"encoding/binary"
+	"io"
+	"net"
 	"testing"
 	"time"
 
+	"github.com/frozenpine/msgqueue/chanio"
+	"github.com/frozenpine/msgqueue/channel"
 	"github.com/frozenpine/msgqueue/core"
+	"github.com/frozenpine/msgqueue/hub/protocol"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 func TestMemoHub(t *testing.T) {
@@ -42,7 +55,11 @@ func TestMemoHub(t *testing.T) {
 
 		t.Log(topicCh.Name(), topicCh.ID(), err)
 
-		subID, data := topicCh.Subscribe("test1", core.Quick)
+		subID, data, err := topicCh.Subscribe("test1", core.Quick)
+		if err != nil {
+			t.Error("subscribe failed:", err)
+			return
+		}
 
 		t.Logf("channel sub id: %+v", subID)
 
@@ -70,3 +87,497 @@ func TestMemoHub(t *testing.T) {
 
 	<-done
 }
+
+type Int struct {
+	int
+}
+
+func (v Int) Serialize() []byte {
+	result := make([]byte, 4)
+
+	binary.LittleEndian.PutUint32(result, uint32(v.int))
+
+	return result
+}
+
+func (v *Int) Deserialize(data []byte) error {
+	v.int = int(binary.LittleEndian.Uint32(data))
+	return nil
+}
+
+// Long is another data type with same serialization as Int
+type Long struct {
+	Int
+}
+
+func TestPersistentHub(t *testing.T) {
+	chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	dir := t.TempDir()
+	topic := "integer"
+	vCount := 100
+
+	hub, err := NewPersistentHub(context.TODO(), "persist", dir, -1)
+	if err != nil {
+		t.Fatal("create hub failed:", err)
+	}
+
+	if hub.Type() != core.Persistent {
+		t.Fatal("hub type mismatch:", hub.Type())
+	}
+
+	if _, err := GetOrCreateTopicChannel[int](hub, "invalid"); err == nil {
+		t.Fatal("non persistent channel should fail")
+	}
+
+	topicCh, err := GetOrCreateTopicChannel[*Int](hub, topic)
+	if err != nil {
+		t.Fatal("create channel failed:", err)
+	}
+
+	for idx := 0; idx < vCount; idx++ {
+		if err := topicCh.Publish(&Int{idx}, -1); err != nil {
+			t.Fatal("publish error:", err)
+		}
+	}
+
+	hub.Release()
+	hub.Join()
+
+	if hub, err = NewPersistentHub(context.TODO(), "persist", dir, -1); err != nil {
+		t.Fatal("reload hub failed:", err)
+	}
+
+	if topics := hub.Topics(); len(topics) != 1 || topics[0] != topic {
+		t.Fatal("topics mismatch:", topics)
+	}
+
+	if topicCh, err = GetOrCreateTopicChannel[*Int](hub, topic); err != nil {
+		t.Fatal("reload channel failed:", err)
+	}
+
+	_, data, err := topicCh.Subscribe("test1", core.Restart)
+	if err != nil {
+		t.Fatal("subscribe failed:", err)
+	}
+
+	if err := topicCh.Publish(&Int{vCount}, -1); err != nil {
+		t.Fatal("publish error:", err)
+	}
+
+	for idx := 0; idx <= vCount; idx++ {
+		if v := <-data; v.int != idx {
+			t.Fatalf("replay data mismatch: %d %d", v.int, idx)
+		}
+	}
+
+	// ack mode is available through topic channel
+	acker, ok := topicCh.(channel.AckConsumer[*Int])
+	if !ok {
+		t.Fatalf("topic channel without ack: %T", topicCh)
+	}
+
+	ackID, deliveries, err := acker.SubscribeAck("acker", core.Restart)
+	if err != nil {
+		t.Fatal("subscribe ack failed:", err)
+	}
+
+	if v := <-deliveries; v.Seq != 0 || v.Data.int != 0 {
+		t.Fatal("ack delivery mismatch:", v)
+	}
+	if err := acker.Ack(ackID, 0); err != nil {
+		t.Fatal("ack failed:", err)
+	}
+	if err := topicCh.UnSubscribe(ackID); err != nil {
+		t.Fatal("unsubscribe ack failed:", err)
+	}
+
+	hub.Release()
+	hub.Join()
+
+	if _, ok := <-data; ok {
+		t.Fatal("sub channel should be closed")
+	}
+}
+
+func serveHub(hub Hub) (grpc.DialOption, func()) {
+	listener := bufconn.Listen(1024 * 1024)
+	svr := grpc.NewServer()
+	protocol.RegisterHubServiceServer(svr, NewHubServer(hub))
+
+	go svr.Serve(listener)
+
+	return grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}), svr.Stop
+}
+
+func TestHubServer(t *testing.T) {
+	chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	hub, err := NewPersistentHub(context.TODO(), "server", t.TempDir(), -1)
+	if err != nil {
+		t.Fatal("create hub failed:", err)
+	}
+	defer func() {
+		hub.Release()
+		hub.Join()
+	}()
+
+	topic := "integer"
+	vCount := 10
+
+	topicCh, err := GetOrCreateTopicChannel[*Int](hub, topic)
+	if err != nil {
+		t.Fatal("create channel failed:", err)
+	}
+
+	for idx := 0; idx < vCount; idx++ {
+		if err := topicCh.Publish(&Int{idx}, -1); err != nil {
+			t.Fatal("publish error:", err)
+		}
+	}
+
+	dialer, stop := serveHub(hub)
+	defer stop()
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet", dialer,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal("dial failed:", err)
+	}
+	defer conn.Close()
+
+	client := protocol.NewHubServiceClient(conn)
+
+	if topics, err := client.GetTopics(context.TODO(), &emptypb.Empty{}); err != nil {
+		t.Fatal("get topics failed:", err)
+	} else if typ, exist := topics.GetDefine()[topic]; !exist || typ != "*hub.Int" {
+		t.Fatal("topics mismatch:", topics.GetDefine())
+	}
+
+	if stream, err := client.Subscribe(context.TODO(), &protocol.ReqSub{
+		Topic: "unknown",
+	}); err == nil {
+		if _, err = stream.Recv(); status.Code(err) != codes.NotFound {
+			t.Fatal("subscribe unknown topic should fail:", err)
+		}
+	}
+
+	subscribe := func(name string) protocol.HubService_SubscribeClient {
+		stream, err := client.Subscribe(context.TODO(), &protocol.ReqSub{
+			Topic:      topic,
+			Subscriber: name,
+			ResumeType: protocol.ResumeType_Restart,
+		})
+		if err != nil {
+			t.Fatal("subscribe failed:", err)
+		}
+
+		// wait subscription created
+		if _, err := stream.Header(); err != nil {
+			t.Fatal("subscribe failed:", err)
+		}
+
+		return stream
+	}
+
+	recv := func(stream protocol.HubService_SubscribeClient, expect int) {
+		rtn, err := stream.Recv()
+		if err != nil {
+			t.Fatal("recv failed:", err)
+		}
+
+		v := Int{}
+		if err := v.Deserialize(rtn.GetData()); err != nil {
+			t.Fatal("decode failed:", err)
+		}
+
+		// seq is topic's sequence, so it's same for all subscribers
+		if v.int != expect || rtn.GetSeq() != uint64(expect) || rtn.GetTopic() != topic {
+			t.Fatalf("data mismatch: %d %d %+v", v.int, expect, rtn)
+		}
+	}
+
+	stream := subscribe("remote")
+	other := subscribe("other")
+
+	// duplicated subscriber is rejected and won't affect existing one
+	if dup, err := client.Subscribe(context.TODO(), &protocol.ReqSub{
+		Topic: topic, Subscriber: "remote",
+	}); err == nil {
+		if _, err = dup.Recv(); status.Code(err) != codes.AlreadyExists {
+			t.Fatal("duplicated subscriber should fail:", err)
+		}
+	}
+
+	if err := topicCh.Publish(&Int{vCount}, -1); err != nil {
+		t.Fatal("publish error:", err)
+	}
+
+	for idx := 0; idx <= vCount; idx++ {
+		recv(stream, idx)
+		recv(other, idx)
+	}
+
+	if rsp, err := client.UnSubscribe(context.TODO(), &protocol.ReqUnSub{
+		Topic: topic,
+		SubId: core.GenID("remote").String(),
+	}); err != nil || rsp.GetErrorId() != 0 {
+		t.Fatal("unsubscribe failed:", rsp, err)
+	}
+
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatal("stream should be finished:", err)
+	}
+}
+
+func TestRemoteHub(t *testing.T) {
+	chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+	chanio.RegisterType(&Long{}, func() chanio.PersistentData {
+		return &Long{}
+	})
+
+	hub, err := NewPersistentHub(context.TODO(), "server", t.TempDir(), -1)
+	if err != nil {
+		t.Fatal("create hub failed:", err)
+	}
+	defer func() {
+		hub.Release()
+		hub.Join()
+	}()
+
+	topic := "integer"
+	vCount := 10
+
+	topicCh, err := GetOrCreateTopicChannel[*Int](hub, topic)
+	if err != nil {
+		t.Fatal("create channel failed:", err)
+	}
+
+	for idx := 0; idx < vCount; idx++ {
+		if err := topicCh.Publish(&Int{idx}, -1); err != nil {
+			t.Fatal("publish error:", err)
+		}
+	}
+
+	dialer, stop := serveHub(hub)
+	defer stop()
+
+	remote, err := NewRemoteHub(
+		context.TODO(), "remote", "passthrough:///bufnet", -1, dialer,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal("create remote hub failed:", err)
+	}
+
+	if remote.Type() != core.Remote {
+		t.Fatal("hub type mismatch:", remote.Type())
+	}
+
+	if topics := remote.Topics(); len(topics) != 1 || topics[0] != topic {
+		t.Fatal("remote topics mismatch:", topics)
+	}
+
+	if _, err := GetHubTopicChannel[*Int](remote, "unknown"); err != ErrNoTopic {
+		t.Fatal("unknown topic should not exist:", err)
+	}
+
+	if _, err := GetHubTopicChannel[*Long](remote, topic); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatal("remote topic type should mismatch:", err)
+	}
+
+	remoteCh, err := GetHubTopicChannel[*Int](remote, topic)
+	if err != nil {
+		t.Fatal("get remote channel failed:", err)
+	}
+
+	if _, _, err := remoteCh.Subscribe("invalid", 100); err == nil {
+		t.Fatal("invalid resume type should fail")
+	}
+
+	subID, data, err := remoteCh.Subscribe("remote", core.Restart)
+	if err != nil {
+		t.Fatal("subscribe remote failed:", err)
+	}
+
+	if subID != core.GenID("remote") {
+		t.Fatal("sub id mismatch:", subID)
+	}
+
+	other, err := NewRemoteHub(
+		context.TODO(), "other", "passthrough:///bufnet", -1, dialer,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal("create remote hub failed:", err)
+	}
+
+	otherCh, err := GetHubTopicChannel[*Int](other, topic)
+	if err != nil {
+		t.Fatal("get remote channel failed:", err)
+	}
+
+	// same name is rejected by server for other client
+	if _, _, err := otherCh.Subscribe("remote", core.Quick); !errors.Is(err, core.ErrAlreadySubscribed) {
+		t.Fatal("duplicated remote subscriber should fail:", err)
+	}
+
+	other.Release()
+	other.Join()
+
+	if err := topicCh.Publish(&Int{vCount}, -1); err != nil {
+		t.Fatal("publish error:", err)
+	}
+
+	for idx := 0; idx <= vCount; idx++ {
+		if v := <-data; v.int != idx {
+			t.Fatalf("remote data mismatch: %d %d", v.int, idx)
+		}
+	}
+
+	if err := remoteCh.UnSubscribe(subID); err != nil {
+		t.Fatal("unsubscribe remote failed:", err)
+	}
+
+	if _, ok := <-data; ok {
+		t.Fatal("sub channel should be closed")
+	}
+
+	remote.Release()
+	remote.Join()
+}
+
+func TestRemotePublish(t *testing.T) {
+	chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	topic := "integer"
+	vCount := 10
+
+	// local subscriber reads after all data published
+	hub, err := NewPersistentHub(context.TODO(), "server", t.TempDir(), vCount*2)
+	if err != nil {
+		t.Fatal("create hub failed:", err)
+	}
+	defer func() {
+		hub.Release()
+		hub.Join()
+	}()
+
+	topicCh, err := GetOrCreateTopicChannel[*Int](hub, topic)
+	if err != nil {
+		t.Fatal("create channel failed:", err)
+	}
+
+	_, data, err := topicCh.Subscribe("local", core.Quick)
+	if err != nil {
+		t.Fatal("subscribe failed:", err)
+	}
+
+	dialer, stop := serveHub(hub)
+	defer stop()
+
+	remote, err := NewRemoteHub(
+		context.TODO(), "remote", "passthrough:///bufnet", -1, dialer,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal("create remote hub failed:", err)
+	}
+	defer func() {
+		remote.Release()
+		remote.Join()
+	}()
+
+	unknownCh, err := GetOrCreateTopicChannel[*Int](remote, "unknown")
+	if err != nil {
+		t.Fatal("create remote channel failed:", err)
+	}
+
+	if err := unknownCh.Publish(&Int{0}, -1); !errors.Is(err, ErrNoTopic) {
+		t.Fatal("publish to unknown topic should fail:", err)
+	}
+
+	remoteCh, err := GetHubTopicChannel[*Int](remote, topic)
+	if err != nil {
+		t.Fatal("get remote channel failed:", err)
+	}
+
+	for idx := 0; idx < vCount; idx++ {
+		if err := remoteCh.Publish(&Int{idx}, time.Second); err != nil {
+			t.Fatal("remote publish failed:", err)
+		}
+	}
+
+	src := channel.NewMemoChannel[*Int](context.TODO(), "src", 0)
+
+	if err := remoteCh.PipelineUpStream(src); err != nil {
+		t.Fatal("pipeline remote channel failed:", err)
+	}
+
+	for idx := vCount; idx < vCount*2; idx++ {
+		if err := src.Publish(&Int{idx}, -1); err != nil {
+			t.Fatal("src publish failed:", err)
+		}
+	}
+
+	for idx := 0; idx < vCount*2; idx++ {
+		if v := <-data; v.int != idx {
+			t.Fatalf("published data mismatch: %d %d", v.int, idx)
+		}
+	}
+
+	src.Release()
+	src.Join()
+}
+
+func TestPublishRawContext(t *testing.T) {
+	ctx := context.WithValue(context.TODO(), core.CtxSlowPolicy, channel.Block)
+	ch := channel.NewMemoChannel[*Int](ctx, "", 1)
+
+	subID, _, err := ch.Subscribe("block", core.Quick)
+	if err != nil {
+		t.Fatal("subscribe failed:", err)
+	}
+
+	// fill until dispatcher blocked by subscriber not reading
+	for {
+		if err := ch.Publish(&Int{0}, 10*time.Millisecond); errors.Is(err, core.ErrPubTimeout) {
+			break
+		} else if err != nil {
+			t.Fatal("publish failed:", err)
+		}
+	}
+
+	deadline, cancelDeadline := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancelDeadline()
+
+	if err := publishRaw[*Int](deadline, ch, &Int{1}); !errors.Is(err, core.ErrPubTimeout) {
+		t.Fatal("publish after deadline should time out:", err)
+	}
+
+	canceled, cancel := context.WithCancel(context.TODO())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	if err := publishRaw[*Int](canceled, ch, &Int{1}); !errors.Is(err, context.Canceled) {
+		t.Fatal("publish waiting forever should stop by canceled ctx:", err)
+	}
+
+	if err := ch.UnSubscribe(subID); err != nil {
+		t.Fatal("unsubscribe failed:", err)
+	}
+
+	ch.Release()
+	ch.Join()
+}
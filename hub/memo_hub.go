@@ -20,6 +20,7 @@ type MemoHub struct {
 
 	chanLen int
 
+	createLock     sync.Mutex
 	topicChanCache sync.Map
 }
 
@@ -111,19 +112,25 @@ func (hub *MemoHub) Topics() []string {
 }
 
 func (hub *MemoHub) createTopicChannel(topic string, fn ChannelCreateWrapper) (core.QueueBase, error) {
+	return hub.createTopic(
+		context.WithValue(hub.runCtx, core.CtxQueueType, core.Memory),
+		topic, fn,
+	)
+}
+
+func (hub *MemoHub) createTopic(ctx context.Context, topic string, fn ChannelCreateWrapper) (core.QueueBase, error) {
+	hub.createLock.Lock()
+	defer hub.createLock.Unlock()
+
 	if ch, exist := hub.topicChanCache.Load(topic); exist {
 		return ch.(core.QueueBase), ErrTopicExist
 	}
 
-	if ch, err := fn(
-		context.WithValue(hub.runCtx, core.CtxQueueType, core.Memory),
-		hub.name+"."+topic,
-		hub.chanLen,
-	); err != nil {
+	if ch, err := fn(ctx, hub.name+"."+topic, hub.chanLen); err != nil {
 		return nil, err
 	} else {
-		result, _ := hub.topicChanCache.LoadOrStore(topic, ch)
-		return result.(core.QueueBase), nil
+		hub.topicChanCache.Store(topic, ch)
+		return ch, nil
 	}
 }
 
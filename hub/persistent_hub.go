@@ -1,5 +1,108 @@
 package hub
 
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/frozenpine/msgqueue/core"
+	"github.com/pkg/errors"
+)
+
+// PersistentHub is a hub whose topic channels write all published
+// data to per topic flows under flow dir, topics are reloaded from
+// flow dir when hub created
 type PersistentHub struct {
 	MemoHub
+
+	flowDIR    string
+	flowTopics sync.Map
+}
+
+func NewPersistentHub(ctx context.Context, name string, dir string, bufSize int) (*PersistentHub, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "create hub dir failed")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read hub dir failed")
+	}
+
+	hub := PersistentHub{flowDIR: dir}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		hub.flowTopics.Store(entry.Name(), struct{}{})
+
+		slog.Info(
+			"persistent topic loaded",
+			slog.String("topic", entry.Name()),
+			slog.String("dir", dir),
+		)
+	}
+
+	if name == "" {
+		name = "PersistentHub"
+	}
+
+	hub.Init(ctx, name, func() {
+		hub.chanLen = bufSize
+	})
+
+	return &hub, nil
+}
+
+func (hub *PersistentHub) Type() core.Type {
+	return core.Persistent
+}
+
+// Topics get all topics in hub, include topics loaded from flow dir
+// which channel not created yet
+func (hub *PersistentHub) Topics() []string {
+	topics := []string{}
+
+	hub.flowTopics.Range(func(key, value any) bool {
+		topics = append(topics, key.(string))
+		return true
+	})
+
+	sort.Strings(topics)
+
+	return topics
+}
+
+func (hub *PersistentHub) createTopicChannel(topic string, fn ChannelCreateWrapper) (core.QueueBase, error) {
+	if topic == "" || topic == "." || topic == ".." || filepath.Base(topic) != topic {
+		return nil, errors.Wrapf(ErrInvalidTopic, "%q", topic)
+	}
+
+	ctx := context.WithValue(hub.runCtx, core.CtxQueueType, core.Persistent)
+	ctx = context.WithValue(ctx, core.CtxFlowDir, filepath.Join(hub.flowDIR, topic))
+
+	ch, err := hub.createTopic(ctx, topic, fn)
+
+	if err == nil {
+		hub.flowTopics.Store(topic, struct{}{})
+	}
+
+	return ch, err
+}
+
+func (hub *PersistentHub) getTopicChannel(topic string) (core.QueueBase, error) {
+	ch, err := hub.MemoHub.getTopicChannel(topic)
+
+	if err == ErrNoTopic {
+		if _, exist := hub.flowTopics.Load(topic); exist {
+			return nil, errTopicNotCreated
+		}
+	}
+
+	return ch, err
 }
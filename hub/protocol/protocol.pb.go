@@ -290,13 +290,15 @@ func (x *ReqUnSub) GetSubId() string {
 	return ""
 }
 
+// RtnData is data of topic, seq is topic's sequence of data,
+// so same data has same seq for all subscribers
 type RtnData struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
 	Topic string `protobuf:"bytes,1,opt,name=topic,proto3" json:"topic,omitempty"`
-	Seq   uint32 `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+	Seq   uint64 `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
 	Len   uint32 `protobuf:"varint,3,opt,name=len,proto3" json:"len,omitempty"`
 	Data  []byte `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
 }
@@ -340,7 +342,7 @@ func (x *RtnData) GetTopic() string {
 	return ""
 }
 
-func (x *RtnData) GetSeq() uint32 {
+func (x *RtnData) GetSeq() uint64 {
 	if x != nil {
 		return x.Seq
 	}
@@ -361,6 +363,144 @@ func (x *RtnData) GetData() []byte {
 	return nil
 }
 
+// ReqPub publish one message to topic, seq is assigned by publisher
+// and echoed in RspPub, timeout is publish timeout in milliseconds,
+// non-positive timeout means waiting until published
+type ReqPub struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Topic   string `protobuf:"bytes,1,opt,name=topic,proto3" json:"topic,omitempty"`
+	Seq     uint64 `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+	Tid     uint32 `protobuf:"varint,3,opt,name=tid,proto3" json:"tid,omitempty"`
+	Data    []byte `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	Timeout int64  `protobuf:"zigzag64,5,opt,name=timeout,proto3" json:"timeout,omitempty"`
+}
+
+func (x *ReqPub) Reset() {
+	*x = ReqPub{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_protocol_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ReqPub) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReqPub) ProtoMessage() {}
+
+func (x *ReqPub) ProtoReflect() protoreflect.Message {
+	mi := &file_protocol_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReqPub.ProtoReflect.Descriptor instead.
+func (*ReqPub) Descriptor() ([]byte, []int) {
+	return file_protocol_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ReqPub) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+func (x *ReqPub) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *ReqPub) GetTid() uint32 {
+	if x != nil {
+		return x.Tid
+	}
+	return 0
+}
+
+func (x *ReqPub) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *ReqPub) GetTimeout() int64 {
+	if x != nil {
+		return x.Timeout
+	}
+	return 0
+}
+
+// RspPub is the ack of ReqPub with same seq
+type RspPub struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Seq  uint64   `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	Info *RspInfo `protobuf:"bytes,2,opt,name=info,proto3" json:"info,omitempty"`
+}
+
+func (x *RspPub) Reset() {
+	*x = RspPub{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_protocol_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RspPub) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RspPub) ProtoMessage() {}
+
+func (x *RspPub) ProtoReflect() protoreflect.Message {
+	mi := &file_protocol_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RspPub.ProtoReflect.Descriptor instead.
+func (*RspPub) Descriptor() ([]byte, []int) {
+	return file_protocol_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RspPub) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *RspPub) GetInfo() *RspInfo {
+	if x != nil {
+		return x.Info
+	}
+	return nil
+}
+
 var File_protocol_proto protoreflect.FileDescriptor
 
 var file_protocol_proto_rawDesc = []byte{
@@ -393,25 +533,39 @@ var file_protocol_proto_rawDesc = []byte{
 	0x73, 0x75, 0x62, 0x49, 0x64, 0x22, 0x57, 0x0a, 0x07, 0x52, 0x74, 0x6e, 0x44, 0x61, 0x74, 0x61,
 	0x12, 0x14, 0x0a, 0x05, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
 	0x05, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x65, 0x71, 0x18, 0x02, 0x20,
-	0x01, 0x28, 0x0d, 0x52, 0x03, 0x73, 0x65, 0x71, 0x12, 0x10, 0x0a, 0x03, 0x6c, 0x65, 0x6e, 0x18,
+	0x01, 0x28, 0x04, 0x52, 0x03, 0x73, 0x65, 0x71, 0x12, 0x10, 0x0a, 0x03, 0x6c, 0x65, 0x6e, 0x18,
 	0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x6c, 0x65, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61,
-	0x74, 0x61, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x2a, 0x30,
-	0x0a, 0x0a, 0x52, 0x65, 0x73, 0x75, 0x6d, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0b, 0x0a, 0x07,
-	0x52, 0x65, 0x73, 0x74, 0x61, 0x72, 0x74, 0x10, 0x00, 0x12, 0x0a, 0x0a, 0x06, 0x52, 0x65, 0x73,
-	0x75, 0x6d, 0x65, 0x10, 0x01, 0x12, 0x09, 0x0a, 0x05, 0x51, 0x75, 0x69, 0x63, 0x6b, 0x10, 0x02,
-	0x32, 0xad, 0x01, 0x0a, 0x0a, 0x48, 0x75, 0x62, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12,
-	0x35, 0x0a, 0x09, 0x47, 0x65, 0x74, 0x54, 0x6f, 0x70, 0x69, 0x63, 0x73, 0x12, 0x16, 0x2e, 0x67,
-	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45,
-	0x6d, 0x70, 0x74, 0x79, 0x1a, 0x10, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x2e,
-	0x54, 0x6f, 0x70, 0x69, 0x63, 0x73, 0x12, 0x32, 0x0a, 0x09, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72,
-	0x69, 0x62, 0x65, 0x12, 0x10, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x2e, 0x52,
-	0x65, 0x71, 0x53, 0x75, 0x62, 0x1a, 0x11, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c,
-	0x2e, 0x52, 0x74, 0x6e, 0x44, 0x61, 0x74, 0x61, 0x30, 0x01, 0x12, 0x34, 0x0a, 0x0b, 0x55, 0x6e,
-	0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x12, 0x12, 0x2e, 0x70, 0x72, 0x6f, 0x74,
-	0x6f, 0x63, 0x6f, 0x6c, 0x2e, 0x52, 0x65, 0x71, 0x55, 0x6e, 0x53, 0x75, 0x62, 0x1a, 0x11, 0x2e,
-	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x2e, 0x52, 0x73, 0x70, 0x49, 0x6e, 0x66, 0x6f,
-	0x42, 0x0b, 0x5a, 0x09, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x62, 0x06, 0x70,
-	0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x74, 0x61, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x70,
+	0x0a, 0x06, 0x52, 0x65, 0x71, 0x50, 0x75, 0x62, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x6f, 0x70, 0x69,
+	0x63, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x74, 0x6f, 0x70, 0x69, 0x63, 0x12, 0x10,
+	0x0a, 0x03, 0x73, 0x65, 0x71, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x73, 0x65, 0x71,
+	0x12, 0x10, 0x0a, 0x03, 0x74, 0x69, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x74,
+	0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x18, 0x0a, 0x07, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75,
+	0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x12, 0x52, 0x07, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74,
+	0x22, 0x41, 0x0a, 0x06, 0x52, 0x73, 0x70, 0x50, 0x75, 0x62, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x65,
+	0x71, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x73, 0x65, 0x71, 0x12, 0x25, 0x0a, 0x04,
+	0x69, 0x6e, 0x66, 0x6f, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x2e, 0x52, 0x73, 0x70, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x04, 0x69,
+	0x6e, 0x66, 0x6f, 0x2a, 0x30, 0x0a, 0x0a, 0x52, 0x65, 0x73, 0x75, 0x6d, 0x65, 0x54, 0x79, 0x70,
+	0x65, 0x12, 0x0b, 0x0a, 0x07, 0x52, 0x65, 0x73, 0x74, 0x61, 0x72, 0x74, 0x10, 0x00, 0x12, 0x0a,
+	0x0a, 0x06, 0x52, 0x65, 0x73, 0x75, 0x6d, 0x65, 0x10, 0x01, 0x12, 0x09, 0x0a, 0x05, 0x51, 0x75,
+	0x69, 0x63, 0x6b, 0x10, 0x02, 0x32, 0xe0, 0x01, 0x0a, 0x0a, 0x48, 0x75, 0x62, 0x53, 0x65, 0x72,
+	0x76, 0x69, 0x63, 0x65, 0x12, 0x35, 0x0a, 0x09, 0x47, 0x65, 0x74, 0x54, 0x6f, 0x70, 0x69, 0x63,
+	0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x10, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x63, 0x6f, 0x6c, 0x2e, 0x54, 0x6f, 0x70, 0x69, 0x63, 0x73, 0x12, 0x32, 0x0a, 0x09, 0x53,
+	0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x12, 0x10, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x63, 0x6f, 0x6c, 0x2e, 0x52, 0x65, 0x71, 0x53, 0x75, 0x62, 0x1a, 0x11, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x2e, 0x52, 0x74, 0x6e, 0x44, 0x61, 0x74, 0x61, 0x30, 0x01, 0x12,
+	0x34, 0x0a, 0x0b, 0x55, 0x6e, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x12, 0x12,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x2e, 0x52, 0x65, 0x71, 0x55, 0x6e, 0x53,
+	0x75, 0x62, 0x1a, 0x11, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x2e, 0x52, 0x73,
+	0x70, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x31, 0x0a, 0x07, 0x50, 0x75, 0x62, 0x6c, 0x69, 0x73, 0x68,
+	0x12, 0x10, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x2e, 0x52, 0x65, 0x71, 0x50,
+	0x75, 0x62, 0x1a, 0x10, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x2e, 0x52, 0x73,
+	0x70, 0x50, 0x75, 0x62, 0x28, 0x01, 0x30, 0x01, 0x42, 0x0b, 0x5a, 0x09, 0x2f, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -427,7 +581,7 @@ func file_protocol_proto_rawDescGZIP() []byte {
 }
 
 var file_protocol_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_protocol_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_protocol_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
 var file_protocol_proto_goTypes = []interface{}{
 	(ResumeType)(0),       // 0: protocol.ResumeType
 	(*Topics)(nil),        // 1: protocol.Topics
@@ -435,23 +589,28 @@ var file_protocol_proto_goTypes = []interface{}{
 	(*ReqSub)(nil),        // 3: protocol.ReqSub
 	(*ReqUnSub)(nil),      // 4: protocol.ReqUnSub
 	(*RtnData)(nil),       // 5: protocol.RtnData
-	nil,                   // 6: protocol.Topics.DefineEntry
-	(*emptypb.Empty)(nil), // 7: google.protobuf.Empty
+	(*ReqPub)(nil),        // 6: protocol.ReqPub
+	(*RspPub)(nil),        // 7: protocol.RspPub
+	nil,                   // 8: protocol.Topics.DefineEntry
+	(*emptypb.Empty)(nil), // 9: google.protobuf.Empty
 }
 var file_protocol_proto_depIdxs = []int32{
-	6, // 0: protocol.Topics.define:type_name -> protocol.Topics.DefineEntry
+	8, // 0: protocol.Topics.define:type_name -> protocol.Topics.DefineEntry
 	0, // 1: protocol.ReqSub.resume_type:type_name -> protocol.ResumeType
-	7, // 2: protocol.HubService.GetTopics:input_type -> google.protobuf.Empty
-	3, // 3: protocol.HubService.Subscribe:input_type -> protocol.ReqSub
-	4, // 4: protocol.HubService.UnSubscribe:input_type -> protocol.ReqUnSub
-	1, // 5: protocol.HubService.GetTopics:output_type -> protocol.Topics
-	5, // 6: protocol.HubService.Subscribe:output_type -> protocol.RtnData
-	2, // 7: protocol.HubService.UnSubscribe:output_type -> protocol.RspInfo
-	5, // [5:8] is the sub-list for method output_type
-	2, // [2:5] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	2, // 2: protocol.RspPub.info:type_name -> protocol.RspInfo
+	9, // 3: protocol.HubService.GetTopics:input_type -> google.protobuf.Empty
+	3, // 4: protocol.HubService.Subscribe:input_type -> protocol.ReqSub
+	4, // 5: protocol.HubService.UnSubscribe:input_type -> protocol.ReqUnSub
+	6, // 6: protocol.HubService.Publish:input_type -> protocol.ReqPub
+	1, // 7: protocol.HubService.GetTopics:output_type -> protocol.Topics
+	5, // 8: protocol.HubService.Subscribe:output_type -> protocol.RtnData
+	2, // 9: protocol.HubService.UnSubscribe:output_type -> protocol.RspInfo
+	7, // 10: protocol.HubService.Publish:output_type -> protocol.RspPub
+	7, // [7:11] is the sub-list for method output_type
+	3, // [3:7] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_protocol_proto_init() }
@@ -520,6 +679,30 @@ func file_protocol_proto_init() {
 				return nil
 			}
 		}
+		file_protocol_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ReqPub); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_protocol_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RspPub); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -527,7 +710,7 @@ func file_protocol_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_protocol_proto_rawDesc,
 			NumEnums:      1,
-			NumMessages:   6,
+			NumMessages:   8,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
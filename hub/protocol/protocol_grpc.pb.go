@@ -26,6 +26,7 @@ type HubServiceClient interface {
 	GetTopics(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Topics, error)
 	Subscribe(ctx context.Context, in *ReqSub, opts ...grpc.CallOption) (HubService_SubscribeClient, error)
 	UnSubscribe(ctx context.Context, in *ReqUnSub, opts ...grpc.CallOption) (*RspInfo, error)
+	Publish(ctx context.Context, opts ...grpc.CallOption) (HubService_PublishClient, error)
 }
 
 type hubServiceClient struct {
@@ -86,6 +87,37 @@ func (c *hubServiceClient) UnSubscribe(ctx context.Context, in *ReqUnSub, opts .
 	return out, nil
 }
 
+func (c *hubServiceClient) Publish(ctx context.Context, opts ...grpc.CallOption) (HubService_PublishClient, error) {
+	stream, err := c.cc.NewStream(ctx, &HubService_ServiceDesc.Streams[1], "/protocol.HubService/Publish", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &hubServicePublishClient{stream}
+	return x, nil
+}
+
+type HubService_PublishClient interface {
+	Send(*ReqPub) error
+	Recv() (*RspPub, error)
+	grpc.ClientStream
+}
+
+type hubServicePublishClient struct {
+	grpc.ClientStream
+}
+
+func (x *hubServicePublishClient) Send(m *ReqPub) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *hubServicePublishClient) Recv() (*RspPub, error) {
+	m := new(RspPub)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // HubServiceServer is the server API for HubService service.
 // All implementations must embed UnimplementedHubServiceServer
 // for forward compatibility
@@ -93,6 +125,7 @@ type HubServiceServer interface {
 	GetTopics(context.Context, *emptypb.Empty) (*Topics, error)
 	Subscribe(*ReqSub, HubService_SubscribeServer) error
 	UnSubscribe(context.Context, *ReqUnSub) (*RspInfo, error)
+	Publish(HubService_PublishServer) error
 	mustEmbedUnimplementedHubServiceServer()
 }
 
@@ -109,6 +142,9 @@ func (UnimplementedHubServiceServer) Subscribe(*ReqSub, HubService_SubscribeServ
 func (UnimplementedHubServiceServer) UnSubscribe(context.Context, *ReqUnSub) (*RspInfo, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UnSubscribe not implemented")
 }
+func (UnimplementedHubServiceServer) Publish(HubService_PublishServer) error {
+	return status.Errorf(codes.Unimplemented, "method Publish not implemented")
+}
 func (UnimplementedHubServiceServer) mustEmbedUnimplementedHubServiceServer() {}
 
 // UnsafeHubServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -179,6 +215,32 @@ func _HubService_UnSubscribe_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _HubService_Publish_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(HubServiceServer).Publish(&hubServicePublishServer{stream})
+}
+
+type HubService_PublishServer interface {
+	Send(*RspPub) error
+	Recv() (*ReqPub, error)
+	grpc.ServerStream
+}
+
+type hubServicePublishServer struct {
+	grpc.ServerStream
+}
+
+func (x *hubServicePublishServer) Send(m *RspPub) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *hubServicePublishServer) Recv() (*ReqPub, error) {
+	m := new(ReqPub)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // HubService_ServiceDesc is the grpc.ServiceDesc for HubService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -201,6 +263,12 @@ var HubService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _HubService_Subscribe_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "Publish",
+			Handler:       _HubService_Publish_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "protocol.proto",
 }
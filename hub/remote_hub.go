@@ -0,0 +1,676 @@
+package hub
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/frozenpine/msgqueue/chanio"
+	"github.com/frozenpine/msgqueue/channel"
+	"github.com/frozenpine/msgqueue/core"
+	"github.com/frozenpine/msgqueue/hub/protocol"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	ctxRemoteClient core.CtxTypeKey = "remote_client"
+
+	defaultRemoteTimeout = 5 * time.Second
+)
+
+// RemoteHub is a hub client of HubService, topic channels in
+// RemoteHub subscribe data from remote hub
+type RemoteHub struct {
+	MemoHub
+
+	closeOnce sync.Once
+	conn      *grpc.ClientConn
+	client    protocol.HubServiceClient
+}
+
+// NewRemoteHub create client of HubService at target, target is resolved
+// by dns resolver as grpc.NewClient and connected when first used,
+// insecure credential will be used if no dial option specified
+func NewRemoteHub(ctx context.Context, name string, target string, bufSize int, opts ...grpc.DialOption) (*RemoteHub, error) {
+	if len(opts) == 0 {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "create remote hub client failed")
+	}
+
+	hub := RemoteHub{
+		conn:   conn,
+		client: protocol.NewHubServiceClient(conn),
+	}
+
+	if name == "" {
+		name = "RemoteHub"
+	}
+
+	hub.Init(ctx, name, func() {
+		hub.chanLen = bufSize
+	})
+
+	return &hub, nil
+}
+
+func (hub *RemoteHub) Type() core.Type {
+	return core.Remote
+}
+
+func (hub *RemoteHub) Release() {
+	hub.MemoHub.Release()
+
+	hub.closeOnce.Do(func() {
+		if err := hub.conn.Close(); err != nil {
+			slog.Error(
+				"close remote hub connection failed",
+				slog.Any("error", err),
+			)
+		}
+	})
+}
+
+func (hub *RemoteHub) remoteTopics() (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(hub.runCtx, defaultRemoteTimeout)
+	defer cancel()
+
+	topics, err := hub.client.GetTopics(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+
+	return topics.GetDefine(), nil
+}
+
+// Topics get topics from remote hub, only local created topics
+// will be returned if remote hub unavailable
+func (hub *RemoteHub) Topics() []string {
+	defines, err := hub.remoteTopics()
+	if err != nil {
+		slog.Error(
+			"get remote topics failed",
+			slog.Any("error", err),
+		)
+
+		return hub.MemoHub.Topics()
+	}
+
+	topics := make([]string, 0, len(defines))
+	for topic := range defines {
+		topics = append(topics, topic)
+	}
+
+	sort.Strings(topics)
+
+	return topics
+}
+
+func (hub *RemoteHub) createTopicChannel(topic string, fn ChannelCreateWrapper) (core.QueueBase, error) {
+	ctx := context.WithValue(hub.runCtx, core.CtxQueueType, core.Remote)
+	ctx = context.WithValue(ctx, ctxRemoteClient, hub.client)
+
+	return hub.createTopic(ctx, topic, fn)
+}
+
+func (hub *RemoteHub) getTopicChannel(topic string) (core.QueueBase, error) {
+	ch, err := hub.MemoHub.getTopicChannel(topic)
+
+	if err == ErrNoTopic {
+		if defines, rErr := hub.remoteTopics(); rErr != nil {
+			return nil, errors.Wrap(rErr, "get remote topics failed")
+		} else if _, exist := defines[topic]; exist {
+			return nil, errTopicNotCreated
+		}
+	}
+
+	return ch, err
+}
+
+type remoteSub struct {
+	cancel context.CancelFunc
+}
+
+// remoteChannel is a topic channel of RemoteHub,
+// each subscriber owns a Subscribe stream to remote hub
+type remoteChannel[T any] struct {
+	name        string
+	id          uuid.UUID
+	initOnce    sync.Once
+	releaseOnce sync.Once
+
+	runCtx   context.Context
+	cancelFn context.CancelFunc
+
+	chanLen int
+	topic   string
+	tid     chanio.TID
+	client  protocol.HubServiceClient
+
+	subscriberCache sync.Map
+	subscriberWg    sync.WaitGroup
+
+	upstreamCache sync.Map
+	upstreamWg    sync.WaitGroup
+
+	pubLock    sync.Mutex
+	pubStream  protocol.HubService_PublishClient
+	pubSeq     uint64
+	pubPending map[uint64]chan *protocol.RspInfo
+	pubWg      sync.WaitGroup
+}
+
+func newRemoteChannel[T any](ctx context.Context, topic, name string, bufSize int) (*remoteChannel[T], error) {
+	client, ok := ctx.Value(ctxRemoteClient).(protocol.HubServiceClient)
+	if !ok {
+		return nil, errors.Wrap(ErrInvalidHub, "remote client missing")
+	}
+
+	var v T
+
+	data, ok := any(v).(chanio.PersistentData)
+	if !ok {
+		return nil, errors.Wrap(ErrNotPersistent, reflect.TypeFor[T]().String())
+	}
+
+	tid, err := chanio.LookupType(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkRemoteType[T](ctx, client, topic); err != nil {
+		return nil, err
+	}
+
+	if bufSize <= 0 {
+		bufSize = 1
+	}
+
+	ch := remoteChannel[T]{}
+
+	ch.Init(ctx, name, func() {
+		ch.chanLen = bufSize
+		ch.topic = topic
+		ch.tid = tid
+		ch.client = client
+		ch.pubPending = make(map[uint64]chan *protocol.RspInfo)
+	})
+
+	return &ch, nil
+}
+
+// checkRemoteType checks data type of topic in remote hub,
+// topic not exist or not created in remote hub is not checked
+func checkRemoteType[T any](ctx context.Context, client protocol.HubServiceClient, topic string) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultRemoteTimeout)
+	defer cancel()
+
+	topics, err := client.GetTopics(ctx, &emptypb.Empty{})
+	if err != nil {
+		return errors.Wrap(err, "get remote topics failed")
+	}
+
+	dataType := topics.GetDefine()[topic]
+	if expect := reflect.TypeFor[T]().String(); dataType != "" && dataType != expect {
+		return errors.Wrapf(
+			ErrTypeMismatch, "topic %q is %s in remote, not %s",
+			topic, dataType, expect,
+		)
+	}
+
+	return nil
+}
+
+func (ch *remoteChannel[T]) Init(ctx context.Context, name string, extraInit func()) {
+	ch.initOnce.Do(func() {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		if name == "" {
+			name = "RemoteChan"
+		}
+
+		ch.runCtx, ch.cancelFn = context.WithCancel(ctx)
+		ch.name = core.GenName(name)
+		ch.id = core.GenID(ch.name)
+
+		if extraInit != nil {
+			extraInit()
+		}
+	})
+}
+
+func (ch *remoteChannel[T]) ID() uuid.UUID {
+	return ch.id
+}
+
+func (ch *remoteChannel[T]) Name() string {
+	return ch.name
+}
+
+func (ch *remoteChannel[T]) Release() {
+	ch.releaseOnce.Do(func() {
+		slog.Info(
+			"releasing remote channel",
+			slog.String("name", ch.name),
+			slog.String("topic", ch.topic),
+		)
+
+		ch.cancelFn()
+	})
+}
+
+func (ch *remoteChannel[T]) Join() {
+	<-ch.runCtx.Done()
+
+	ch.upstreamWg.Wait()
+	ch.subscriberWg.Wait()
+	ch.pubWg.Wait()
+}
+
+func (ch *remoteChannel[T]) dataType() string {
+	return reflect.TypeFor[T]().String()
+}
+
+func (ch *remoteChannel[T]) subscribeRaw(name string, resumeType core.ResumeType) (uuid.UUID, <-chan channel.Delivery[chanio.PersistentData], error) {
+	return subscribeRaw(ch.subscribeSeq, name, resumeType)
+}
+
+func (ch *remoteChannel[T]) publishRaw(ctx context.Context, v chanio.PersistentData) error {
+	return publishRaw[T](ctx, ch, v)
+}
+
+func convertCoreResumeType(typ core.ResumeType) (protocol.ResumeType, error) {
+	switch typ {
+	case core.Restart:
+		return protocol.ResumeType_Restart, nil
+	case core.Resume:
+		return protocol.ResumeType_Resume, nil
+	case core.Quick:
+		return protocol.ResumeType_Quick, nil
+	default:
+		return protocol.ResumeType_Quick, core.ErrInvalidResumeType
+	}
+}
+
+func (ch *remoteChannel[T]) Subscribe(name string, resumeType core.ResumeType) (uuid.UUID, <-chan T, error) {
+	return subscribeRemote(ch, name, resumeType, func(_ uint64, v T) T { return v })
+}
+
+// subscribeSeq subscribes remote topic with topic's seq of data
+func (ch *remoteChannel[T]) subscribeSeq(name string, resumeType core.ResumeType) (uuid.UUID, <-chan channel.Delivery[T], error) {
+	return subscribeRemote(ch, name, resumeType, func(seq uint64, v T) channel.Delivery[T] {
+		return channel.Delivery[T]{Seq: seq, Data: v}
+	})
+}
+
+// subscribeRemote receives data from remote topic, data
+// with its seq is wrapped by wrap before sent to result
+func subscribeRemote[T, R any](
+	ch *remoteChannel[T], name string, resumeType core.ResumeType,
+	wrap func(uint64, T) R,
+) (uuid.UUID, <-chan R, error) {
+	typ, err := convertCoreResumeType(resumeType)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	if name == "" {
+		name = core.GenName(ch.name)
+	}
+
+	ctx, cancel := context.WithCancel(ch.runCtx)
+
+	// name is reserved until subscribed, remote sub id is generated by name
+	reserved := core.GenID(name)
+	sub := &remoteSub{cancel: cancel}
+
+	if _, exist := ch.subscriberCache.LoadOrStore(reserved, sub); exist {
+		cancel()
+		return uuid.Nil, nil, core.ErrAlreadySubscribed
+	}
+
+	stream, err := ch.client.Subscribe(ctx, &protocol.ReqSub{
+		Topic:      ch.topic,
+		Subscriber: name,
+		ResumeType: typ,
+	})
+	if err != nil {
+		cancel()
+		ch.subscriberCache.CompareAndDelete(reserved, sub)
+		return uuid.Nil, nil, errors.Wrap(err, "subscribe remote topic failed")
+	}
+
+	// header will be sent after subscription created on remote
+	header, err := stream.Header()
+	if err == nil && len(header.Get(subIDHeader)) == 0 {
+		// stream finished without header, error is in stream's status
+		if _, err = stream.Recv(); err == nil {
+			err = errors.New("sub id header missing")
+		}
+	}
+
+	if err != nil {
+		cancel()
+		ch.subscriberCache.CompareAndDelete(reserved, sub)
+
+		if status.Code(err) == codes.AlreadyExists {
+			return uuid.Nil, nil, errors.Wrap(
+				core.ErrAlreadySubscribed, status.Convert(err).Message(),
+			)
+		}
+
+		return uuid.Nil, nil, errors.Wrap(err, "subscribe remote topic failed")
+	}
+
+	subID, err := uuid.FromString(header.Get(subIDHeader)[0])
+	if err == nil && subID != reserved {
+		err = errors.Errorf("sub id %s not generated by name %q", subID, name)
+	}
+	if err != nil {
+		cancel()
+		ch.subscriberCache.CompareAndDelete(reserved, sub)
+		return uuid.Nil, nil, errors.Wrap(err, "invalid remote sub id")
+	}
+
+	ch.subscriberWg.Add(1)
+
+	result := make(chan R, ch.chanLen)
+
+	go func() {
+		defer func() {
+			cancel()
+			close(result)
+			ch.subscriberCache.CompareAndDelete(subID, sub)
+			ch.subscriberWg.Done()
+		}()
+
+		for {
+			rtn, err := stream.Recv()
+
+			if err != nil {
+				if err != io.EOF && status.Code(err) != codes.Canceled {
+					slog.Error(
+						"receive remote data failed",
+						slog.Any("error", err),
+						slog.String("topic", ch.topic),
+						slog.String("sub_id", subID.String()),
+					)
+				}
+				return
+			}
+
+			v, err := chanio.NewTypeValue(ch.tid)
+			if err == nil {
+				err = v.Deserialize(rtn.GetData())
+			}
+
+			if err != nil {
+				slog.Error(
+					"decode remote data failed",
+					slog.Any("error", err),
+					slog.String("topic", ch.topic),
+					slog.Uint64("seq", rtn.GetSeq()),
+				)
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case result <- wrap(rtn.GetSeq(), v.(T)):
+			}
+		}
+	}()
+
+	slog.Info(
+		"remote subscriber add",
+		slog.String("name", name),
+		slog.String("topic", ch.topic),
+		slog.String("sub_id", subID.String()),
+	)
+
+	return subID, result, nil
+}
+
+func (ch *remoteChannel[T]) UnSubscribe(subID uuid.UUID) error {
+	sub, exist := ch.subscriberCache.LoadAndDelete(subID)
+	if !exist {
+		return core.ErrNoSubcriber
+	}
+	defer sub.(*remoteSub).cancel()
+
+	ctx, timeout := context.WithTimeout(ch.runCtx, defaultRemoteTimeout)
+	defer timeout()
+
+	rsp, err := ch.client.UnSubscribe(ctx, &protocol.ReqUnSub{
+		Topic: ch.topic,
+		SubId: subID.String(),
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "unsubscribe remote topic failed")
+	}
+
+	if rsp.GetErrorId() != rspSuccess {
+		return errors.Errorf(
+			"unsubscribe remote topic failed: [%d] %s",
+			rsp.GetErrorId(), rsp.GetErrorMsg(),
+		)
+	}
+
+	return nil
+}
+
+func rspError(info *protocol.RspInfo) error {
+	switch info.GetErrorId() {
+	case rspSuccess:
+		return nil
+	case rspNoTopic:
+		return errors.Wrap(ErrNoTopic, info.GetErrorMsg())
+	case rspInvalidData:
+		return errors.Wrap(ErrTypeMismatch, info.GetErrorMsg())
+	case rspPubTimeout:
+		return errors.Wrap(core.ErrPubTimeout, info.GetErrorMsg())
+	default:
+		return errors.Errorf(
+			"remote error: [%d] %s",
+			info.GetErrorId(), info.GetErrorMsg(),
+		)
+	}
+}
+
+// publishStream get or open the Publish stream shared by all
+// publishers of channel, pubLock must be held by caller
+func (ch *remoteChannel[T]) publishStream() (protocol.HubService_PublishClient, error) {
+	if ch.pubStream != nil {
+		return ch.pubStream, nil
+	}
+
+	stream, err := ch.client.Publish(ch.runCtx)
+	if err != nil {
+		return nil, errors.Wrap(err, "open remote publish stream failed")
+	}
+
+	ch.pubStream = stream
+	ch.pubWg.Add(1)
+
+	go ch.receiveAcks(stream)
+
+	return stream, nil
+}
+
+func (ch *remoteChannel[T]) receiveAcks(stream protocol.HubService_PublishClient) {
+	defer ch.pubWg.Done()
+
+	for {
+		rsp, err := stream.Recv()
+
+		if err != nil {
+			if err != io.EOF && status.Code(err) != codes.Canceled {
+				slog.Error(
+					"receive remote publish ack failed",
+					slog.Any("error", err),
+					slog.String("topic", ch.topic),
+				)
+			}
+
+			ch.pubLock.Lock()
+			defer ch.pubLock.Unlock()
+
+			// publishers waiting on broken stream will get a closed ack,
+			// next publish will open a new stream
+			ch.pubStream = nil
+			for seq, ack := range ch.pubPending {
+				close(ack)
+				delete(ch.pubPending, seq)
+			}
+
+			return
+		}
+
+		ch.pubLock.Lock()
+		ack, exist := ch.pubPending[rsp.GetSeq()]
+		delete(ch.pubPending, rsp.GetSeq())
+		ch.pubLock.Unlock()
+
+		if exist {
+			ack <- rsp.GetInfo()
+		}
+	}
+}
+
+// Publish publish data to remote topic and wait for remote ack,
+// timeout is applied by remote hub, non-positive timeout means
+// waiting until published
+func (ch *remoteChannel[T]) Publish(v T, timeout time.Duration) error {
+	if ch.runCtx.Err() != nil {
+		return channel.ErrChanClosed
+	}
+
+	payload, err := chanio.Serialize(any(v).(chanio.PersistentData))
+	if err != nil {
+		return errors.Wrap(err, "serialize data failed")
+	}
+
+	ack := make(chan *protocol.RspInfo, 1)
+
+	ch.pubLock.Lock()
+
+	stream, err := ch.publishStream()
+	if err != nil {
+		ch.pubLock.Unlock()
+		return err
+	}
+
+	ch.pubSeq++
+	seq := ch.pubSeq
+	ch.pubPending[seq] = ack
+
+	if err = stream.Send(&protocol.ReqPub{
+		Topic:   ch.topic,
+		Seq:     seq,
+		Tid:     uint32(ch.tid),
+		Data:    payload,
+		Timeout: timeout.Milliseconds(),
+	}); err != nil {
+		delete(ch.pubPending, seq)
+	}
+
+	ch.pubLock.Unlock()
+
+	if err != nil {
+		return errors.Wrap(err, "send remote publish failed")
+	}
+
+	var expire <-chan time.Time
+	if timeout > 0 {
+		// wait remote ack a little longer than remote timeout
+		expire = time.After(timeout + defaultRemoteTimeout)
+	}
+
+	select {
+	case <-ch.runCtx.Done():
+		return channel.ErrChanClosed
+	case <-expire:
+		ch.pubLock.Lock()
+		delete(ch.pubPending, seq)
+		ch.pubLock.Unlock()
+
+		return errors.Wrap(core.ErrPubTimeout, "wait remote ack")
+	case info, ok := <-ack:
+		if !ok {
+			return errors.New("remote publish stream broken")
+		}
+
+		return rspError(info)
+	}
+}
+
+func (ch *remoteChannel[T]) PipelineUpStream(src core.Consumer[T]) error {
+	if src == nil {
+		return errors.Wrap(core.ErrPipeline, "upstream empty")
+	}
+
+	subID, subCh, err := src.Subscribe(ch.name, core.Quick)
+	if err != nil {
+		return errors.Wrap(err, "subscribe upstream failed")
+	}
+
+	if _, exist := ch.upstreamCache.LoadOrStore(subID, src); exist {
+		return core.ErrAlreadySubscribed
+	}
+
+	ch.upstreamWg.Add(1)
+
+	go func() {
+		defer ch.upstreamWg.Done()
+
+		for {
+			select {
+			case <-ch.runCtx.Done():
+				return
+			case v, ok := <-subCh:
+				if !ok {
+					return
+				}
+
+				if err := ch.Publish(v, -1); err != nil {
+					slog.Error(
+						"relay pipeline upstream failed",
+						slog.Any("error", err),
+						slog.String("identity", core.QueueIdentity(src)),
+					)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (ch *remoteChannel[T]) PipelineDownStream(dst core.Upstream[T]) error {
+	if dst == nil {
+		return errors.Wrap(core.ErrPipeline, "empty down stream")
+	}
+
+	return dst.PipelineUpStream(ch)
+}
@@ -0,0 +1,249 @@
+package hub
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/frozenpine/msgqueue/chanio"
+	"github.com/frozenpine/msgqueue/core"
+	"github.com/frozenpine/msgqueue/hub/protocol"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// subIDHeader is the header key of subscriber id in Subscribe stream,
+// header is sent after subscription created on server
+const subIDHeader = "sub_id"
+
+const (
+	rspSuccess int32 = iota
+	rspNoTopic
+	rspInvalidChannel
+	rspInvalidSubID
+	rspUnSubscribeFailed
+	rspInvalidData
+	rspPubTimeout
+	rspPublishFailed
+)
+
+// HubServer serves topics in hub through protocol.HubService
+type HubServer struct {
+	protocol.UnimplementedHubServiceServer
+
+	hub Hub
+}
+
+func NewHubServer(hub Hub) *HubServer {
+	return &HubServer{hub: hub}
+}
+
+func convertResumeType(typ protocol.ResumeType) (core.ResumeType, error) {
+	switch typ {
+	case protocol.ResumeType_Restart:
+		return core.Restart, nil
+	case protocol.ResumeType_Resume:
+		return core.Resume, nil
+	case protocol.ResumeType_Quick:
+		return core.Quick, nil
+	default:
+		return core.Quick, core.ErrInvalidResumeType
+	}
+}
+
+func (svr *HubServer) getRawChannel(topic string) (rawChannel, error) {
+	ch, err := svr.hub.getTopicChannel(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw, ok := ch.(rawChannel); ok {
+		return raw, nil
+	}
+
+	return nil, ErrInvalidChannel
+}
+
+// GetTopics returns topics in hub with topic's data type,
+// data type is empty if topic channel not created yet
+func (svr *HubServer) GetTopics(ctx context.Context, _ *emptypb.Empty) (*protocol.Topics, error) {
+	topics := protocol.Topics{Define: make(map[string]string)}
+
+	for _, topic := range svr.hub.Topics() {
+		if raw, err := svr.getRawChannel(topic); err == nil {
+			topics.Define[topic] = raw.dataType()
+		} else {
+			topics.Define[topic] = ""
+		}
+	}
+
+	return &topics, nil
+}
+
+func (svr *HubServer) Subscribe(req *protocol.ReqSub, stream protocol.HubService_SubscribeServer) error {
+	raw, err := svr.getRawChannel(req.GetTopic())
+	if err != nil {
+		return status.Errorf(codes.NotFound, "topic %q: %v", req.GetTopic(), err)
+	}
+
+	resumeType, err := convertResumeType(req.GetResumeType())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	subID, data, err := raw.subscribeRaw(req.GetSubscriber(), resumeType)
+	if errors.Is(err, core.ErrAlreadySubscribed) {
+		return status.Error(codes.AlreadyExists, err.Error())
+	} else if err != nil {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	slog.Info(
+		"remote subscriber connected",
+		slog.String("topic", req.GetTopic()),
+		slog.String("subscriber", req.GetSubscriber()),
+		slog.String("sub_id", subID.String()),
+	)
+
+	defer func() {
+		// subscriber may already be removed by UnSubscribe
+		raw.UnSubscribe(subID)
+
+		// release raw converting goroutine, data is closed soon
+		// as subscriber's history reader is stopped by UnSubscribe
+		for range data {
+		}
+
+		slog.Info(
+			"remote subscriber disconnected",
+			slog.String("topic", req.GetTopic()),
+			slog.String("sub_id", subID.String()),
+		)
+	}()
+
+	if err := stream.SendHeader(metadata.Pairs(subIDHeader, subID.String())); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case v, ok := <-data:
+			if !ok {
+				return nil
+			}
+
+			payload, err := chanio.Serialize(v.Data)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+
+			if err := stream.Send(&protocol.RtnData{
+				Topic: req.GetTopic(),
+				Seq:   v.Seq,
+				Len:   uint32(len(payload)),
+				Data:  payload,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (svr *HubServer) UnSubscribe(ctx context.Context, req *protocol.ReqUnSub) (*protocol.RspInfo, error) {
+	raw, err := svr.getRawChannel(req.GetTopic())
+	switch {
+	case err == ErrNoTopic:
+		return &protocol.RspInfo{ErrorId: rspNoTopic, ErrorMsg: err.Error()}, nil
+	case err != nil:
+		return &protocol.RspInfo{ErrorId: rspInvalidChannel, ErrorMsg: err.Error()}, nil
+	}
+
+	subID, err := uuid.FromString(req.GetSubId())
+	if err != nil {
+		return &protocol.RspInfo{ErrorId: rspInvalidSubID, ErrorMsg: err.Error()}, nil
+	}
+
+	if err := raw.UnSubscribe(subID); err != nil {
+		return &protocol.RspInfo{ErrorId: rspUnSubscribeFailed, ErrorMsg: err.Error()}, nil
+	}
+
+	return &protocol.RspInfo{ErrorId: rspSuccess}, nil
+}
+
+// publish waits until published, timeout or publish stream closed
+func (svr *HubServer) publish(ctx context.Context, raw rawChannel, req *protocol.ReqPub) *protocol.RspInfo {
+	v, err := chanio.NewTypeValue(chanio.TID(req.GetTid()))
+	if err == nil {
+		err = v.Deserialize(req.GetData())
+	}
+	if err != nil {
+		return &protocol.RspInfo{ErrorId: rspInvalidData, ErrorMsg: err.Error()}
+	}
+
+	if timeout := time.Duration(req.GetTimeout()) * time.Millisecond; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err = raw.publishRaw(ctx, v)
+	switch {
+	case err == nil:
+		return &protocol.RspInfo{ErrorId: rspSuccess}
+	case errors.Is(err, ErrTypeMismatch):
+		return &protocol.RspInfo{ErrorId: rspInvalidData, ErrorMsg: err.Error()}
+	case errors.Is(err, core.ErrPubTimeout):
+		return &protocol.RspInfo{ErrorId: rspPubTimeout, ErrorMsg: err.Error()}
+	default:
+		return &protocol.RspInfo{ErrorId: rspPublishFailed, ErrorMsg: err.Error()}
+	}
+}
+
+// Publish publishes data from remote publisher to topic channels,
+// each ReqPub is acked by a RspPub with the same seq,
+// topic must be created in hub as its data type is needed
+func (svr *HubServer) Publish(stream protocol.HubService_PublishServer) error {
+	channels := make(map[string]rawChannel)
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var info *protocol.RspInfo
+
+		raw, exist := channels[req.GetTopic()]
+		if !exist {
+			raw, err = svr.getRawChannel(req.GetTopic())
+			if err == nil {
+				channels[req.GetTopic()] = raw
+			}
+		}
+
+		switch {
+		case err == ErrNoTopic || err == errTopicNotCreated:
+			info = &protocol.RspInfo{ErrorId: rspNoTopic, ErrorMsg: err.Error()}
+		case err != nil:
+			info = &protocol.RspInfo{ErrorId: rspInvalidChannel, ErrorMsg: err.Error()}
+		default:
+			info = svr.publish(stream.Context(), raw, req)
+		}
+
+		if err := stream.Send(&protocol.RspPub{
+			Seq:  req.GetSeq(),
+			Info: info,
+		}); err != nil {
+			return err
+		}
+	}
+}
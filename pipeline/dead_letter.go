@@ -0,0 +1,137 @@
+package pipeline
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/frozenpine/msgqueue/chanio"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidLetter  = errors.New("invalid dead letter data")
+	ErrDeadLetterType = errors.New("dead letter destination type mismatch")
+)
+
+// DeadLetter is the input which converter failed after all retries,
+// DeadLetter is PersistentData if input is PersistentData,
+// so it can be sent to persistent channel for inspecting & reinjecting
+type DeadLetter[IV any] struct {
+	Input     IV
+	Error     string
+	Retries   int
+	Timestamp time.Time
+}
+
+// Serialize returns nil if input can not be serialized,
+// storage uses SerializeChecked to get the error
+func (dl *DeadLetter[IV]) Serialize() []byte {
+	data, _ := dl.SerializeChecked()
+
+	return data
+}
+
+// SerializeChecked returns chanio.ErrNotPersistent if input is not
+// PersistentData, or error if input's type not registered
+func (dl *DeadLetter[IV]) SerializeChecked() ([]byte, error) {
+	input, ok := any(dl.Input).(chanio.PersistentData)
+	if !ok {
+		return nil, errors.Wrapf(chanio.ErrNotPersistent, "dead letter input %T", dl.Input)
+	}
+
+	tid, err := chanio.LookupType(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "lookup input type failed")
+	}
+
+	buf := binary.AppendVarint(nil, dl.Timestamp.UnixNano())
+	buf = binary.AppendUvarint(buf, uint64(dl.Retries))
+	buf = binary.AppendUvarint(buf, uint64(len(dl.Error)))
+	buf = append(buf, dl.Error...)
+	buf = binary.AppendUvarint(buf, uint64(tid))
+
+	payload, err := chanio.Serialize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(buf, payload...), nil
+}
+
+func (dl *DeadLetter[IV]) Deserialize(data []byte) error {
+	ts, n := binary.Varint(data)
+	if n <= 0 {
+		return errors.Wrap(ErrInvalidLetter, "timestamp")
+	}
+	data = data[n:]
+
+	retries, n := binary.Uvarint(data)
+	if n <= 0 {
+		return errors.Wrap(ErrInvalidLetter, "retries")
+	}
+	data = data[n:]
+
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size {
+		return errors.Wrap(ErrInvalidLetter, "error")
+	}
+	data = data[n:]
+	errMsg := string(data[:size])
+	data = data[size:]
+
+	tid, n := binary.Uvarint(data)
+	if n <= 0 {
+		return errors.Wrap(ErrInvalidLetter, "tid")
+	}
+	data = data[n:]
+
+	v, err := chanio.NewTypeValue(chanio.TID(tid))
+	if err != nil {
+		return err
+	}
+
+	if err := v.Deserialize(data); err != nil {
+		return err
+	}
+
+	input, ok := v.(IV)
+	if !ok {
+		return errors.Wrapf(ErrInvalidLetter, "input type %T", v)
+	}
+
+	dl.Input = input
+	dl.Error = errMsg
+	dl.Retries = int(retries)
+	dl.Timestamp = time.Unix(0, ts)
+
+	return nil
+}
+
+// RetryPolicy decides how many times & how long to wait
+// before converter retries a failed input
+type RetryPolicy struct {
+	// MaxRetry is retry count before dead-lettering,
+	// no retry if MaxRetry is 0
+	MaxRetry int
+	// Backoff is the wait duration before first retry
+	Backoff time.Duration
+	// Multiplier multiplies backoff after each retry,
+	// backoff is fixed if Multiplier less than 1
+	Multiplier float64
+	// MaxBackoff is upper limit of backoff, 0 means no limit
+	MaxBackoff time.Duration
+}
+
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	backoff := float64(p.Backoff)
+
+	for idx := 0; idx < retry && p.Multiplier > 1; idx++ {
+		backoff *= p.Multiplier
+
+		if p.MaxBackoff > 0 && backoff >= float64(p.MaxBackoff) {
+			return p.MaxBackoff
+		}
+	}
+
+	return time.Duration(backoff)
+}
@@ -3,6 +3,8 @@ package pipeline
 import (
 	"context"
 	"log/slog"
+	"reflect"
+	"runtime"
 	"sync"
 	"time"
 
@@ -22,23 +24,81 @@ type MemoPipeLine[
 
 	initOnce    sync.Once
 	releaseOnce sync.Once
+	// initErr is error in Init, returned by NewMemoPipeLine
+	initErr error
 
 	inputChan  channel.Channel[IV]
 	outputChan channel.Channel[OV]
 
 	converter Converter[IV, OV]
+
+	retry      RetryPolicy
+	deadLetter core.Producer[*DeadLetter[IV]]
+
+	workers      int
+	ordered      bool
+	partitionKey func(IV) string
 }
 
+// NewMemoPipeLine create pipeline converting input to output by cvt,
+// returns error if dead letter destination or partition key's type
+// mismatch with input type
 func NewMemoPipeLine[
 	IV, OV any,
-](ctx context.Context, name string, cvt Converter[IV, OV]) *MemoPipeLine[IV, OV] {
+](ctx context.Context, name string, cvt Converter[IV, OV], opts ...Option) (*MemoPipeLine[IV, OV], error) {
 	pipe := MemoPipeLine[IV, OV]{}
 
+	options := pipeOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if options.deadLetter != nil {
+		dst, ok := options.deadLetter.(core.Producer[*DeadLetter[IV]])
+		if !ok {
+			return nil, errors.Wrapf(
+				ErrDeadLetterType, "%T for %s",
+				options.deadLetter, reflect.TypeFor[IV]().String(),
+			)
+		}
+
+		pipe.deadLetter = dst
+	}
+
+	if options.partitionKey != nil {
+		key, ok := options.partitionKey.(func(IV) string)
+		if !ok {
+			return nil, errors.Wrapf(
+				ErrPartitionKeyType, "%T for %s",
+				options.partitionKey, reflect.TypeFor[IV]().String(),
+			)
+		}
+
+		pipe.partitionKey = key
+	}
+
+	workers := options.workers
+	if workers <= 0 {
+		workers = 1
+
+		// partitioned inputs are dispatched to workers by key
+		if pipe.partitionKey != nil {
+			workers = runtime.NumCPU()
+		}
+	}
+
 	pipe.Init(ctx, name, func() {
 		pipe.converter = cvt
+		pipe.retry = options.retry
+		pipe.workers = workers
+		pipe.ordered = options.ordered
 	})
 
-	return &pipe
+	if pipe.initErr != nil {
+		return nil, pipe.initErr
+	}
+
+	return &pipe, nil
 }
 
 func (pipe *MemoPipeLine[IV, OV]) Name() string {
@@ -82,32 +142,59 @@ func (pipe *MemoPipeLine[IV, OV]) Init(
 		pipe.name = core.GenName(name)
 		pipe.id = core.GenID(pipe.name)
 
+		// pipeline's channels block slow subscribers by default, so no data
+		// lost between stages, core.CtxSlowPolicy in ctx overrides it
+		policy := channel.Block
+		if v, ok := ctx.Value(core.CtxSlowPolicy).(channel.SlowPolicy); ok {
+			policy = v
+		}
+
 		// use seperate context to prevent exit same time
+		chanCtx := context.WithValue(
+			context.Background(), core.CtxSlowPolicy, policy,
+		)
 		pipe.inputChan = channel.NewMemoChannel[IV](
-			context.Background(), name+"_input", 0)
+			chanCtx, name+"_input", 0)
 		pipe.outputChan = channel.NewMemoChannel[OV](
-			context.Background(), name+"_output", 0)
+			chanCtx, name+"_output", 0)
 
 		if extraInit != nil {
 			extraInit()
 		}
 
-		go pipe.dispatcher()
+		// subscribe before dispatcher started, so no input will be
+		// missed if pipeline released right after created
+		subID, upChan, err := pipe.inputChan.Subscribe(pipe.name, core.Quick)
+		if err != nil {
+			pipe.initErr = errors.Wrap(err, "subscribe pipeline input failed")
+
+			pipe.cancelFn()
+			pipe.inputChan.Release()
+			pipe.outputChan.Release()
+			return
+		}
+
+		go pipe.dispatcher(subID, upChan)
 	})
 }
 
-func (pipe *MemoPipeLine[IV, OV]) dispatcher() {
+func (pipe *MemoPipeLine[IV, OV]) dispatcher(subID uuid.UUID, upChan <-chan IV) {
 	if pipe.converter == nil {
 		panic("input converter to output missing")
 	}
 
-	subID, upChan := pipe.inputChan.Subscribe(pipe.name, core.Quick)
-
 	slog.Info(
 		"starting dispatcher from input to output",
 		slog.String("sub_id", subID.String()),
+		slog.Int("workers", pipe.workers),
 	)
 
+	if pipe.workers > 1 || pipe.partitionKey != nil {
+		pipe.runWorkers(upChan)
+		pipe.outputChan.Release()
+		return
+	}
+
 	for {
 		select {
 		case <-pipe.runCtx.Done():
@@ -118,21 +205,104 @@ func (pipe *MemoPipeLine[IV, OV]) dispatcher() {
 				return
 			}
 
-			if err := pipe.converter(in, pipe.outputChan); err != nil {
-				slog.Error(
-					"dispatch to output chan failed",
-					slog.Any("error", err),
-				)
-			}
+			pipe.convertLive(in)
 		}
 	}
 }
 
+// convertLive converts input to output chan, outputs are buffered
+// per attempt if retry enabled, so outputs published before
+// converter failed won't be published again by retry
+func (pipe *MemoPipeLine[IV, OV]) convertLive(in IV) {
+	if pipe.retry.MaxRetry <= 0 {
+		pipe.convert(in, pipe.outputChan, nil)
+		return
+	}
+
+	out := bufferedOutput[OV]{Producer: pipe.outputChan}
+	if err := pipe.convert(in, &out, out.reset); err != nil {
+		// partial outputs of failed input discarded
+		return
+	}
+
+	out.flush(pipe.name)
+}
+
+// convert input with retry policy, failed input will be
+// sent to dead letter destination if configured,
+// reset is called before each retry if not nil,
+// returns converter's error after all retries
+func (pipe *MemoPipeLine[IV, OV]) convert(in IV, out core.Producer[OV], reset func()) error {
+	err := pipe.converter(in, out)
+	retry := 0
+
+retryLoop:
+	for err != nil && retry < pipe.retry.MaxRetry {
+		slog.Warn(
+			"dispatch to output chan failed, retrying",
+			slog.Any("error", err),
+			slog.Int("retry", retry+1),
+		)
+
+		select {
+		case <-pipe.runCtx.Done():
+			// no more retry if pipeline released
+			break retryLoop
+		case <-time.After(pipe.retry.backoff(retry)):
+		}
+
+		if reset != nil {
+			reset()
+		}
+
+		retry++
+		err = pipe.converter(in, out)
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	slog.Error(
+		"dispatch to output chan failed",
+		slog.Any("error", err),
+		slog.Int("retries", retry),
+	)
+
+	if pipe.deadLetter == nil {
+		return err
+	}
+
+	if dlErr := pipe.deadLetter.Publish(&DeadLetter[IV]{
+		Input:     in,
+		Error:     err.Error(),
+		Retries:   retry,
+		Timestamp: time.Now(),
+	}, -1); dlErr != nil {
+		slog.Error(
+			"send dead letter failed",
+			slog.Any("error", dlErr),
+			slog.String("name", pipe.name),
+		)
+	}
+
+	return err
+}
+
+// Reinject publishes dead letter's input to pipeline again
+func (pipe *MemoPipeLine[IV, OV]) Reinject(dl *DeadLetter[IV], timeout time.Duration) error {
+	if dl == nil {
+		return errors.Wrap(ErrInvalidLetter, "empty dead letter")
+	}
+
+	return pipe.inputChan.Publish(dl.Input, timeout)
+}
+
 func (pipe *MemoPipeLine[IV, OV]) Publish(v IV, timeout time.Duration) error {
 	return pipe.inputChan.Publish(v, timeout)
 }
 
-func (pipe *MemoPipeLine[IV, OV]) Subscribe(name string, resume core.ResumeType) (uuid.UUID, <-chan OV) {
+func (pipe *MemoPipeLine[IV, OV]) Subscribe(name string, resume core.ResumeType) (uuid.UUID, <-chan OV, error) {
 	return pipe.outputChan.Subscribe(name, resume)
 }
 
@@ -145,7 +315,10 @@ func (pipe *MemoPipeLine[IV, OV]) PipelineUpStream(src core.Consumer[IV]) error
 		return errors.Wrap(core.ErrPipeline, "empty upstream")
 	}
 
-	subID, upChan := src.Subscribe(pipe.name, core.Quick)
+	subID, upChan, err := src.Subscribe(pipe.name, core.Quick)
+	if err != nil {
+		return errors.Wrap(err, "subscribe upstream failed")
+	}
 
 	go func() {
 		defer src.UnSubscribe(subID)
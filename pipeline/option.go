@@ -0,0 +1,67 @@
+package pipeline
+
+import "github.com/frozenpine/msgqueue/core"
+
+type pipeOptions struct {
+	// deadLetter is core.Producer[*DeadLetter[IV]],
+	// checked when pipeline created
+	deadLetter any
+	retry      RetryPolicy
+
+	workers int
+	ordered bool
+	// partitionKey is func(IV) string,
+	// checked when pipeline created
+	partitionKey any
+}
+
+type Option func(*pipeOptions)
+
+// WithDeadLetter sends input which converter failed to dst,
+// IV must be same as pipeline's input type
+func WithDeadLetter[IV any](dst core.Producer[*DeadLetter[IV]]) Option {
+	return func(opts *pipeOptions) {
+		if dst != nil {
+			opts.deadLetter = dst
+		}
+	}
+}
+
+// WithRetry retries converter with backoff before dead-lettering
+func WithRetry(policy RetryPolicy) Option {
+	return func(opts *pipeOptions) {
+		opts.retry = policy
+	}
+}
+
+// WithWorkers runs converter on n worker goroutines,
+// converter must be safe for concurrent use if n > 1
+func WithWorkers(n int) Option {
+	return func(opts *pipeOptions) {
+		if n > 0 {
+			opts.workers = n
+		}
+	}
+}
+
+// WithOrdered re-sequences outputs of workers to input order,
+// outputs of one input are published together. Dispatching blocks
+// if workers*16 inputs are unpublished after earliest one, the limit
+// is global for all workers, so a slow input won't make outputs of
+// following inputs buffered without limit
+func WithOrdered() Option {
+	return func(opts *pipeOptions) {
+		opts.ordered = true
+	}
+}
+
+// WithPartitionKey dispatches inputs with same key to same worker,
+// IV must be same as pipeline's input type, workers count is
+// runtime.NumCPU() if not set by WithWorkers
+func WithPartitionKey[IV any](key func(IV) string) Option {
+	return func(opts *pipeOptions) {
+		if key != nil {
+			opts.partitionKey = key
+		}
+	}
+}
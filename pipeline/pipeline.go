@@ -35,7 +35,13 @@ func NewPipeline[
 
 	switch typ {
 	case core.Memory:
-		return NewMemoPipeLine(ctx, name, cvt), nil
+		pipe, err := NewMemoPipeLine(ctx, name, cvt)
+		if err != nil {
+			// avoid typed nil in interface
+			return nil, err
+		}
+
+		return pipe, nil
 	}
 
 	return nil, core.ErrInvalidType
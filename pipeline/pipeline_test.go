@@ -2,15 +2,25 @@ package pipeline
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/frozenpine/msgqueue/chanio"
+	"github.com/frozenpine/msgqueue/channel"
 	"github.com/frozenpine/msgqueue/core"
 )
 
 func TestMemoPipeline(t *testing.T) {
-	line := NewMemoPipeLine(
+	line, err := NewMemoPipeLine(
 		context.TODO(), "pipeline",
 		func(s int, c core.Producer[float64]) error {
 			o := float64(s) * 0.5
@@ -18,17 +28,22 @@ func TestMemoPipeline(t *testing.T) {
 			return c.Publish(o, -1)
 		},
 	)
+	if err != nil {
+		t.Fatal("create pipeline failed:", err)
+	}
 
 	wg := sync.WaitGroup{}
 
 	wg.Add(1)
 	go func() {
-		subID, ch := line.Subscribe("test1", core.Quick)
+		defer wg.Done()
 
-		defer func() {
-			line.UnSubscribe(subID)
-			wg.Done()
-		}()
+		subID, ch, err := line.Subscribe("test1", core.Quick)
+		if err != nil {
+			t.Error("subscribe failed:", err)
+			return
+		}
+		defer line.UnSubscribe(subID)
 
 		for v := range ch {
 			t.Log("output:", v)
@@ -46,3 +61,362 @@ func TestMemoPipeline(t *testing.T) {
 
 	wg.Wait()
 }
+
+type Int struct {
+	int
+}
+
+func (v *Int) Serialize() []byte {
+	return binary.AppendVarint(nil, int64(v.int))
+}
+
+func (v *Int) Deserialize(data []byte) error {
+	i, n := binary.Varint(data)
+	if n <= 0 {
+		return errors.New("invalid int")
+	}
+
+	v.int = int(i)
+	return nil
+}
+
+func TestDeadLetter(t *testing.T) {
+	dlq := channel.NewMemoChannel[*DeadLetter[int]](context.TODO(), "dlq", 10)
+	_, letters, _ := dlq.Subscribe("inspect", core.Quick)
+
+	failed := map[int]int{}
+	errFailed := errors.New("convert failed")
+
+	line, err := NewMemoPipeLine(
+		context.TODO(), "pipeline",
+		func(s int, c core.Producer[float64]) error {
+			failed[s]++
+
+			// 3 succeeds after first retry, 5 always fails
+			if s == 5 || (s == 3 && failed[s] == 1) {
+				return errFailed
+			}
+
+			return c.Publish(float64(s), -1)
+		},
+		WithDeadLetter(dlq),
+		WithRetry(RetryPolicy{
+			MaxRetry:   2,
+			Backoff:    time.Millisecond,
+			Multiplier: 2,
+		}),
+	)
+	if err != nil {
+		t.Fatal("create pipeline failed:", err)
+	}
+
+	_, output, _ := line.Subscribe("output", core.Quick)
+
+	go func() {
+		for idx := 0; idx < 10; idx++ {
+			if err := line.Publish(idx, -1); err != nil {
+				t.Error("publish failed:", err)
+				return
+			}
+		}
+	}()
+
+	for idx := 0; idx < 10; idx++ {
+		if idx == 5 {
+			continue
+		}
+
+		if v := <-output; v != float64(idx) {
+			t.Fatalf("output mismatch: %v %d", v, idx)
+		}
+	}
+
+	dl := <-letters
+	if dl.Input != 5 || dl.Retries != 2 || dl.Error != errFailed.Error() {
+		t.Fatal("dead letter mismatch:", dl)
+	}
+
+	if err := line.Reinject(dl, -1); err != nil {
+		t.Fatal("reinject failed:", err)
+	}
+
+	if dl = <-letters; dl.Input != 5 || failed[5] != 6 {
+		t.Fatal("reinjected dead letter mismatch:", dl, failed[5])
+	}
+
+	line.Release()
+	line.Join()
+
+	dlq.Release()
+	dlq.Join()
+}
+
+func TestRetryOutput(t *testing.T) {
+	vCount := 10
+
+	run := func(t *testing.T, opts ...Option) {
+		attempts := sync.Map{}
+		errFailed := errors.New("convert failed")
+
+		line, err := NewMemoPipeLine(
+			context.TODO(), "pipeline",
+			func(s int, c core.Producer[int]) error {
+				if err := c.Publish(s, -1); err != nil {
+					return err
+				}
+
+				// every input fails after partial output in first attempt
+				if _, retried := attempts.LoadOrStore(s, true); !retried {
+					return errFailed
+				}
+
+				return c.Publish(-s, -1)
+			},
+			append(opts, WithRetry(RetryPolicy{
+				MaxRetry: 2,
+				Backoff:  time.Millisecond,
+			}))...,
+		)
+		if err != nil {
+			t.Fatal("create pipeline failed:", err)
+		}
+
+		_, output, _ := line.Subscribe("output", core.Quick)
+
+		for idx := 1; idx <= vCount; idx++ {
+			if err := line.Publish(idx, -1); err != nil {
+				t.Fatal("publish failed:", err)
+			}
+		}
+
+		count := map[int]int{}
+		for received := 0; received < vCount*2; received++ {
+			select {
+			case v := <-output:
+				count[v]++
+			case <-time.After(time.Second):
+				t.Fatal("receive timeout:", count)
+			}
+		}
+
+		// wait for duplicated output if any
+		select {
+		case v := <-output:
+			t.Fatal("output duplicated:", v)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		line.Release()
+		line.Join()
+
+		for idx := 1; idx <= vCount; idx++ {
+			if count[idx] != 1 || count[-idx] != 1 {
+				t.Fatalf("output of %d duplicated or lost: %d %d", idx, count[idx], count[-idx])
+			}
+		}
+	}
+
+	t.Run("single worker", func(t *testing.T) { run(t) })
+	t.Run("unordered", func(t *testing.T) { run(t, WithWorkers(4)) })
+	t.Run("ordered", func(t *testing.T) { run(t, WithWorkers(4), WithOrdered()) })
+}
+
+func TestDeadLetterSerialize(t *testing.T) {
+	chanio.RegisterType(&Int{}, func() chanio.PersistentData {
+		return &Int{}
+	})
+
+	dl := DeadLetter[*Int]{
+		Input:     &Int{100},
+		Error:     "convert failed",
+		Retries:   3,
+		Timestamp: time.Now(),
+	}
+
+	result := DeadLetter[*Int]{}
+
+	if err := result.Deserialize(dl.Serialize()); err != nil {
+		t.Fatal("deserialize failed:", err)
+	}
+
+	if result.Input.int != dl.Input.int || result.Error != dl.Error ||
+		result.Retries != dl.Retries || !result.Timestamp.Equal(dl.Timestamp) {
+		t.Fatal("dead letter mismatch:", result)
+	}
+
+	notPersistent := DeadLetter[int]{Input: 100}
+	if _, err := notPersistent.SerializeChecked(); !errors.Is(err, chanio.ErrNotPersistent) {
+		t.Fatal("non persistent input should fail:", err)
+	}
+
+	store := chanio.NewFileStore(filepath.Join(t.TempDir(), "dlq.dat"))
+	if err := store.Open(os.O_WRONLY | os.O_CREATE | os.O_TRUNC); err != nil {
+		t.Fatal("store open failed:", err)
+	}
+	defer store.Close()
+
+	if err := store.Write(0, &notPersistent); !errors.Is(err, chanio.ErrNotPersistent) {
+		t.Fatal("non persistent dead letter should not be stored:", err)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	vCount := 100
+
+	run := func(t *testing.T, opts ...Option) []int {
+		line, err := NewMemoPipeLine(
+			context.TODO(), "pipeline",
+			func(s int, c core.Producer[int]) error {
+				time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+
+				return c.Publish(s, -1)
+			},
+			opts...,
+		)
+		if err != nil {
+			t.Fatal("create pipeline failed:", err)
+		}
+
+		_, output, _ := line.Subscribe("output", core.Quick)
+
+		go func() {
+			for idx := 0; idx < vCount; idx++ {
+				if err := line.Publish(idx, -1); err != nil {
+					t.Error("publish failed:", err)
+					return
+				}
+			}
+		}()
+
+		result := make([]int, vCount)
+		for idx := range result {
+			select {
+			case result[idx] = <-output:
+			case <-time.After(time.Second):
+				t.Fatal("receive timeout:", idx)
+			}
+		}
+
+		line.Release()
+		line.Join()
+
+		return result
+	}
+
+	t.Run("unordered", func(t *testing.T) {
+		result := run(t, WithWorkers(4))
+
+		sort.Ints(result)
+		for idx, v := range result {
+			if v != idx {
+				t.Fatalf("output mismatch: %d %d", v, idx)
+			}
+		}
+	})
+
+	t.Run("ordered", func(t *testing.T) {
+		for idx, v := range run(t, WithWorkers(4), WithOrdered()) {
+			if v != idx {
+				t.Fatalf("output order mismatch: %d %d", v, idx)
+			}
+		}
+	})
+
+	checkPartition := func(t *testing.T, result []int) {
+		last := map[string]int{}
+
+		for _, v := range result {
+			key := strconv.Itoa(v % 3)
+
+			if prev, exist := last[key]; exist && prev > v {
+				t.Fatalf("output order mismatch in partition %s: %d %d", key, prev, v)
+			}
+
+			last[key] = v
+		}
+	}
+
+	t.Run("partitioned", func(t *testing.T) {
+		checkPartition(t, run(t, WithWorkers(4), WithPartitionKey(func(v int) string {
+			return strconv.Itoa(v % 3)
+		})))
+	})
+
+	t.Run("partition key mismatch", func(t *testing.T) {
+		_, err := NewMemoPipeLine(
+			context.TODO(), "pipeline",
+			func(s int, c core.Producer[int]) error {
+				return c.Publish(s, -1)
+			},
+			WithPartitionKey(func(v string) string { return v }),
+		)
+
+		if !errors.Is(err, ErrPartitionKeyType) {
+			t.Fatal("partition key type should mismatch:", err)
+		}
+	})
+
+	t.Run("partition key only", func(t *testing.T) {
+		checkPartition(t, run(t, WithPartitionKey(func(v int) string {
+			return strconv.Itoa(v % 3)
+		})))
+	})
+}
+
+func TestOrderedInflight(t *testing.T) {
+	workers, vCount := 2, 200
+	block := make(chan struct{})
+
+	var started atomic.Int32
+
+	line, err := NewMemoPipeLine(
+		context.TODO(), "pipeline",
+		func(s int, c core.Producer[int]) error {
+			started.Add(1)
+
+			// first input blocks all outputs in ordered mode
+			if s == 0 {
+				<-block
+			}
+
+			return c.Publish(s, -1)
+		},
+		WithWorkers(workers), WithOrdered(),
+	)
+	if err != nil {
+		t.Fatal("create pipeline failed:", err)
+	}
+
+	_, output, _ := line.Subscribe("output", core.Quick)
+
+	go func() {
+		for idx := 0; idx < vCount; idx++ {
+			if err := line.Publish(idx, -1); err != nil {
+				t.Error("publish failed:", err)
+				return
+			}
+		}
+	}()
+
+	<-time.After(time.Millisecond * 100)
+
+	if n := int(started.Load()); n > workers*orderedInflight {
+		t.Fatalf("inputs processed ahead of blocked one: %d", n)
+	}
+
+	close(block)
+
+	for idx := 0; idx < vCount; idx++ {
+		select {
+		case v := <-output:
+			if v != idx {
+				t.Fatalf("output order mismatch: %d %d", v, idx)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("receive timeout:", idx)
+		}
+	}
+
+	line.Release()
+	line.Join()
+}
@@ -0,0 +1,199 @@
+package pipeline
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/frozenpine/msgqueue/core"
+	"github.com/pkg/errors"
+)
+
+var ErrPartitionKeyType = errors.New("partition key type mismatch")
+
+// orderedInflight multiplied by workers count is how many inputs can be
+// dispatched ahead of the earliest unpublished one in ordered mode, which
+// bounds outputs buffered by resequencer. The bound is shared by all
+// workers, so one worker may take more than orderedInflight of it
+const orderedInflight = 16
+
+type job[IV any] struct {
+	seq uint64
+	in  IV
+}
+
+type result[OV any] struct {
+	seq    uint64
+	values []OV
+}
+
+// bufferedOutput collects outputs of one input in ordered mode
+type bufferedOutput[OV any] struct {
+	core.Producer[OV]
+
+	values []OV
+}
+
+func (out *bufferedOutput[OV]) Publish(v OV, _ time.Duration) error {
+	out.values = append(out.values, v)
+	return nil
+}
+
+func (out *bufferedOutput[OV]) reset() {
+	out.values = nil
+}
+
+// flush publishes buffered outputs to underlying producer
+func (out *bufferedOutput[OV]) flush(name string) {
+	for _, v := range out.values {
+		if err := out.Producer.Publish(v, -1); err != nil {
+			slog.Error(
+				"publish buffered output failed",
+				slog.Any("error", err),
+				slog.String("name", name),
+			)
+		}
+	}
+
+	out.reset()
+}
+
+func partition(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return int(h.Sum32() % uint32(n))
+}
+
+// runWorkers dispatches inputs to worker pool until upChan closed
+func (pipe *MemoPipeLine[IV, OV]) runWorkers(upChan <-chan IV) {
+	queues := make([]chan job[IV], pipe.workers)
+	queueSize := 1
+
+	if pipe.partitionKey == nil {
+		// all workers share one queue if not partitioned
+		queues = queues[:1]
+		queueSize = pipe.workers
+	}
+
+	for idx := range queues {
+		queues[idx] = make(chan job[IV], queueSize)
+	}
+
+	var (
+		results     chan result[OV]
+		inflight    chan struct{}
+		sequencerWg sync.WaitGroup
+		workerWg    sync.WaitGroup
+	)
+
+	if pipe.ordered {
+		results = make(chan result[OV], pipe.workers)
+		inflight = make(chan struct{}, pipe.workers*orderedInflight)
+
+		sequencerWg.Add(1)
+		go func() {
+			defer sequencerWg.Done()
+
+			pipe.resequence(results, inflight)
+		}()
+	}
+
+	for idx := 0; idx < pipe.workers; idx++ {
+		workerWg.Add(1)
+
+		go func(queue <-chan job[IV]) {
+			defer workerWg.Done()
+
+			pipe.worker(queue, results)
+		}(queues[idx%len(queues)])
+	}
+
+	var seq uint64
+
+	for running := true; running; {
+		select {
+		case <-pipe.runCtx.Done():
+			pipe.Release()
+		case in, ok := <-upChan:
+			if !ok {
+				running = false
+				continue
+			}
+
+			if inflight != nil {
+				// released by resequencer when input's outputs published,
+				// every dispatched input has result, so it won't block forever
+				inflight <- struct{}{}
+			}
+
+			queue := queues[0]
+			if pipe.partitionKey != nil {
+				queue = queues[partition(pipe.partitionKey(in), len(queues))]
+			}
+
+			queue <- job[IV]{seq: seq, in: in}
+			seq++
+		}
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+
+	workerWg.Wait()
+
+	if results != nil {
+		close(results)
+	}
+
+	sequencerWg.Wait()
+}
+
+func (pipe *MemoPipeLine[IV, OV]) worker(queue <-chan job[IV], results chan<- result[OV]) {
+	for j := range queue {
+		if results == nil {
+			pipe.convertLive(j.in)
+			continue
+		}
+
+		out := bufferedOutput[OV]{Producer: pipe.outputChan}
+		if err := pipe.convert(j.in, &out, out.reset); err != nil {
+			// partial outputs of failed input discarded
+			out.reset()
+		}
+
+		// result is sent even if input failed,
+		// so that sequencer won't wait for it
+		results <- result[OV]{seq: j.seq, values: out.values}
+	}
+}
+
+// resequence publishes workers' outputs in input order,
+// inflight slot of input is released after its outputs published
+func (pipe *MemoPipeLine[IV, OV]) resequence(results <-chan result[OV], inflight <-chan struct{}) {
+	var next uint64
+	pending := make(map[uint64][]OV)
+
+	for r := range results {
+		pending[r.seq] = r.values
+
+		for values, exist := pending[next]; exist; values, exist = pending[next] {
+			delete(pending, next)
+			next++
+
+			for _, v := range values {
+				if err := pipe.outputChan.Publish(v, -1); err != nil {
+					slog.Error(
+						"publish ordered output failed",
+						slog.Any("error", err),
+						slog.String("name", pipe.name),
+					)
+				}
+			}
+
+			<-inflight
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log/slog"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -37,6 +38,10 @@ type Trade interface {
 type TradeSequence struct {
 	Trade
 	ts time.Time
+
+	// water mark injected by timer, only closes bar
+	// which index before water mark
+	timer bool
 }
 
 func NewTradeSequence(v Trade) *TradeSequence {
@@ -48,6 +53,7 @@ func NewTradeSequence(v Trade) *TradeSequence {
 
 	result.Trade = v
 	result.ts = time.Now()
+	result.timer = false
 
 	runtime.SetFinalizer(result, tradeSequencePool.Put)
 
@@ -55,6 +61,39 @@ func NewTradeSequence(v Trade) *TradeSequence {
 	// return &result
 }
 
+// NewWaterMark create water mark sequence, in event time mode
+// ts is treated as event time
+func NewWaterMark(ts time.Time) *TradeSequence {
+	return &TradeSequence{ts: ts}
+}
+
+func newTimerMark(ts time.Time) *TradeSequence {
+	return &TradeSequence{ts: ts, timer: true}
+}
+
+// runWaterMarkTimer publishes timer water mark until ctx done
+func runWaterMarkTimer(
+	ctx context.Context, name string,
+	interval time.Duration, clock Clock,
+	publish func(Sequence[time.Time, Trade], time.Duration) error,
+) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ts := <-clock.After(interval):
+			if err := publish(newTimerMark(ts), -1); err != nil {
+				slog.Warn(
+					"inject timer water mark failed",
+					slog.Any("error", err),
+					slog.String("name", name),
+				)
+				return
+			}
+		}
+	}
+}
+
 func (tds *TradeSequence) Index() time.Time {
 	return tds.ts
 }
@@ -83,6 +122,108 @@ type KBar interface {
 	Volume() int
 	Precise() time.Duration
 	Index() time.Time
+	// Instrument is empty if bar aggregated from all trades
+	Instrument() string
+	// IsCorrection means bar is re-published after amended by late trades
+	IsCorrection() bool
+}
+
+// eventClock tracks event time of stream's windows
+type eventClock struct {
+	lateness time.Duration
+	maxTS    time.Time
+	mark     time.Time
+	// idle is how long no trade observed, counted by timer water marks
+	idle time.Duration
+}
+
+func (c *eventClock) observe(ts time.Time) {
+	c.idle = 0
+
+	if ts.After(c.maxTS) {
+		c.maxTS = ts
+	}
+}
+
+func (c *eventClock) advance(ts time.Time) {
+	if ts.After(c.mark) {
+		c.mark = ts
+	}
+}
+
+// timerMark converts timer water mark to event time, which is max
+// observed trade time plus idle interval minus lateness, so wall clock
+// never pushes event time. It returns v if c is nil or v is not timer
+// water mark, returns nil if no trade observed yet
+func (c *eventClock) timerMark(v Sequence[time.Time, Trade], interval time.Duration) Sequence[time.Time, Trade] {
+	if mark, ok := v.(*TradeSequence); c == nil || !ok || !mark.timer {
+		return v
+	}
+
+	if c.maxTS.IsZero() {
+		return nil
+	}
+
+	c.idle += interval
+
+	return NewWaterMark(c.maxTS.Add(c.idle - c.lateness))
+}
+
+func (c *eventClock) watermark() time.Time {
+	wm := c.maxTS.Add(-c.lateness)
+
+	if c.mark.After(wm) {
+		return c.mark
+	}
+
+	return wm
+}
+
+// barSnapshot is immutable copy of bar published by stream
+type barSnapshot struct {
+	instrument string
+	precise    time.Duration
+	index      time.Time
+
+	open, high, low, close float64
+	volume                 int
+	count                  int
+
+	correction bool
+}
+
+func (bar *barSnapshot) High() float64          { return bar.high }
+func (bar *barSnapshot) Low() float64           { return bar.low }
+func (bar *barSnapshot) Open() float64          { return bar.open }
+func (bar *barSnapshot) Close() float64         { return bar.close }
+func (bar *barSnapshot) Volume() int            { return bar.volume }
+func (bar *barSnapshot) Precise() time.Duration { return bar.precise }
+func (bar *barSnapshot) Index() time.Time       { return bar.index }
+func (bar *barSnapshot) Instrument() string     { return bar.instrument }
+func (bar *barSnapshot) IsCorrection() bool     { return bar.correction }
+func (bar *barSnapshot) Value() KBar            { return bar }
+func (bar *barSnapshot) IsWaterMark() bool      { return false }
+
+func (bar *barSnapshot) Compare(than Sequence[time.Time, KBar]) int {
+	return core.TimeCompare(bar.index, than.Index())
+}
+
+func (bar *barSnapshot) merge(v KBar) {
+	if bar.count == 0 {
+		bar.open, bar.high, bar.low = v.Open(), v.High(), v.Low()
+	}
+	bar.count++
+
+	if v.High() > bar.high {
+		bar.high = v.High()
+	}
+
+	if v.Low() < bar.low {
+		bar.low = v.Low()
+	}
+
+	bar.close = v.Close()
+	bar.volume += v.Volume()
 }
 
 type KBarWindow struct {
@@ -93,6 +234,16 @@ type KBarWindow struct {
 	max, min    Trade
 	precise     time.Duration
 	index       time.Time
+	instrument  string
+
+	// clock is nil in processing time mode
+	clock *eventClock
+	// trades after bar's index but water mark not passed
+	pending []*TradeSequence
+	// how many closed bars retained in chain, 0 means unbounded
+	retain int
+	// align is location & offset which bar boundaries aligned to
+	align barAlign
 }
 
 func NewKBarWindow(preBar *KBarWindow, preSettle float64, gap time.Duration) *KBarWindow {
@@ -122,6 +273,22 @@ func NewKBarWindow(preBar *KBarWindow, preSettle float64, gap time.Duration) *KB
 
 	// to prevent dirty data in history
 	bar.data = bar.data[:0]
+	bar.preBar = preBar
+	bar.preSettle = preSettle
+	bar.precise = gap
+	bar.totalVolume = 0
+	bar.max, bar.min = nil, nil
+	bar.instrument = ""
+	bar.clock = nil
+	bar.pending = nil
+	bar.retain = 0
+	bar.align = barAlign{}
+
+	if preBar != nil {
+		bar.clock = preBar.clock
+		bar.retain = preBar.retain
+		bar.align = preBar.align
+	}
 
 	runtime.SetFinalizer(bar, kbarSequencePool.Put)
 
@@ -129,6 +296,66 @@ func NewKBarWindow(preBar *KBarWindow, preSettle float64, gap time.Duration) *KB
 	// return &bar
 }
 
+// newEventKBarWindow create bar in event time mode,
+// bar's index is anchored by first trade's TradeTime
+func newEventKBarWindow(preSettle float64, gap time.Duration, clock *eventClock) *KBarWindow {
+	bar := NewKBarWindow(nil, preSettle, gap)
+	bar.index = time.Time{}
+	bar.clock = clock
+
+	return bar
+}
+
+// alignTo aligns bar boundaries by align, bar created in
+// processing time mode is re-anchored to next aligned boundary
+func (k *KBarWindow) alignTo(align barAlign) {
+	k.align = align
+
+	if k.clock != nil || k.index.IsZero() {
+		return
+	}
+
+	now := time.Now()
+	index := align.index(now, k.precise)
+	if !index.After(now) {
+		index = index.Add(k.precise)
+	}
+	k.index = index
+}
+
+// barIndex get end of bar which ts belongs to
+func barIndex(ts time.Time, gap time.Duration) time.Time {
+	index := ts.Truncate(gap)
+	if index.Before(ts) {
+		index = index.Add(gap)
+	}
+
+	return index
+}
+
+// barAlign aligns bar boundaries to midnight of loc plus offset,
+// zero value aligns to UTC midnight
+type barAlign struct {
+	loc    *time.Location
+	offset time.Duration
+}
+
+// index get end of aligned bar which ts belongs to,
+// loc's zone offset is taken at ts
+func (a barAlign) index(ts time.Time, gap time.Duration) time.Time {
+	shift := -a.offset
+	if a.loc != nil {
+		_, zone := ts.In(a.loc).Zone()
+		shift += time.Duration(zone) * time.Second
+	}
+
+	if shift == 0 {
+		return barIndex(ts, gap)
+	}
+
+	return barIndex(ts.Add(shift), gap).Add(-shift)
+}
+
 func (k *KBarWindow) Indexs() []time.Time {
 	indexes := make([]time.Time, len(k.data))
 
@@ -177,48 +404,113 @@ func (k *KBarWindow) Push(v Sequence[time.Time, Trade]) error {
 	}
 
 	if v.IsWaterMark() {
-		return errors.Wrap(ErrWindowClosed, "water mark arrive")
+		if k.clock == nil {
+			if mark, ok := v.(*TradeSequence); ok && mark.timer &&
+				core.TimeCompare(mark.ts, k.index) < 0 {
+				return nil
+			}
+
+			return errors.Wrap(ErrWindowClosed, "water mark arrive")
+		}
+
+		k.clock.advance(v.Index())
+
+		if k.index.IsZero() || k.clock.watermark().Before(k.index) {
+			return nil
+		}
+
+		return errors.Wrap(ErrWindowClosed, "water mark passed bar")
 	}
 
 	td := v.Value()
 
+	if k.clock != nil {
+		k.clock.observe(td.TradeTime())
+
+		if k.index.IsZero() {
+			k.index = k.align.index(td.TradeTime(), k.precise)
+		}
+	}
+
 	if core.TimeCompare(k.index, td.TradeTime()) < 0 {
+		if k.clock != nil && k.clock.watermark().Before(k.index) {
+			// wait for late trades in allowed lateness
+			k.pending = append(k.pending, v.(*TradeSequence))
+			return nil
+		}
+
 		return errors.Wrap(ErrWindowClosed, "trade ts after current bar")
 	}
 
-	if core.TimeCompare(k.index.Add(-k.precise), td.TradeTime()) >= 0 {
+	if k.isLate(td.TradeTime()) {
 		// 根据链表回溯历史流的窗口
-		if k.preBar != nil {
-			err := k.preBar.Push(v)
+		if pre := k.retrace(td.TradeTime()); pre != nil {
+			pre.add(v.(*TradeSequence))
+			return nil
+		}
 
-			switch {
-			case errors.Is(err, ErrWindowClosed):
-				slog.Warn(
-					"bar gap in history stream",
-					slog.Time("trade_time", td.TradeTime()),
-					slog.Time("pre_idx", k.preBar.Index()),
-					slog.Time("curr_idx", k.Index()),
-				)
-			case errors.Is(err, ErrHistorySequence):
-				slog.Warn(
-					"trade ts before stream start",
-					slog.Time("trade_time", td.TradeTime()),
-					slog.Time("pre_idx", k.preBar.Index()),
-				)
-			default:
-				slog.Error(
-					"unkown error occoured in stream retrace",
-					slog.Any("error", err),
-				)
-			}
+		return errors.Wrap(ErrHistorySequence, "trade ts before current bar")
+	}
+
+	k.add(v.(*TradeSequence))
+
+	return nil
+}
+
+// isLate checks if ts belongs to closed bars
+func (k *KBarWindow) isLate(ts time.Time) bool {
+	return !k.index.IsZero() &&
+		core.TimeCompare(k.index.Add(-k.precise), ts) >= 0
+}
 
+// retrace finds retained closed bar which ts belongs to
+func (k *KBarWindow) retrace(ts time.Time) *KBarWindow {
+	for pre := k.preBar; pre != nil; pre = pre.preBar {
+		if core.TimeCompare(pre.index, ts) < 0 {
+			slog.Warn(
+				"bar gap in history stream",
+				slog.Time("trade_time", ts),
+				slog.Time("pre_idx", pre.Index()),
+			)
 			return nil
 		}
 
-		return errors.Wrap(ErrHistorySequence, "trade ts before current bar")
+		if !pre.isLate(ts) {
+			return pre
+		}
+	}
+
+	return nil
+}
+
+func (k *KBarWindow) snapshot(correction bool) *barSnapshot {
+	return &barSnapshot{
+		instrument: k.instrument,
+		precise:    k.precise,
+		index:      k.index,
+		open:       k.Open(),
+		high:       k.High(),
+		low:        k.Low(),
+		close:      k.Close(),
+		volume:     k.totalVolume,
+		count:      1,
+		correction: correction,
+	}
+}
+
+// add keeps data in trade time order, so open & close
+// are still right after late trade added to closed bar
+func (k *KBarWindow) add(v *TradeSequence) {
+	td := v.Value()
+
+	pos := len(k.data)
+	if pos > 0 && td.TradeTime().Before(k.data[pos-1].TradeTime()) {
+		pos = sort.Search(pos, func(i int) bool {
+			return k.data[i].TradeTime().After(td.TradeTime())
+		})
 	}
 
-	k.data = append(k.data, v.(*TradeSequence))
+	k.data = slices.Insert(k.data, pos, v)
 
 	k.totalVolume += td.Volume()
 
@@ -229,8 +521,6 @@ func (k *KBarWindow) Push(v Sequence[time.Time, Trade]) error {
 	if k.min == nil || td.Price() < k.min.Price() {
 		k.min = td
 	}
-
-	return nil
 }
 
 func (k *KBarWindow) PreWindow() Window[time.Time, Trade, KBar] {
@@ -238,7 +528,34 @@ func (k *KBarWindow) PreWindow() Window[time.Time, Trade, KBar] {
 }
 
 func (k *KBarWindow) NextWindow() Window[time.Time, Trade, KBar] {
-	return NewKBarWindow(k, k.preSettle, k.precise)
+	next := NewKBarWindow(k, k.preSettle, k.precise)
+	next.instrument = k.instrument
+
+	for _, v := range k.pending {
+		if core.TimeCompare(next.index, v.TradeTime()) < 0 {
+			next.pending = append(next.pending, v)
+		} else {
+			next.add(v)
+		}
+	}
+	k.pending = nil
+
+	if next.retain > 0 {
+		// cut chain so bars out of retention can be recycled
+		tail := next
+		for idx := 0; idx < next.retain && tail != nil; idx++ {
+			tail = tail.preBar
+		}
+
+		if tail != nil {
+			// keep previous bar's close as pre settle like clone,
+			// so empty tail bar's price not falls back to settle
+			tail.preSettle = tail.getPrePrice()
+			tail.preBar = nil
+		}
+	}
+
+	return next
 }
 
 func (k *KBarWindow) IsWaterMark() bool { return true }
@@ -295,6 +612,14 @@ func (k *KBarWindow) Precise() time.Duration {
 	return k.precise
 }
 
+func (k *KBarWindow) Instrument() string {
+	return k.instrument
+}
+
+func (k *KBarWindow) IsCorrection() bool {
+	return false
+}
+
 func (k *KBarWindow) Value() KBar {
 	return k
 }
@@ -309,28 +634,414 @@ func (k *KBarWindow) Compare(than Sequence[time.Time, KBar]) int {
 
 type KBarStream struct {
 	MemoStream[time.Time, Trade, KBar, string]
+
+	options kbarOptions
 }
 
-func NewKBarStream(ctx context.Context, name string, preSettle float64, gap time.Duration) *KBarStream {
-	stream := KBarStream{}
+func NewKBarStream(ctx context.Context, name string, preSettle float64, gap time.Duration, opts ...KBarOption) (*KBarStream, error) {
+	options := newKBarOptions(opts...)
+
+	stream, err := newKBarStream(ctx, name, options, func() Window[time.Time, Trade, KBar] {
+		var bar *KBarWindow
+
+		if options.eventTime {
+			bar = newEventKBarWindow(
+				preSettle, gap,
+				&eventClock{lateness: options.lateness},
+			)
+		} else {
+			bar = NewKBarWindow(nil, preSettle, gap)
+		}
+
+		bar.retain = options.maxRetrace
+		bar.alignTo(options.align)
+
+		return bar
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if options.markClock != nil {
+		go runWaterMarkTimer(
+			stream.runCtx, stream.name,
+			options.markInterval, options.markClock,
+			stream.Publish,
+		)
+	}
+
+	return stream, nil
+}
+
+func newKBarStream(
+	ctx context.Context, name string, options kbarOptions,
+	newWindow func() Window[time.Time, Trade, KBar],
+) (*KBarStream, error) {
+	stream := KBarStream{options: options}
+
+	var err error
 
 	stream.MemoStream.Init(ctx, name, func() {
-		stream.pipeline = pipeline.NewMemoPipeLine(
+		stream.pipeline, err = pipeline.NewMemoPipeLine(
 			context.Background(),
 			"KBarStream_pipeline", stream.convert)
 
-		stream.currWindow = NewKBarWindow(nil, preSettle, Min1BarGap)
+		stream.newWindow = newWindow
+		stream.currWindow = stream.newWindow()
 
-		stream.aggregator = func(
-			w Window[time.Time, Trade, KBar],
-		) (Sequence[time.Time, KBar], error) {
-			if bar, ok := w.(*KBarWindow); ok {
-				return bar, nil
-			} else {
-				return nil, errors.New("not Kbar window")
-			}
+		stream.aggregator = kbarAggregator
+		stream.spawn = stream.derive
+	})
+
+	if err != nil {
+		stream.cancelFn()
+		return nil, errors.Wrap(err, "create stream pipeline failed")
+	}
+
+	return &stream, nil
+}
+
+// derive create child KBarStream with same options, water marks
+// are forwarded by parent, so child runs no water mark timer
+func (strm *KBarStream) derive(name string) (Stream[time.Time, Trade, KBar, string], error) {
+	child, err := newKBarStream(strm.runCtx, name, strm.options, strm.newWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	child.history = strm.history
+
+	return child, nil
+}
+
+// convert converts timer water mark to event time before
+// dispatching to derived streams, so derived streams' windows
+// are closed by same event time as parent's
+func (strm *KBarStream) convert(inData Sequence[time.Time, Trade], outChan core.Producer[Sequence[time.Time, KBar]]) error {
+	bar, isBar := strm.currWindow.(*KBarWindow)
+
+	if isBar {
+		if inData = bar.clock.timerMark(inData, strm.options.markInterval); inData == nil {
+			return nil
 		}
+	}
+
+	if err := strm.dispatchDerived(inData); err != nil {
+		return err
+	}
+
+	if isBar {
+		if late, err := strm.options.handleLate(bar, inData, outChan, &strm.windowLock); late {
+			return err
+		}
+	}
+
+	return strm.pushWindow(inData, outChan)
+}
+
+// RollUp derives bars with larger gap from stream's closed bars
+func (strm *KBarStream) RollUp(gap time.Duration) (*KBarRollUp, error) {
+	// roll up released by stream's output closing
+	return NewKBarRollUp(
+		context.Background(), strm.name+"_"+gap.String(), strm, gap,
+		WithBarAlign(strm.options.align.loc, strm.options.align.offset),
+	)
+}
+
+func kbarAggregator(w Window[time.Time, Trade, KBar]) (Sequence[time.Time, KBar], error) {
+	if bar, ok := w.(*KBarWindow); ok {
+		return bar.snapshot(false), nil
+	} else {
+		return nil, errors.New("not Kbar window")
+	}
+}
+
+// MultiKBarStream aggregates trades into separate bars for each
+// instrument, bars of all instruments are published to same output
+// and tagged with instrument
+type MultiKBarStream struct {
+	MemoStream[time.Time, Trade, KBar, string]
+
+	instrument func(Trade) string
+	preSettles map[string]float64
+	gap        time.Duration
+	// clock shared by all instruments in event time mode
+	clock   *eventClock
+	options kbarOptions
+
+	bars map[string]*KBarWindow
+}
+
+// NewMultiKBarStream create kbar stream keyed by instrument func,
+// preSettles is instruments' pre settle price used by empty bars,
+// can be nil
+func NewMultiKBarStream(
+	ctx context.Context, name string,
+	instrument func(Trade) string,
+	preSettles map[string]float64,
+	gap time.Duration,
+	opts ...KBarOption,
+) (*MultiKBarStream, error) {
+	if instrument == nil {
+		return nil, errors.New("instrument func missing")
+	}
+
+	options := newKBarOptions(opts...)
+
+	stream, err := newMultiKBarStream(ctx, name, instrument, preSettles, gap, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.markClock != nil {
+		go runWaterMarkTimer(
+			stream.runCtx, stream.name,
+			options.markInterval, options.markClock,
+			stream.Publish,
+		)
+	}
+
+	return stream, nil
+}
+
+func newMultiKBarStream(
+	ctx context.Context, name string,
+	instrument func(Trade) string,
+	preSettles map[string]float64,
+	gap time.Duration,
+	options kbarOptions,
+) (*MultiKBarStream, error) {
+	stream := MultiKBarStream{
+		instrument: instrument,
+		preSettles: preSettles,
+		gap:        gap,
+		options:    options,
+		bars:       make(map[string]*KBarWindow),
+	}
+
+	if options.eventTime {
+		stream.clock = &eventClock{lateness: options.lateness}
+	}
+
+	if name == "" {
+		name = "MultiKBarStream"
+	}
+
+	var err error
+
+	stream.MemoStream.Init(ctx, name, func() {
+		stream.pipeline, err = pipeline.NewMemoPipeLine(
+			context.Background(),
+			"MultiKBarStream_pipeline", stream.convert)
+
+		stream.aggregator = kbarAggregator
+		stream.spawn = stream.derive
 	})
 
-	return &stream
+	if err != nil {
+		stream.cancelFn()
+		return nil, errors.Wrap(err, "create stream pipeline failed")
+	}
+
+	return &stream, nil
+}
+
+// derive create child MultiKBarStream with same instruments & options,
+// water marks are forwarded by parent, so child runs no water mark timer
+func (strm *MultiKBarStream) derive(name string) (Stream[time.Time, Trade, KBar, string], error) {
+	child, err := newMultiKBarStream(
+		strm.runCtx, name, strm.instrument,
+		strm.preSettles, strm.gap, strm.options,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	child.history = strm.history
+
+	return child, nil
+}
+
+// CurrWindow returns nil, as bars are kept for each instrument,
+// use InstrumentWindow to get instrument's bar
+func (strm *MultiKBarStream) CurrWindow() Window[time.Time, Trade, KBar] {
+	return nil
+}
+
+// PreWindow returns nil, as bars are kept for each instrument,
+// use InstrumentWindow to get instrument's bar
+func (strm *MultiKBarStream) PreWindow(int) Window[time.Time, Trade, KBar] {
+	return nil
+}
+
+// InstrumentWindow get snapshot of instrument's n count previous bar,
+// if n count <= 0, will return instrument's current bar,
+// returns nil if instrument has no bar or bar out of retention
+func (strm *MultiKBarStream) InstrumentWindow(ins string, n int) Window[time.Time, Trade, KBar] {
+	strm.deriveLock.RLock()
+	bar, exist := strm.bars[ins]
+	strm.deriveLock.RUnlock()
+
+	if !exist {
+		return nil
+	}
+
+	strm.windowLock.RLock()
+	defer strm.windowLock.RUnlock()
+
+	for ; n > 0 && bar != nil; n-- {
+		bar = bar.preBar
+	}
+
+	if bar == nil {
+		return nil
+	}
+
+	return freeze[time.Time, Trade, KBar](bar)
+}
+
+// RollUp derives bars with larger gap from all instruments' closed bars
+func (strm *MultiKBarStream) RollUp(gap time.Duration) (*KBarRollUp, error) {
+	// roll up released by stream's output closing
+	return NewKBarRollUp(
+		context.Background(), strm.name+"_"+gap.String(), strm, gap,
+		WithBarAlign(strm.options.align.loc, strm.options.align.offset),
+	)
+}
+
+// Instruments get all instruments which bar window created
+func (strm *MultiKBarStream) Instruments() []string {
+	strm.deriveLock.RLock()
+	defer strm.deriveLock.RUnlock()
+
+	result := make([]string, 0, len(strm.bars))
+
+	for ins := range strm.bars {
+		result = append(result, ins)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+func (strm *MultiKBarStream) getBar(ins string) *KBarWindow {
+	strm.deriveLock.RLock()
+	bar, exist := strm.bars[ins]
+	strm.deriveLock.RUnlock()
+
+	if exist {
+		return bar
+	}
+
+	if strm.clock != nil {
+		bar = newEventKBarWindow(strm.preSettles[ins], strm.gap, strm.clock)
+	} else {
+		bar = NewKBarWindow(nil, strm.preSettles[ins], strm.gap)
+	}
+	bar.instrument = ins
+	bar.retain = strm.options.maxRetrace
+	bar.alignTo(strm.options.align)
+
+	strm.deriveLock.Lock()
+	strm.bars[ins] = bar
+	strm.deriveLock.Unlock()
+
+	return bar
+}
+
+// pushBar pushes sequence into instrument's bar in lock,
+// as bar may be read by InstrumentWindow
+func (strm *MultiKBarStream) pushBar(bar *KBarWindow, inData Sequence[time.Time, Trade]) error {
+	strm.windowLock.Lock()
+	defer strm.windowLock.Unlock()
+
+	return bar.Push(inData)
+}
+
+// settle publishes bar and move instrument's window to next bar
+func (strm *MultiKBarStream) settle(bar *KBarWindow, outChan core.Producer[Sequence[time.Time, KBar]]) (*KBarWindow, error) {
+	if err := outChan.Publish(bar.snapshot(false), -1); err != nil {
+		slog.Error(
+			"stream out failed",
+			slog.Any("error", err),
+			slog.String("instrument", bar.instrument),
+		)
+		return bar, err
+	}
+
+	strm.windowLock.Lock()
+	next := bar.NextWindow().(*KBarWindow)
+	strm.windowLock.Unlock()
+
+	strm.deriveLock.Lock()
+	strm.bars[bar.instrument] = next
+	strm.deriveLock.Unlock()
+
+	return next, nil
+}
+
+func (strm *MultiKBarStream) convert(inData Sequence[time.Time, Trade], outChan core.Producer[Sequence[time.Time, KBar]]) error {
+	if inData = strm.clock.timerMark(inData, strm.options.markInterval); inData == nil {
+		return nil
+	}
+
+	if err := strm.dispatchDerived(inData); err != nil {
+		return err
+	}
+
+	if inData.IsWaterMark() {
+		strm.deriveLock.RLock()
+		bars := make([]*KBarWindow, 0, len(strm.bars))
+		for _, bar := range strm.bars {
+			bars = append(bars, bar)
+		}
+		strm.deriveLock.RUnlock()
+
+		sort.Slice(bars, func(i, j int) bool {
+			return bars[i].instrument < bars[j].instrument
+		})
+
+		for _, bar := range bars {
+			if err := strm.pushBar(bar, inData); !errors.Is(err, ErrWindowClosed) {
+				continue
+			}
+
+			if _, err := strm.settle(bar, outChan); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	bar := strm.getBar(strm.instrument(inData.Value()))
+
+	if late, err := strm.options.handleLate(bar, inData, outChan, &strm.windowLock); late {
+		return err
+	}
+
+	for {
+		err := strm.pushBar(bar, inData)
+
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, ErrWindowClosed):
+			// trade after current bar, settle bars until trade accepted
+			if bar, err = strm.settle(bar, outChan); err != nil {
+				return err
+			}
+		case errors.Is(err, ErrHistorySequence):
+			slog.Error(
+				"history sequence arrived",
+				slog.String("instrument", bar.instrument),
+				slog.Time("trade_time", inData.Value().TradeTime()),
+			)
+
+			return nil
+		default:
+			return err
+		}
+	}
 }
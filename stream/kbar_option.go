@@ -0,0 +1,196 @@
+package stream
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/frozenpine/msgqueue/core"
+)
+
+// LatePolicy decides what stream does with trades
+// arrived after their bar closed
+type LatePolicy uint8
+
+const (
+	// LateRetrace adds late trades to retained closed bar silently,
+	// closed bar is not re-published
+	LateRetrace LatePolicy = iota
+	// LateDrop discards late trades
+	LateDrop
+	// LateSideOutput publishes late trades to late output
+	LateSideOutput
+	// LateCorrect amends closed bar with late trade,
+	// and re-publishes bar flagged as correction
+	LateCorrect
+)
+
+// defaultMaxRetrace is how many closed bars retained for late trades
+const defaultMaxRetrace = 60
+
+func (p LatePolicy) String() string {
+	switch p {
+	case LateRetrace:
+		return "LateRetrace"
+	case LateDrop:
+		return "LateDrop"
+	case LateSideOutput:
+		return "LateSideOutput"
+	case LateCorrect:
+		return "LateCorrect"
+	default:
+		return "Unknown"
+	}
+}
+
+// Clock is time source of timer water marks,
+// can be replaced in tests
+type Clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type kbarOptions struct {
+	eventTime bool
+	lateness  time.Duration
+
+	markInterval time.Duration
+	markClock    Clock
+
+	latePolicy LatePolicy
+	lateOutput core.Producer[Sequence[time.Time, Trade]]
+	maxRetrace int
+
+	align barAlign
+}
+
+func newKBarOptions(opts ...KBarOption) kbarOptions {
+	options := kbarOptions{maxRetrace: defaultMaxRetrace}
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
+
+type KBarOption func(*kbarOptions)
+
+// WithEventTime derives bar boundaries from trade's TradeTime instead of
+// wall clock, bar closes when water mark passes bar's index, water mark is
+// max observed trade time minus allowed lateness
+func WithEventTime(lateness time.Duration) KBarOption {
+	return func(opts *kbarOptions) {
+		opts.eventTime = true
+
+		if lateness > 0 {
+			opts.lateness = lateness
+		}
+	}
+}
+
+// WithWaterMarkTimer injects water mark every interval from clock,
+// so idle bars are closed and published on schedule,
+// nil clock means system clock. In event time mode, clock's time is
+// ignored, water mark is last trade time plus idle interval since then
+func WithWaterMarkTimer(interval time.Duration, clock Clock) KBarOption {
+	return func(opts *kbarOptions) {
+		if interval <= 0 {
+			return
+		}
+
+		if clock == nil {
+			clock = systemClock{}
+		}
+
+		opts.markInterval = interval
+		opts.markClock = clock
+	}
+}
+
+// WithLatePolicy sets how late trades handled, default is LateRetrace,
+// LateSideOutput without late output is same as LateDrop
+func WithLatePolicy(policy LatePolicy) KBarOption {
+	return func(opts *kbarOptions) {
+		opts.latePolicy = policy
+	}
+}
+
+// WithLateOutput publishes late trades to dst, implies LateSideOutput
+func WithLateOutput(dst core.Producer[Sequence[time.Time, Trade]]) KBarOption {
+	return func(opts *kbarOptions) {
+		if dst != nil {
+			opts.latePolicy = LateSideOutput
+			opts.lateOutput = dst
+		}
+	}
+}
+
+// WithMaxRetrace bounds how many closed bars retained in window chain,
+// trades later than that will be dropped, default is 60
+func WithMaxRetrace(n int) KBarOption {
+	return func(opts *kbarOptions) {
+		if n > 0 {
+			opts.maxRetrace = n
+		}
+	}
+}
+
+// WithBarAlign aligns bar boundaries to midnight of loc plus offset
+// instead of UTC midnight, which matters for bars of hours or days,
+// e.g. day bars of trading day starting at 21:00 use offset -3h,
+// nil loc means UTC
+func WithBarAlign(loc *time.Location, offset time.Duration) KBarOption {
+	return func(opts *kbarOptions) {
+		opts.align = barAlign{loc: loc, offset: offset}
+	}
+}
+
+// handleLate handles trade arrived after its bar closed by late policy,
+// returns false if trade is not late or retraced by bar's Push. Closed bar is corrected in lock,
+// as it may be read by stream's PreWindow
+func (opts *kbarOptions) handleLate(
+	bar *KBarWindow, inData Sequence[time.Time, Trade],
+	outChan core.Producer[Sequence[time.Time, KBar]], lock sync.Locker,
+) (bool, error) {
+	if opts.latePolicy == LateRetrace || inData.IsWaterMark() ||
+		!bar.isLate(inData.Value().TradeTime()) {
+		return false, nil
+	}
+
+	switch opts.latePolicy {
+	case LateSideOutput:
+		if opts.lateOutput != nil {
+			return true, opts.lateOutput.Publish(inData, -1)
+		}
+	case LateCorrect:
+		td, ok := inData.(*TradeSequence)
+		if !ok {
+			break
+		}
+
+		if pre := bar.retrace(td.TradeTime()); pre != nil {
+			lock.Lock()
+			pre.add(td)
+			corrected := pre.snapshot(true)
+			lock.Unlock()
+
+			return true, outChan.Publish(corrected, -1)
+		}
+	}
+
+	slog.Debug(
+		"late trade dropped",
+		slog.String("policy", opts.latePolicy.String()),
+		slog.String("instrument", bar.instrument),
+		slog.Time("trade_time", inData.Value().TradeTime()),
+		slog.Time("bar_idx", bar.index),
+	)
+
+	return true, nil
+}
@@ -0,0 +1,147 @@
+package stream
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/frozenpine/msgqueue/core"
+	"github.com/frozenpine/msgqueue/pipeline"
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidGap = errors.New("invalid bar gap")
+
+// KBarRollUp derives bars with larger gap from closed bars,
+// such as 5m/15m/1h/1d bars from 1m bars,
+// source bar's gap must be a divisor of roll up gap
+type KBarRollUp struct {
+	*pipeline.MemoPipeLine[Sequence[time.Time, KBar], Sequence[time.Time, KBar]]
+
+	gap   time.Duration
+	align barAlign
+	bars  map[string]*barSnapshot
+}
+
+// NewKBarRollUp create roll up pipeline subscribing bars from src,
+// roll up will be released when src's output closed,
+// bar not completed at that time will be discarded,
+// only WithBarAlign in opts takes effect
+func NewKBarRollUp(
+	ctx context.Context, name string,
+	src core.Consumer[Sequence[time.Time, KBar]],
+	gap time.Duration, opts ...KBarOption,
+) (*KBarRollUp, error) {
+	if src == nil {
+		return nil, errors.Wrap(core.ErrPipeline, "empty upstream")
+	}
+
+	if gap < Min1BarGap || gap%Min1BarGap != 0 {
+		return nil, errors.Wrapf(ErrInvalidGap, "roll up gap %s", gap)
+	}
+
+	if name == "" {
+		name = "KBarRollUp"
+	}
+
+	rollUp := KBarRollUp{
+		gap:   gap,
+		align: newKBarOptions(opts...).align,
+		bars:  make(map[string]*barSnapshot),
+	}
+
+	pipe, err := pipeline.NewMemoPipeLine(ctx, name, rollUp.convert)
+	if err != nil {
+		return nil, errors.Wrap(err, "create roll up pipeline failed")
+	}
+	rollUp.MemoPipeLine = pipe
+
+	subID, upChan, err := src.Subscribe(rollUp.Name(), core.Quick)
+	if err != nil {
+		rollUp.Release()
+		return nil, errors.Wrap(err, "subscribe upstream failed")
+	}
+
+	go func() {
+		defer rollUp.Release()
+		defer src.UnSubscribe(subID)
+
+		for v := range upChan {
+			if err := rollUp.Publish(v, -1); err != nil {
+				slog.Error(
+					"roll up input failed",
+					slog.Any("error", err),
+					slog.String("name", rollUp.Name()),
+				)
+			}
+		}
+	}()
+
+	return &rollUp, nil
+}
+
+func (r *KBarRollUp) Gap() time.Duration {
+	return r.gap
+}
+
+func (r *KBarRollUp) convert(inData Sequence[time.Time, KBar], outChan core.Producer[Sequence[time.Time, KBar]]) error {
+	bar := inData.Value()
+
+	if bar.IsCorrection() {
+		slog.Debug(
+			"correction bar ignored by roll up",
+			slog.String("instrument", bar.Instrument()),
+			slog.Time("bar_idx", bar.Index()),
+		)
+
+		return nil
+	}
+
+	if precise := bar.Precise(); precise <= 0 || precise >= r.gap || r.gap%precise != 0 {
+		slog.Error(
+			"source bar gap mismatch with roll up",
+			slog.Duration("source", precise),
+			slog.Duration("gap", r.gap),
+		)
+
+		return errors.Wrapf(ErrInvalidGap, "source gap %s", precise)
+	}
+
+	index := r.align.index(bar.Index(), r.gap)
+
+	curr, exist := r.bars[bar.Instrument()]
+
+	if exist && curr.index.Before(index) {
+		// source bar gap missing, publish uncompleted bar
+		slog.Warn(
+			"roll up bar not completed",
+			slog.String("instrument", curr.instrument),
+			slog.Time("bar_idx", curr.index),
+		)
+
+		if err := outChan.Publish(curr, -1); err != nil {
+			return err
+		}
+
+		exist = false
+	}
+
+	if !exist {
+		curr = &barSnapshot{
+			instrument: bar.Instrument(),
+			precise:    r.gap,
+			index:      index,
+		}
+		r.bars[curr.instrument] = curr
+	}
+
+	curr.merge(bar)
+
+	if bar.Index().Equal(curr.index) {
+		delete(r.bars, curr.instrument)
+
+		return outChan.Publish(curr, -1)
+	}
+
+	return nil
+}
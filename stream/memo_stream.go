@@ -2,7 +2,9 @@ package stream
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"reflect"
 	"sync"
 	"time"
 
@@ -27,10 +29,37 @@ type MemoStream[
 
 	pipeline pipeline.Pipeline[Sequence[IDX, IV], Sequence[IDX, OV]]
 
-	windowCache []Window[IDX, IV, OV]
+	windowLock  sync.RWMutex
+	windowCache []closedWindow[IDX, IV, OV]
 	currWindow  Window[IDX, IV, OV]
+	newWindow   func() Window[IDX, IV, OV]
+	history     streamOptions
 
 	aggregator Aggregator[IDX, IV, OV]
+
+	deriveLock  sync.RWMutex
+	spawn       func(name string) (Stream[IDX, IV, OV, KEY], error)
+	filters     []*filteredStream[IDX, IV, OV, KEY]
+	groupKey    func(Sequence[IDX, IV]) KEY
+	groupNotify func(KEY, Stream[IDX, IV, OV, KEY])
+	groups      map[KEY]Stream[IDX, IV, OV, KEY]
+}
+
+type closedWindow[
+	IDX comparable,
+	IV, OV any,
+] struct {
+	window Window[IDX, IV, OV]
+	closed time.Time
+}
+
+type filteredStream[
+	IDX comparable,
+	IV, OV any,
+	KEY comparable,
+] struct {
+	filter func(Sequence[IDX, IV]) bool
+	stream Stream[IDX, IV, OV, KEY]
 }
 
 func NewMemoStream[
@@ -41,65 +70,372 @@ func NewMemoStream[
 	ctx context.Context, name string,
 	initWin Window[IDX, IV, OV],
 	agg Aggregator[IDX, IV, OV],
+	opts ...StreamOption,
 ) (*MemoStream[IDX, IV, OV, KEY], error) {
 	if agg == nil {
 		return nil, errors.Wrap(ErrInvalidAggregator, "aggregator missing")
 	}
 	stream := MemoStream[IDX, IV, OV, KEY]{}
 
+	for _, opt := range opts {
+		opt(&stream.history)
+	}
+
 	slog.Debug("creating new memo stream")
 
+	var err error
+
 	stream.Init(ctx, name, func() {
 		slog.Debug("memo stream extra init")
 
 		if initWin == nil {
 			initWin = &DefaultWindow[IDX, IV, OV]{}
+			stream.newWindow = func() Window[IDX, IV, OV] {
+				return &DefaultWindow[IDX, IV, OV]{}
+			}
+		} else {
+			// initWin will be changed by pushing, so keep an
+			// untouched window as template of derived streams
+			template := initWin.NextWindow()
+			stream.newWindow = template.NextWindow
 		}
 
-		stream.pipeline = pipeline.NewMemoPipeLine(
+		stream.pipeline, err = pipeline.NewMemoPipeLine(
 			context.Background(),
 			stream.name+"_pipeline", stream.convert)
 		stream.currWindow = initWin
 		stream.aggregator = agg
 	})
 
+	if err != nil {
+		stream.cancelFn()
+		return nil, errors.Wrap(err, "create stream pipeline failed")
+	}
+
 	return &stream, nil
 }
 
-func (strm *MemoStream[IDX, IV, OV, KEY]) convert(inData Sequence[IDX, IV], outChan core.Producer[Sequence[IDX, OV]]) error {
-	err := strm.currWindow.Push(inData)
+// derive create child stream with same aggregator,
+// child's window chain starts from a new window.
+// Streams with own convert path set spawn, so their
+// derived streams are created with same convert path
+func (strm *MemoStream[IDX, IV, OV, KEY]) derive(name string) (Stream[IDX, IV, OV, KEY], error) {
+	if strm.spawn != nil {
+		return strm.spawn(name)
+	}
+
+	child := MemoStream[IDX, IV, OV, KEY]{}
+
+	var err error
+
+	child.Init(strm.runCtx, name, func() {
+		child.pipeline, err = pipeline.NewMemoPipeLine(
+			context.Background(),
+			child.name+"_pipeline", child.convert)
+		child.newWindow = strm.newWindow
+		child.currWindow = strm.newWindow()
+		child.aggregator = strm.aggregator
+		child.history = strm.history
+	})
+
+	if err != nil {
+		child.cancelFn()
+		return nil, errors.Wrap(err, "create derived stream pipeline failed")
+	}
+
+	return &child, nil
+}
+
+// FilterBy create derived stream which only matched sequences
+// will be pushed into its windows, water marks always pass through,
+// returns nil if filter is nil or derived stream creation failed
+func (strm *MemoStream[IDX, IV, OV, KEY]) FilterBy(filter func(Sequence[IDX, IV]) bool) Stream[IDX, IV, OV, KEY] {
+	if filter == nil {
+		return nil
+	}
+
+	child, err := strm.derive(strm.name + "_filter")
+	if err != nil {
+		slog.Error(
+			"create filtered stream failed",
+			slog.Any("error", err),
+			slog.String("name", strm.name),
+		)
+		return nil
+	}
+
+	strm.deriveLock.Lock()
+	strm.filters = append(strm.filters, &filteredStream[IDX, IV, OV, KEY]{
+		filter: filter,
+		stream: child,
+	})
+	strm.deriveLock.Unlock()
+
+	return child
+}
+
+// GroupBy partitions input sequences by key into child streams,
+// child stream is created when new key arrived,
+// water marks will be broadcasted to all child streams.
+// it returns snapshot of created child streams, which is empty
+// before any input, use GroupByNotify to discover new keys.
+// nil key only gets snapshot, key func different from
+// first call's is rejected and nil returned
+func (strm *MemoStream[IDX, IV, OV, KEY]) GroupBy(key func(Sequence[IDX, IV]) KEY) map[KEY]Stream[IDX, IV, OV, KEY] {
+	groups, err := strm.GroupByNotify(key, nil)
+	if err != nil {
+		slog.Error(
+			"group stream failed",
+			slog.String("name", strm.name),
+			slog.Any("error", err),
+		)
+		return nil
+	}
+
+	return groups
+}
+
+// GroupByNotify is same as GroupBy, notify will be called in stream's
+// dispatcher when child stream created, before any sequence pushed into it,
+// so subscribing child stream in notify will not miss any output.
+// notify is called out of stream's lock, so it can call GroupBy.
+// stream is grouped by one key func, later call with different
+// key func or notify returns ErrGroupKeyMismatch, funcs are
+// compared by code pointer, so closures of same func are equal
+func (strm *MemoStream[IDX, IV, OV, KEY]) GroupByNotify(
+	key func(Sequence[IDX, IV]) KEY,
+	notify func(KEY, Stream[IDX, IV, OV, KEY]),
+) (map[KEY]Stream[IDX, IV, OV, KEY], error) {
+	strm.deriveLock.Lock()
+	defer strm.deriveLock.Unlock()
 
 	switch {
-	case errors.Is(err, ErrWindowClosed):
-		result, err := strm.aggregator(strm.currWindow)
+	case key == nil:
+	case strm.groupKey == nil:
+		strm.groupKey = key
+		strm.groupNotify = notify
+		strm.groups = make(map[KEY]Stream[IDX, IV, OV, KEY])
+	case !sameFunc(strm.groupKey, key):
+		return nil, errors.Wrap(ErrGroupKeyMismatch, "key func changed")
+	case notify != nil && !sameFunc(strm.groupNotify, notify):
+		return nil, errors.Wrap(ErrGroupKeyMismatch, "notify func changed")
+	}
+
+	result := make(map[KEY]Stream[IDX, IV, OV, KEY], len(strm.groups))
+	for k, child := range strm.groups {
+		result[k] = child
+	}
+
+	return result, nil
+}
+
+// sameFunc checks if funcs have same code pointer
+func sameFunc[F any](a, b F) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+
+	return va.IsValid() && vb.IsValid() &&
+		!va.IsNil() && !vb.IsNil() && va.Pointer() == vb.Pointer()
+}
+
+// groupStream get child stream of key, child stream is only
+// created by stream's dispatcher, so notify is called before
+// any sequence pushed into it
+func (strm *MemoStream[IDX, IV, OV, KEY]) groupStream(key KEY) (Stream[IDX, IV, OV, KEY], error) {
+	strm.deriveLock.RLock()
+	child, exist := strm.groups[key]
+	strm.deriveLock.RUnlock()
+
+	if exist {
+		return child, nil
+	}
+
+	child, err := strm.derive(fmt.Sprintf("%s_%v", strm.name, key))
+	if err != nil {
+		return nil, err
+	}
+
+	strm.deriveLock.Lock()
+	strm.groups[key] = child
+	notify := strm.groupNotify
+	strm.deriveLock.Unlock()
+
+	if notify != nil {
+		notify(key, child)
+	}
+
+	return child, nil
+}
+
+// dispatchDerived publishes sequence to derived streams,
+// returns error if grouped stream creation failed
+func (strm *MemoStream[IDX, IV, OV, KEY]) dispatchDerived(inData Sequence[IDX, IV]) error {
+	strm.deriveLock.RLock()
+	filters := strm.filters
+	groupKey := strm.groupKey
+	var groups []Stream[IDX, IV, OV, KEY]
+	if groupKey != nil && inData.IsWaterMark() {
+		groups = make([]Stream[IDX, IV, OV, KEY], 0, len(strm.groups))
+		for _, child := range strm.groups {
+			groups = append(groups, child)
+		}
+	}
+	strm.deriveLock.RUnlock()
+
+	for _, f := range filters {
+		if !inData.IsWaterMark() && !f.filter(inData) {
+			continue
+		}
+
+		if err := f.stream.Publish(inData, -1); err != nil {
+			slog.Error(
+				"filtered stream input failed",
+				slog.Any("error", err),
+				slog.String("name", f.stream.Name()),
+			)
+		}
+	}
+
+	if groupKey == nil {
+		return nil
+	}
 
+	if !inData.IsWaterMark() {
+		child, err := strm.groupStream(groupKey(inData))
 		if err != nil {
 			return err
 		}
 
-		if err = outChan.Publish(result, -1); err != nil {
+		groups = append(groups, child)
+	}
+
+	for _, child := range groups {
+		if err := child.Publish(inData, -1); err != nil {
 			slog.Error(
-				"stream out failed",
+				"grouped stream input failed",
 				slog.Any("error", err),
+				slog.String("name", child.Name()),
 			)
-			return err
 		}
+	}
 
-		strm.windowCache = append(strm.windowCache, strm.currWindow)
-		strm.currWindow = strm.currWindow.NextWindow()
+	return nil
+}
 
-		return nil
-	case errors.Is(err, ErrHistorySequence):
-		slog.Error(
-			"history sequence arrived",
-			slog.Any("idx", inData.Index()),
-			slog.Any("value", inData.Value()),
-		)
+func (strm *MemoStream[IDX, IV, OV, KEY]) children() []Stream[IDX, IV, OV, KEY] {
+	strm.deriveLock.RLock()
+	defer strm.deriveLock.RUnlock()
 
-		return nil
-	default:
+	result := make([]Stream[IDX, IV, OV, KEY], 0, len(strm.filters)+len(strm.groups))
+
+	for _, f := range strm.filters {
+		result = append(result, f.stream)
+	}
+
+	for _, child := range strm.groups {
+		result = append(result, child)
+	}
+
+	return result
+}
+
+func (strm *MemoStream[IDX, IV, OV, KEY]) convert(inData Sequence[IDX, IV], outChan core.Producer[Sequence[IDX, OV]]) error {
+	if err := strm.dispatchDerived(inData); err != nil {
 		return err
 	}
+
+	return strm.pushWindow(inData, outChan)
+}
+
+// pushWindow pushes sequence into current window,
+// publishes aggregated result if window closed
+func (strm *MemoStream[IDX, IV, OV, KEY]) pushWindow(inData Sequence[IDX, IV], outChan core.Producer[Sequence[IDX, OV]]) error {
+	for {
+		strm.windowLock.Lock()
+		curr := strm.currWindow
+		err := curr.Push(inData)
+		strm.windowLock.Unlock()
+
+		switch {
+		case errors.Is(err, ErrWindowClosed), errors.Is(err, ErrWindowFilled):
+			filled := errors.Is(err, ErrWindowFilled)
+
+			result, err := strm.aggregator(curr)
+
+			if err != nil {
+				return err
+			}
+
+			if err = outChan.Publish(result, -1); err != nil {
+				slog.Error(
+					"stream out failed",
+					slog.Any("error", err),
+				)
+				return err
+			}
+
+			strm.rollWindow(curr)
+
+			// water mark only closes one window,
+			// data will be pushed into next window
+			if filled || inData.IsWaterMark() {
+				return nil
+			}
+		case errors.Is(err, ErrHistorySequence):
+			slog.Error(
+				"history sequence arrived",
+				slog.Any("idx", inData.Index()),
+				slog.Any("value", inData.Value()),
+			)
+
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// rollWindow moves closed window into history and
+// evicts windows out of retention
+func (strm *MemoStream[IDX, IV, OV, KEY]) rollWindow(curr Window[IDX, IV, OV]) {
+	now := time.Now()
+
+	strm.windowLock.Lock()
+	defer strm.windowLock.Unlock()
+
+	strm.windowCache = append(strm.windowCache, closedWindow[IDX, IV, OV]{
+		window: curr, closed: now,
+	})
+	strm.currWindow = curr.NextWindow()
+
+	count := strm.history.historyCount
+	if count <= 0 {
+		count = defaultHistoryCount
+	}
+	age := strm.history.historyAge
+
+	evict := 0
+	for evict < len(strm.windowCache) {
+		remain := len(strm.windowCache) - evict
+
+		if remain <= count && (age <= 0 || now.Sub(strm.windowCache[evict].closed) <= age) {
+			break
+		}
+
+		evict++
+	}
+
+	if evict == 0 {
+		return
+	}
+
+	// cut window chain after newest evicted window,
+	// so older windows can be released
+	if win, ok := strm.windowCache[evict-1].window.(detachable); ok {
+		win.detach()
+	}
+
+	clear(strm.windowCache[:evict])
+	strm.windowCache = strm.windowCache[evict:]
 }
 
 func (strm *MemoStream[IDX, IV, OV, KEY]) ID() uuid.UUID {
@@ -136,7 +472,9 @@ func (strm *MemoStream[IDX, IV, OV, KEY]) Join() {
 
 	strm.pipeline.Join()
 
-	// TODO: extra join
+	for _, child := range strm.children() {
+		child.Join()
+	}
 }
 
 func (strm *MemoStream[IDX, IV, OV, KEY]) Release() {
@@ -144,7 +482,15 @@ func (strm *MemoStream[IDX, IV, OV, KEY]) Release() {
 		strm.cancelFn()
 
 		strm.pipeline.Release()
-		// TODO: extra release
+
+		// child streams released after all input dispatched
+		go func() {
+			strm.pipeline.Join()
+
+			for _, child := range strm.children() {
+				child.Release()
+			}
+		}()
 	})
 }
 
@@ -152,7 +498,7 @@ func (strm *MemoStream[IDX, IV, OV, KEY]) Publish(v Sequence[IDX, IV], timeout t
 	return strm.pipeline.Publish(v, timeout)
 }
 
-func (strm *MemoStream[IDX, IV, OV, KEY]) Subscribe(name string, resume core.ResumeType) (uuid.UUID, <-chan Sequence[IDX, OV]) {
+func (strm *MemoStream[IDX, IV, OV, KEY]) Subscribe(name string, resume core.ResumeType) (uuid.UUID, <-chan Sequence[IDX, OV], error) {
 	return strm.pipeline.Subscribe(name, resume)
 }
 
@@ -160,10 +506,41 @@ func (strm *MemoStream[IDX, IV, OV, KEY]) UnSubscribe(subID uuid.UUID) error {
 	return strm.pipeline.UnSubscribe(subID)
 }
 
-func (strm *MemoStream[IDX, IV, OV, KEY]) PipelineUpstream(src core.Consumer[Sequence[IDX, IV]]) error {
+func (strm *MemoStream[IDX, IV, OV, KEY]) PipelineUpStream(src core.Consumer[Sequence[IDX, IV]]) error {
 	return strm.pipeline.PipelineUpStream(src)
 }
 
 func (strm *MemoStream[IDX, IV, OV, KEY]) PipelineDownStream(dst core.Upstream[Sequence[IDX, OV]]) error {
 	return strm.pipeline.PipelineDownStream(dst)
 }
+
+// PreWindow get snapshot of n count previous window,
+// if n count <= 0, will return current window,
+// returns nil if window evicted from history
+func (strm *MemoStream[IDX, IV, OV, KEY]) PreWindow(n int) Window[IDX, IV, OV] {
+	if n <= 0 {
+		return strm.CurrWindow()
+	}
+
+	strm.windowLock.RLock()
+	defer strm.windowLock.RUnlock()
+
+	if n > len(strm.windowCache) {
+		return nil
+	}
+
+	return freeze(strm.windowCache[len(strm.windowCache)-n].window)
+}
+
+// CurrWindow get snapshot of window accepting sequences,
+// snapshot is not changed by stream's dispatcher
+func (strm *MemoStream[IDX, IV, OV, KEY]) CurrWindow() Window[IDX, IV, OV] {
+	strm.windowLock.RLock()
+	defer strm.windowLock.RUnlock()
+
+	if strm.currWindow == nil {
+		return nil
+	}
+
+	return freeze(strm.currWindow)
+}
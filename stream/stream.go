@@ -9,7 +9,9 @@ import (
 var (
 	ErrInvalidAggregator = errors.New("invalid aggregator")
 	ErrWindowClosed      = errors.New("window closed")
+	ErrWindowFilled      = errors.New("window filled")
 	ErrHistorySequence   = errors.New("history sequence")
+	ErrGroupKeyMismatch  = errors.New("group key mismatch")
 )
 
 type Sequence[IDX comparable, V any] interface {
@@ -34,7 +36,10 @@ type Window[
 	Values() []IV
 	Series() []Sequence[IDX, IV]
 	// Push if window change, error must be an Wrap of
-	// ErrWindowClosed or ErrHistorySequence
+	// ErrWindowClosed, ErrWindowFilled or ErrHistorySequence.
+	// ErrWindowClosed means sequence not accepted and will be pushed
+	// into next window, ErrWindowFilled means sequence accepted
+	// and window closed
 	Push(Sequence[IDX, IV]) error
 	PreWindow() Window[IDX, IV, OV]
 	NextWindow() Window[IDX, IV, OV]
@@ -56,6 +61,18 @@ type Stream[
 	CurrWindow() Window[IDX, IV, OV]
 }
 
+// GroupNotifier is stream notifying child stream of new key created
+type GroupNotifier[
+	IDX comparable,
+	IV, OV any,
+	KEY comparable,
+] interface {
+	GroupByNotify(
+		func(Sequence[IDX, IV]) KEY,
+		func(KEY, Stream[IDX, IV, OV, KEY]),
+	) (map[KEY]Stream[IDX, IV, OV, KEY], error)
+}
+
 type DefaultWindow[
 	IDX comparable,
 	IV, OV any,
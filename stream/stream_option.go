@@ -0,0 +1,130 @@
+package stream
+
+import (
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultHistoryCount is how many closed windows retained in stream
+const defaultHistoryCount = 100
+
+type streamOptions struct {
+	historyCount int
+	historyAge   time.Duration
+}
+
+type StreamOption func(*streamOptions)
+
+// WithHistoryCount bounds how many closed windows retained
+// for PreWindow, default is 100
+func WithHistoryCount(n int) StreamOption {
+	return func(opts *streamOptions) {
+		if n > 0 {
+			opts.historyCount = n
+		}
+	}
+}
+
+// WithHistoryAge evicts closed windows older than age,
+// age is checked when window closed, non-positive age means no limit
+func WithHistoryAge(age time.Duration) StreamOption {
+	return func(opts *streamOptions) {
+		opts.historyAge = age
+	}
+}
+
+// detachable is implemented by windows which can drop
+// reference to previous window, so evicted windows can be released
+type detachable interface {
+	detach()
+}
+
+func (win *DefaultWindow[IDX, IV, OV]) detach() { win.pre = nil }
+func (win *TimeWindow[IV, OV]) detach()         { win.pre = nil }
+func (win *CountWindow[IDX, IV, OV]) detach()   { win.pre = nil }
+func (win *SessionWindow[IV, OV]) detach()      { win.pre = nil }
+func (k *KBarWindow) detach()                   { k.preBar = nil }
+
+// cloneable is implemented by windows which can be copied,
+// copy has no previous window so it's not changed by dispatcher
+type cloneable[IDX comparable, IV, OV any] interface {
+	clone() Window[IDX, IV, OV]
+}
+
+func (win *DefaultWindow[IDX, IV, OV]) clone() Window[IDX, IV, OV] {
+	return &DefaultWindow[IDX, IV, OV]{sequence: slices.Clone(win.sequence)}
+}
+
+func (win *TimeWindow[IV, OV]) clone() Window[time.Time, IV, OV] {
+	copied := *win
+	copied.sequence = slices.Clone(win.sequence)
+	copied.pre = nil
+
+	return &copied
+}
+
+func (win *CountWindow[IDX, IV, OV]) clone() Window[IDX, IV, OV] {
+	copied := *win
+	copied.sequence = slices.Clone(win.sequence)
+	copied.pre = nil
+
+	return &copied
+}
+
+func (win *SessionWindow[IV, OV]) clone() Window[time.Time, IV, OV] {
+	copied := *win
+	copied.sequence = slices.Clone(win.sequence)
+	copied.pre = nil
+
+	return &copied
+}
+
+// clone keeps previous bar's close as pre settle, so empty copy
+// has same price as original bar
+func (k *KBarWindow) clone() Window[time.Time, Trade, KBar] {
+	return &KBarWindow{
+		preSettle:   k.getPrePrice(),
+		data:        slices.Clone(k.data),
+		totalVolume: k.totalVolume,
+		max:         k.max,
+		min:         k.min,
+		precise:     k.precise,
+		index:       k.index,
+		instrument:  k.instrument,
+		pending:     slices.Clone(k.pending),
+		retain:      k.retain,
+		align:       k.align,
+	}
+}
+
+// frozenWindow is copy of window without clone,
+// which can not be pushed or rolled
+type frozenWindow[IDX comparable, IV, OV any] struct {
+	indexs []IDX
+	values []IV
+	series []Sequence[IDX, IV]
+}
+
+func freeze[IDX comparable, IV, OV any](win Window[IDX, IV, OV]) Window[IDX, IV, OV] {
+	if win, ok := win.(cloneable[IDX, IV, OV]); ok {
+		return win.clone()
+	}
+
+	return &frozenWindow[IDX, IV, OV]{
+		indexs: win.Indexs(),
+		values: win.Values(),
+		series: slices.Clone(win.Series()),
+	}
+}
+
+func (win *frozenWindow[IDX, IV, OV]) Indexs() []IDX                   { return win.indexs }
+func (win *frozenWindow[IDX, IV, OV]) Values() []IV                    { return win.values }
+func (win *frozenWindow[IDX, IV, OV]) Series() []Sequence[IDX, IV]     { return win.series }
+func (win *frozenWindow[IDX, IV, OV]) PreWindow() Window[IDX, IV, OV]  { return nil }
+func (win *frozenWindow[IDX, IV, OV]) NextWindow() Window[IDX, IV, OV] { return nil }
+
+func (win *frozenWindow[IDX, IV, OV]) Push(Sequence[IDX, IV]) error {
+	return errors.Wrap(ErrWindowClosed, "window frozen")
+}
@@ -3,10 +3,12 @@ package stream
 import (
 	"context"
 	"math/rand"
+	"slices"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/frozenpine/msgqueue/channel"
 	"github.com/frozenpine/msgqueue/core"
 	"github.com/pkg/errors"
 )
@@ -101,12 +103,14 @@ func TestMemoStream(t *testing.T) {
 
 	wg.Add(1)
 	go func() {
-		subID, ch := stream.Subscribe("test1", core.Quick)
+		defer wg.Done()
 
-		defer func() {
-			stream.UnSubscribe(subID)
-			wg.Done()
-		}()
+		subID, ch, err := stream.Subscribe("test1", core.Quick)
+		if err != nil {
+			t.Error("subscribe failed:", err)
+			return
+		}
+		defer stream.UnSubscribe(subID)
 
 		for out := range ch {
 			t.Log(out.Index(), out.Value())
@@ -159,17 +163,22 @@ func TestKBar(t *testing.T) {
 
 	src := rand.NewSource(time.Now().UnixNano())
 
-	stream := NewKBarStream(context.TODO(), "Kbar", 1006, Min1BarGap)
+	stream, err := NewKBarStream(context.TODO(), "Kbar", 1006, Min1BarGap)
+	if err != nil {
+		t.Fatal("create stream failed:", err)
+	}
 	wg := sync.WaitGroup{}
 
 	wg.Add(1)
 	go func() {
-		subID, ch := stream.Subscribe("test", core.Quick)
+		defer wg.Done()
 
-		defer func() {
-			stream.UnSubscribe(subID)
-			wg.Done()
-		}()
+		subID, ch, err := stream.Subscribe("test", core.Quick)
+		if err != nil {
+			t.Error("subscribe failed:", err)
+			return
+		}
+		defer stream.UnSubscribe(subID)
 
 		for seq := range ch {
 			bar := seq.Value()
@@ -210,3 +219,1359 @@ func TestKBar(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestFilterGroupBy(t *testing.T) {
+	var _ Stream[time.Time, int, int, string] = (*MemoStream[time.Time, int, int, string])(nil)
+	var _ GroupNotifier[time.Time, int, int, string] = (*MemoStream[time.Time, int, int, string])(nil)
+
+	stream, err := NewMemoStream[
+		time.Time, int, int,
+		string,
+	](
+		context.TODO(), "GroupStream", nil,
+		func(in Window[
+			time.Time, int, int,
+		]) (Sequence[time.Time, int], error) {
+			var result int
+
+			for _, v := range in.Values() {
+				result += v
+			}
+
+			return &sequence[int]{data: result, ts: time.Now()}, nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+	resultLock := sync.Mutex{}
+	results := map[string][]int{}
+
+	collect := func(name string, strm Stream[time.Time, int, int, string]) {
+		subID, ch, err := strm.Subscribe(name, core.Quick)
+		if err != nil {
+			t.Error("subscribe failed:", err)
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer strm.UnSubscribe(subID)
+
+			for out := range ch {
+				resultLock.Lock()
+				results[name] = append(results[name], out.Value())
+				resultLock.Unlock()
+			}
+		}()
+	}
+
+	collect("all", stream)
+	collect("big", stream.FilterBy(func(s Sequence[time.Time, int]) bool {
+		return s.Value() >= 5
+	}))
+
+	parity := func(s Sequence[time.Time, int]) string {
+		if s.Value()%2 == 0 {
+			return "even"
+		}
+		return "odd"
+	}
+
+	if groups, err := stream.GroupByNotify(
+		parity,
+		func(key string, child Stream[time.Time, int, int, string]) {
+			if _, exist := stream.GroupBy(nil)[key]; !exist {
+				t.Error("notified group not found:", key)
+			}
+
+			collect(key, child)
+		},
+	); err != nil || len(groups) != 0 {
+		t.Fatal("group created before input:", groups, err)
+	}
+
+	if groups := stream.GroupBy(parity); groups == nil {
+		t.Fatal("same group key rejected")
+	}
+
+	if _, err := stream.GroupByNotify(func(s Sequence[time.Time, int]) string {
+		return "all"
+	}, nil); !errors.Is(err, ErrGroupKeyMismatch) {
+		t.Fatal("group key mismatch not checked:", err)
+	}
+
+	for round := 0; round < 2; round++ {
+		for v := 0; v < 10; v++ {
+			if err = stream.Publish(&sequence[int]{
+				data: v, ts: time.Now(),
+			}, -1); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err = stream.Publish(&sequence[int]{
+			ts: time.Now(), mark: true,
+		}, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stream.Release()
+	stream.Join()
+	wg.Wait()
+
+	if groups := stream.GroupBy(nil); len(groups) != 2 {
+		t.Fatal("group count mismatch:", groups)
+	}
+
+	expect := map[string][]int{
+		"all":  {45, 45},
+		"big":  {35, 35},
+		"even": {20, 20},
+		"odd":  {25, 25},
+	}
+
+	for name, values := range expect {
+		got := results[name]
+		if len(got) != len(values) {
+			t.Fatalf("%s result mismatch: %v", name, got)
+		}
+
+		for idx, v := range values {
+			if got[idx] != v {
+				t.Fatalf("%s result mismatch: %v", name, got)
+			}
+		}
+	}
+}
+
+type insTrade struct {
+	trade
+
+	instrument string
+}
+
+func TestMultiKBar(t *testing.T) {
+	stream, err := NewMultiKBarStream(
+		context.TODO(), "MultiKBar",
+		func(td Trade) string { return td.(*insTrade).instrument },
+		map[string]float64{"A": 100, "B": 200},
+		Min1BarGap,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stream.CurrWindow() != nil || stream.PreWindow(1) != nil {
+		t.Fatal("multi instrument stream has no single window")
+	}
+
+	type result struct {
+		instrument string
+		volume     int
+		open       float64
+		close      float64
+	}
+
+	wg := sync.WaitGroup{}
+
+	collect := func(strm Stream[time.Time, Trade, KBar, string]) *[]result {
+		subID, ch, err := strm.Subscribe("test", core.Quick)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results := []result{}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer strm.UnSubscribe(subID)
+
+			for seq := range ch {
+				bar := seq.Value()
+
+				t.Log(
+					strm.Name(),
+					bar.Instrument(),
+					seq.Index().Format("2006-01-02 15:04:05.000"),
+					bar.Volume(),
+					bar.Open(), bar.High(),
+					bar.Low(), bar.Close(),
+				)
+
+				results = append(results, result{
+					instrument: bar.Instrument(),
+					volume:     bar.Volume(),
+					open:       bar.Open(),
+					close:      bar.Close(),
+				})
+			}
+		}()
+
+		return &results
+	}
+
+	child := stream.FilterBy(func(s Sequence[time.Time, Trade]) bool {
+		return s.Value().(*insTrade).instrument == "A"
+	})
+	if child == nil {
+		t.Fatal("create filtered stream failed")
+	}
+
+	results := collect(stream)
+	childResults := collect(child)
+
+	base := time.Now()
+
+	for _, td := range []*insTrade{
+		{trade{price: 101, volume: 1, ts: base}, "A"},
+		{trade{price: 102, volume: 1, ts: base}, "A"},
+		{trade{price: 201, volume: 1, ts: base}, "B"},
+		{trade{price: 103, volume: 1, ts: base.Add(Min1BarGap)}, "A"},
+		{trade{price: 203, volume: 1, ts: base.Add(Min1BarGap * 2)}, "B"},
+	} {
+		if err := stream.Publish(NewTradeSequence(td), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := stream.Publish(&TradeSequence{ts: time.Now()}, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	stream.Release()
+	stream.Join()
+	wg.Wait()
+
+	for _, c := range []struct {
+		results *[]result
+		expect  []result
+	}{
+		{results, []result{
+			{"A", 2, 101, 102},
+			{"B", 1, 201, 201},
+			{"B", 0, 201, 201},
+			{"A", 1, 103, 103},
+			{"B", 1, 203, 203},
+		}},
+		// derived stream aggregates by instrument too
+		{childResults, []result{
+			{"A", 2, 101, 102},
+			{"A", 1, 103, 103},
+		}},
+	} {
+		results := *c.results
+
+		if len(results) != len(c.expect) {
+			t.Fatalf("bar count mismatch: %+v", results)
+		}
+
+		for idx, v := range c.expect {
+			if results[idx] != v {
+				t.Fatalf("bar[%d] mismatch: %+v, expect %+v", idx, results[idx], v)
+			}
+		}
+	}
+
+	if win := stream.InstrumentWindow("A", 1); win == nil ||
+		win.(KBar).Volume() != 1 || win.(KBar).Close() != 103 {
+		t.Fatal("instrument pre window mismatch:", win)
+	}
+
+	if win := stream.InstrumentWindow("B", 0); win == nil || win.(KBar).Volume() != 0 {
+		t.Fatal("instrument curr window mismatch:", win)
+	}
+
+	if win := stream.InstrumentWindow("C", 0); win != nil {
+		t.Fatal("unknown instrument has window:", win)
+	}
+
+	if ins := stream.Instruments(); len(ins) != 2 || ins[0] != "A" || ins[1] != "B" {
+		t.Fatal("instruments mismatch:", ins)
+	}
+}
+
+func TestKBarRollUp(t *testing.T) {
+	kbar, err := NewKBarStream(context.TODO(), "Kbar5m", 1000, Min5BarGap)
+	if err != nil {
+		t.Fatal("create stream failed:", err)
+	}
+
+	if bar := kbar.CurrWindow().(*KBarWindow); bar.Precise() != Min5BarGap {
+		t.Fatal("bar gap not honored:", bar.Precise())
+	}
+
+	stream, err := NewMultiKBarStream(
+		context.TODO(), "RollUpSrc",
+		func(td Trade) string { return td.(*insTrade).instrument },
+		nil, Min1BarGap,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := stream.RollUp(time.Second * 90); !errors.Is(err, ErrInvalidGap) {
+		t.Fatal("invalid gap not checked:", err)
+	}
+
+	rollUp, err := stream.RollUp(Min5BarGap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, srcCh, err := stream.Subscribe("src", core.Quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, dstCh, err := rollUp.Subscribe("dst", core.Quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srcBars := []KBar{}
+	dstBars := []KBar{}
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for seq := range srcCh {
+			srcBars = append(srcBars, seq.Value())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for seq := range dstCh {
+			dstBars = append(dstBars, seq.Value())
+		}
+	}()
+
+	base := time.Now()
+	for idx := 0; idx <= 10; idx++ {
+		if err := stream.Publish(NewTradeSequence(&insTrade{trade{
+			price: float64(100 + idx), volume: 1,
+			ts: base.Add(Min1BarGap * time.Duration(idx)),
+		}, "A"}), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.Publish(&TradeSequence{ts: time.Now()}, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	stream.Release()
+	stream.Join()
+	wg.Wait()
+	rollUp.Join()
+
+	if len(srcBars) != 11 {
+		t.Fatal("source bar count mismatch:", len(srcBars))
+	}
+
+	expect := []*barSnapshot{}
+	var curr *barSnapshot
+	for _, bar := range srcBars {
+		index := bar.Index().Truncate(Min5BarGap)
+		if index.Before(bar.Index()) {
+			index = index.Add(Min5BarGap)
+		}
+
+		if curr == nil || !curr.index.Equal(index) {
+			curr = &barSnapshot{index: index}
+		}
+		curr.merge(bar)
+
+		if bar.Index().Equal(index) {
+			expect = append(expect, curr)
+		}
+	}
+
+	if len(dstBars) != len(expect) {
+		t.Fatalf("roll up count mismatch: %d, expect %d", len(dstBars), len(expect))
+	}
+
+	for idx, v := range expect {
+		bar := dstBars[idx]
+
+		t.Log(bar.Index(), bar.Volume(), bar.Open(), bar.High(), bar.Low(), bar.Close())
+
+		if bar.Precise() != Min5BarGap || bar.Instrument() != "A" ||
+			!bar.Index().Equal(v.index) || bar.Volume() != v.volume ||
+			bar.Open() != v.open || bar.Close() != v.close ||
+			bar.High() != v.high || bar.Low() != v.low {
+			t.Fatalf("roll up bar[%d] mismatch: %+v, expect %+v", idx, bar, v)
+		}
+	}
+}
+
+func TestBarAlign(t *testing.T) {
+	cst := time.FixedZone("CST", 8*3600)
+	at := func(day, hour int) time.Time {
+		return time.Date(2022, 12, day, hour, 0, 0, 0, cst)
+	}
+
+	for _, c := range []struct {
+		name   string
+		align  barAlign
+		ts     time.Time
+		expect time.Time
+	}{
+		{"utc", barAlign{}, at(9, 10), at(10, 8)},
+		{"loc", barAlign{loc: cst}, at(9, 10), at(10, 0)},
+		{"loc boundary", barAlign{loc: cst}, at(10, 0), at(10, 0)},
+		{"trading day", barAlign{loc: cst, offset: -3 * time.Hour}, at(9, 20), at(9, 21)},
+		{"night session", barAlign{loc: cst, offset: -3 * time.Hour}, at(9, 22), at(10, 21)},
+	} {
+		if index := c.align.index(c.ts, 24*time.Hour); !index.Equal(c.expect) {
+			t.Fatalf("%s: day bar index mismatch: %s, expect %s", c.name, index.In(cst), c.expect)
+		}
+	}
+
+	src := channel.NewMemoChannel[Sequence[time.Time, KBar]](context.TODO(), "HourBars", 0)
+
+	rollUp, err := NewKBarRollUp(
+		context.TODO(), "DayBars", src, 24*time.Hour, WithBarAlign(cst, 0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ch, err := rollUp.Subscribe("day", core.Quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, idx := range []time.Time{at(9, 23), at(10, 0), at(10, 1)} {
+		if err := src.Publish(&barSnapshot{
+			instrument: "A", precise: time.Hour, index: idx,
+			open: 100, high: 100, low: 100, close: 100, volume: 1, count: 1,
+		}, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case seq := <-ch:
+		if bar := seq.Value(); !bar.Index().Equal(at(10, 0)) || bar.Volume() != 2 {
+			t.Fatalf("day bar not aligned to location: %s, %d", bar.Index().In(cst), bar.Volume())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("day bar not published")
+	}
+
+	src.Release()
+	rollUp.Join()
+}
+
+func TestKBarEventTime(t *testing.T) {
+	base := time.Date(2022, 12, 9, 10, 0, 0, 0, time.UTC)
+
+	trades := []*trade{
+		{price: 100, volume: 1, ts: base.Add(10 * time.Second)},
+		{price: 101, volume: 1, ts: base.Add(30 * time.Second)},
+		{price: 102, volume: 1, ts: base.Add(62 * time.Second)},
+		{price: 103, volume: 1, ts: base.Add(58 * time.Second)},
+		{price: 104, volume: 1, ts: base.Add(70 * time.Second)},
+		{price: 105, volume: 1, ts: base.Add(185 * time.Second)},
+	}
+
+	type result struct {
+		index                  time.Time
+		volume                 int
+		open, high, low, close float64
+	}
+
+	run := func(interval time.Duration) []result {
+		stream, err := NewKBarStream(
+			context.TODO(), "EventKBar", 99, Min1BarGap,
+			WithEventTime(5*time.Second),
+		)
+		if err != nil {
+			t.Fatal("create stream failed:", err)
+		}
+
+		_, ch, err := stream.Subscribe("test", core.Quick)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results := []result{}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+
+			for seq := range ch {
+				bar := seq.Value()
+
+				results = append(results, result{
+					index:  seq.Index(),
+					volume: bar.Volume(),
+					open:   bar.Open(), high: bar.High(),
+					low: bar.Low(), close: bar.Close(),
+				})
+			}
+		}()
+
+		for _, td := range trades {
+			if err := stream.Publish(NewTradeSequence(td), -1); err != nil {
+				t.Fatal(err)
+			}
+
+			<-time.After(interval)
+		}
+
+		if err := stream.Publish(NewWaterMark(base.Add(5*Min1BarGap)), -1); err != nil {
+			t.Fatal(err)
+		}
+
+		stream.Release()
+		stream.Join()
+		<-done
+
+		return results
+	}
+
+	expect := []result{
+		{base.Add(Min1BarGap), 3, 100, 103, 100, 103},
+		{base.Add(2 * Min1BarGap), 2, 102, 104, 102, 104},
+		{base.Add(3 * Min1BarGap), 0, 104, 104, 104, 104},
+		{base.Add(4 * Min1BarGap), 1, 105, 105, 105, 105},
+	}
+
+	for _, interval := range []time.Duration{0, time.Millisecond * 10} {
+		results := run(interval)
+
+		if len(results) != len(expect) {
+			t.Fatalf("bar count mismatch: %+v", results)
+		}
+
+		for idx, v := range expect {
+			if !results[idx].index.Equal(v.index) || results[idx].volume != v.volume ||
+				results[idx].open != v.open || results[idx].high != v.high ||
+				results[idx].low != v.low || results[idx].close != v.close {
+				t.Fatalf("bar[%d] mismatch: %+v, expect %+v", idx, results[idx], v)
+			}
+		}
+	}
+}
+
+func TestWindowClosedRepush(t *testing.T) {
+	base := time.Date(2022, 12, 9, 10, 0, 0, 0, time.UTC)
+
+	stream, err := NewKBarStream(
+		context.TODO(), "RepushKBar", 99, Min1BarGap,
+		WithEventTime(0),
+	)
+	if err != nil {
+		t.Fatal("create stream failed:", err)
+	}
+
+	_, ch, err := stream.Subscribe("test", core.Quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := func() KBar {
+		select {
+		case seq := <-ch:
+			return seq.Value()
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	for _, td := range []*trade{
+		{price: 100, volume: 1, ts: base.Add(10 * time.Second)},
+		// closes first bar and must be kept in next bar
+		{price: 101, volume: 2, ts: base.Add(70 * time.Second)},
+	} {
+		if err := stream.Publish(NewTradeSequence(td), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if bar := next(); bar == nil || bar.Volume() != 1 || bar.Close() != 100 {
+		t.Fatal("first bar mismatch:", bar)
+	}
+
+	if err := stream.Publish(NewWaterMark(base.Add(2*Min1BarGap)), -1); err != nil {
+		t.Fatal(err)
+	}
+
+	if bar := next(); bar == nil || bar.Volume() != 2 || bar.Open() != 101 || bar.Close() != 101 {
+		t.Fatal("closing trade not pushed into next bar:", bar)
+	}
+
+	stream.Release()
+	stream.Join()
+}
+
+type manualClock struct {
+	tick chan time.Time
+}
+
+func (c *manualClock) After(time.Duration) <-chan time.Time {
+	return c.tick
+}
+
+func TestWaterMarkTimer(t *testing.T) {
+	base := time.Date(2022, 12, 9, 10, 0, 0, 0, time.UTC)
+	clock := manualClock{tick: make(chan time.Time)}
+
+	stream, err := NewKBarStream(
+		context.TODO(), "TimerKBar", 99, Min1BarGap,
+		WithEventTime(0), WithWaterMarkTimer(30*time.Second, &clock),
+	)
+	if err != nil {
+		t.Fatal("create stream failed:", err)
+	}
+
+	_, ch, err := stream.Subscribe("test", core.Quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := func() KBar {
+		select {
+		case seq := <-ch:
+			return seq.Value()
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	// timer before any trade has no event time
+	clock.tick <- base.Add(time.Hour)
+	if bar := next(); bar != nil {
+		t.Fatal("bar closed before trade arrived:", bar.Index())
+	}
+
+	if err := stream.Publish(NewTradeSequence(&trade{
+		price: 100, volume: 1, ts: base.Add(10 * time.Second),
+	}), -1); err != nil {
+		t.Fatal(err)
+	}
+
+	// water mark is last trade time plus idle interval,
+	// clock's time is ignored in event time mode
+	clock.tick <- base.Add(time.Hour)
+	if bar := next(); bar != nil {
+		t.Fatal("bar closed before water mark passed:", bar.Index())
+	}
+
+	clock.tick <- base
+	if bar := next(); bar == nil || bar.Volume() != 1 || !bar.Index().Equal(base.Add(Min1BarGap)) {
+		t.Fatal("idle bar not closed:", bar)
+	}
+
+	clock.tick <- base
+	if bar := next(); bar != nil {
+		t.Fatal("bar closed before water mark passed:", bar.Index())
+	}
+
+	clock.tick <- base
+	if bar := next(); bar == nil || bar.Volume() != 0 || bar.Open() != 100 || bar.Close() != 100 {
+		t.Fatal("empty bar not closed:", bar)
+	}
+
+	// trade resets idle interval
+	if err := stream.Publish(NewTradeSequence(&trade{
+		price: 101, volume: 1, ts: base.Add(2*Min1BarGap + 10*time.Second),
+	}), -1); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.tick <- base
+	if bar := next(); bar != nil {
+		t.Fatal("bar closed before water mark passed:", bar.Index())
+	}
+
+	clock.tick <- base
+	if bar := next(); bar == nil || bar.Volume() != 1 || bar.Close() != 101 {
+		t.Fatal("idle bar not closed:", bar)
+	}
+
+	stream.Release()
+	stream.Join()
+
+	processing, err := NewKBarStream(
+		context.TODO(), "TimerKBar", 99, Min1BarGap,
+		WithWaterMarkTimer(time.Second, &clock),
+	)
+	if err != nil {
+		t.Fatal("create stream failed:", err)
+	}
+	_, ch, err = processing.Subscribe("test", core.Quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.tick <- time.Now().Add(-Min1BarGap)
+	if bar := next(); bar != nil {
+		t.Fatal("bar closed before water mark passed:", bar.Index())
+	}
+
+	clock.tick <- time.Now().Add(Min1BarGap)
+	if bar := next(); bar == nil || bar.Volume() != 0 || bar.Open() != 99 || bar.High() != 99 {
+		t.Fatal("empty bar not closed:", bar)
+	}
+
+	processing.Release()
+	processing.Join()
+}
+
+func TestDerivedWaterMarkTimer(t *testing.T) {
+	base := time.Date(2022, 12, 9, 10, 0, 0, 0, time.UTC)
+	clock := manualClock{tick: make(chan time.Time)}
+
+	stream, err := NewKBarStream(
+		context.TODO(), "TimerKBar", 99, Min1BarGap,
+		WithEventTime(0), WithWaterMarkTimer(30*time.Second, &clock),
+	)
+	if err != nil {
+		t.Fatal("create stream failed:", err)
+	}
+
+	child := stream.FilterBy(func(Sequence[time.Time, Trade]) bool { return true })
+	if child == nil {
+		t.Fatal("create filtered stream failed")
+	}
+
+	_, parentCh, err := stream.Subscribe("parent", core.Quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, childCh, err := child.Subscribe("child", core.Quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := func(ch <-chan Sequence[time.Time, KBar]) KBar {
+		select {
+		case seq := <-ch:
+			return seq.Value()
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	if err := stream.Publish(NewTradeSequence(&trade{
+		price: 100, volume: 1, ts: base.Add(10 * time.Second),
+	}), -1); err != nil {
+		t.Fatal(err)
+	}
+
+	// derived stream receives converted water mark, not clock's time
+	clock.tick <- base.Add(time.Hour)
+	if bar := next(parentCh); bar != nil {
+		t.Fatal("parent bar closed before water mark passed:", bar.Index())
+	}
+	if bar := next(childCh); bar != nil {
+		t.Fatal("child bar closed before water mark passed:", bar.Index())
+	}
+
+	clock.tick <- base.Add(time.Hour)
+	if bar := next(parentCh); bar == nil || bar.Volume() != 1 || !bar.Index().Equal(base.Add(Min1BarGap)) {
+		t.Fatal("parent idle bar not closed:", bar)
+	}
+	if bar := next(childCh); bar == nil || bar.Volume() != 1 || !bar.Index().Equal(base.Add(Min1BarGap)) {
+		t.Fatal("child idle bar not closed:", bar)
+	}
+
+	stream.Release()
+	stream.Join()
+}
+
+func TestLatePolicy(t *testing.T) {
+	base := time.Date(2022, 12, 9, 10, 0, 0, 0, time.UTC)
+
+	type result struct {
+		index      time.Time
+		volume     int
+		low        float64
+		open       float64
+		close      float64
+		correction bool
+	}
+
+	lateOutput := channel.NewMemoChannel[Sequence[time.Time, Trade]](
+		context.TODO(), "late", 10,
+	)
+	defer lateOutput.Release()
+
+	_, lateCh, err := lateOutput.Subscribe("late", core.Quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name   string
+		opts   []KBarOption
+		trades []float64
+		expect []result
+		late   int
+		// retraced is closed bar in window cache after late trade
+		retraced *result
+	}{
+		{
+			name:   "retrace",
+			trades: []float64{10, 70},
+			expect: []result{
+				{base.Add(Min1BarGap), 1, 100, 100, 100, false},
+				{base.Add(2 * Min1BarGap), 1, 100, 100, 100, false},
+			},
+			retraced: &result{base.Add(Min1BarGap), 2, 90, 100, 90, false},
+		},
+		{
+			name:   "drop",
+			opts:   []KBarOption{WithLatePolicy(LateDrop)},
+			trades: []float64{10, 70},
+			expect: []result{
+				{base.Add(Min1BarGap), 1, 100, 100, 100, false},
+				{base.Add(2 * Min1BarGap), 1, 100, 100, 100, false},
+			},
+			retraced: &result{base.Add(Min1BarGap), 1, 100, 100, 100, false},
+		},
+		{
+			name:   "side",
+			opts:   []KBarOption{WithLateOutput(lateOutput)},
+			trades: []float64{10, 70},
+			expect: []result{
+				{base.Add(Min1BarGap), 1, 100, 100, 100, false},
+				{base.Add(2 * Min1BarGap), 1, 100, 100, 100, false},
+			},
+			late: 1,
+		},
+		{
+			name:   "correct",
+			opts:   []KBarOption{WithLatePolicy(LateCorrect)},
+			trades: []float64{10, 70},
+			expect: []result{
+				{base.Add(Min1BarGap), 1, 100, 100, 100, false},
+				{base.Add(Min1BarGap), 2, 90, 100, 90, true},
+				{base.Add(2 * Min1BarGap), 1, 100, 100, 100, false},
+			},
+		},
+		{
+			name:   "correct order",
+			opts:   []KBarOption{WithLatePolicy(LateCorrect)},
+			trades: []float64{10, 30, 70},
+			expect: []result{
+				{base.Add(Min1BarGap), 2, 100, 100, 100, false},
+				{base.Add(Min1BarGap), 3, 90, 100, 100, true},
+				{base.Add(2 * Min1BarGap), 1, 100, 100, 100, false},
+			},
+		},
+		{
+			name:   "correct open",
+			opts:   []KBarOption{WithLatePolicy(LateCorrect)},
+			trades: []float64{30, 70},
+			expect: []result{
+				{base.Add(Min1BarGap), 1, 100, 100, 100, false},
+				{base.Add(Min1BarGap), 2, 90, 90, 100, true},
+				{base.Add(2 * Min1BarGap), 1, 100, 100, 100, false},
+			},
+		},
+		{
+			name:   "retain",
+			opts:   []KBarOption{WithLatePolicy(LateCorrect), WithMaxRetrace(1)},
+			trades: []float64{10, 70, 130},
+			expect: []result{
+				{base.Add(Min1BarGap), 1, 100, 100, 100, false},
+				{base.Add(2 * Min1BarGap), 1, 100, 100, 100, false},
+				{base.Add(3 * Min1BarGap), 1, 100, 100, 100, false},
+			},
+		},
+	} {
+		stream, err := NewKBarStream(
+			context.TODO(), "LateKBar", 99, Min1BarGap,
+			append(c.opts, WithEventTime(0))...,
+		)
+		if err != nil {
+			t.Fatal("create stream failed:", err)
+		}
+
+		_, ch, err := stream.Subscribe("test", core.Quick)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results := []result{}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+
+			for seq := range ch {
+				bar := seq.Value()
+				results = append(results, result{
+					bar.Index(), bar.Volume(), bar.Low(),
+					bar.Open(), bar.Close(), bar.IsCorrection(),
+				})
+			}
+		}()
+
+		for _, offset := range c.trades {
+			if err := stream.Publish(NewTradeSequence(&trade{
+				price: 100, volume: 1,
+				ts: base.Add(time.Duration(offset) * time.Second),
+			}), -1); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := stream.Publish(NewTradeSequence(&trade{
+			price: 90, volume: 1, ts: base.Add(20 * time.Second),
+		}), -1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := stream.Publish(NewWaterMark(base.Add(time.Hour)), -1); err != nil {
+			t.Fatal(err)
+		}
+
+		stream.Release()
+		stream.Join()
+		<-done
+
+		if len(results) != len(c.expect) {
+			t.Fatalf("%s: bar count mismatch: %+v", c.name, results)
+		}
+
+		for idx, v := range c.expect {
+			if results[idx] != v {
+				t.Fatalf("%s: bar[%d] mismatch: %+v, expect %+v", c.name, idx, results[idx], v)
+			}
+		}
+
+		for n := 1; n <= len(c.expect); n++ {
+			win := stream.PreWindow(n)
+			if win == nil {
+				break
+			}
+
+			bar := win.(*KBarWindow)
+			if !bar.Index().Equal(base.Add(Min1BarGap)) {
+				continue
+			}
+
+			v := result{
+				bar.Index(), bar.Volume(), bar.Low(),
+				bar.Open(), bar.Close(), bar.IsCorrection(),
+			}
+			if c.retraced != nil && v != *c.retraced {
+				t.Fatalf("%s: retraced bar mismatch: %+v, expect %+v", c.name, v, *c.retraced)
+			}
+		}
+
+		for idx := 0; idx < c.late; idx++ {
+			select {
+			case seq := <-lateCh:
+				if seq.Value().Price() != 90 {
+					t.Fatalf("%s: late trade mismatch: %v", c.name, seq.Value().Price())
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s: late trade not published", c.name)
+			}
+		}
+	}
+}
+
+func TestKBarRetainTail(t *testing.T) {
+	bar := NewKBarWindow(nil, 99, Min1BarGap)
+	bar.retain = 1
+
+	if err := bar.Push(NewTradeSequence(&trade{
+		price: 100, volume: 1, ts: bar.Index().Add(-time.Second),
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	tail := bar.NextWindow().(*KBarWindow)
+	curr := tail.NextWindow().(*KBarWindow)
+
+	if tail.preBar != nil {
+		t.Fatal("chain not cut at retain")
+	}
+
+	for _, v := range []*KBarWindow{tail, curr} {
+		if v.Open() != 100 || v.Close() != 100 {
+			t.Fatalf("empty bar not priced by pre close: %v, %v", v.Open(), v.Close())
+		}
+	}
+}
+
+func TestWindows(t *testing.T) {
+	base := time.Date(2022, 12, 9, 10, 0, 0, 0, time.UTC)
+
+	if _, err := NewSlidingWindow[int, int](time.Second, time.Minute); !errors.Is(err, ErrInvalidWindow) {
+		t.Fatal("invalid sliding window not checked:", err)
+	}
+	if _, err := NewCountWindow[time.Time, int, int](0); !errors.Is(err, ErrInvalidWindow) {
+		t.Fatal("invalid count window not checked:", err)
+	}
+	if _, err := NewSessionWindow[int, int](0); !errors.Is(err, ErrInvalidWindow) {
+		t.Fatal("invalid session window not checked:", err)
+	}
+
+	run := func(win Window[time.Time, int, int], inputs []*sequence[int]) []int {
+		stream, err := NewMemoStream[time.Time, int, int, string](
+			context.TODO(), "WindowStream", win,
+			func(in Window[time.Time, int, int]) (Sequence[time.Time, int], error) {
+				var result int
+
+				for _, v := range in.Values() {
+					result += v
+				}
+
+				return &sequence[int]{data: result, ts: time.Now()}, nil
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, ch, err := stream.Subscribe("test", core.Quick)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results := []int{}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+
+			for seq := range ch {
+				results = append(results, seq.Value())
+			}
+		}()
+
+		for _, v := range inputs {
+			if err := stream.Publish(v, -1); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stream.Release()
+		stream.Join()
+		<-done
+
+		return results
+	}
+
+	at := func(sec int, v int) *sequence[int] {
+		return &sequence[int]{data: v, ts: base.Add(time.Duration(sec) * time.Second)}
+	}
+	mark := func(sec int) *sequence[int] {
+		return &sequence[int]{ts: base.Add(time.Duration(sec) * time.Second), mark: true}
+	}
+
+	tumbling, _ := NewTumblingWindow[int, int](10 * time.Second)
+	sliding, _ := NewSlidingWindow[int, int](10*time.Second, 5*time.Second)
+	count, _ := NewCountWindow[time.Time, int, int](2)
+	session, _ := NewSessionWindow[int, int](5 * time.Second)
+	boundary, _ := NewSessionWindow[int, int](5 * time.Second)
+
+	for _, c := range []struct {
+		name   string
+		win    Window[time.Time, int, int]
+		inputs []*sequence[int]
+		expect []int
+	}{
+		{
+			"tumbling", tumbling,
+			[]*sequence[int]{at(1, 1), at(5, 2), at(12, 3), at(35, 4), mark(60)},
+			[]int{3, 3, 0, 4},
+		},
+		{
+			"sliding", sliding,
+			[]*sequence[int]{at(1, 1), at(6, 2), at(12, 3), mark(30)},
+			[]int{1, 3, 5},
+		},
+		{
+			"count", count,
+			[]*sequence[int]{at(1, 1), at(2, 2), at(3, 3), at(4, 4), at(5, 5), mark(6)},
+			[]int{3, 7, 5},
+		},
+		{
+			"session", session,
+			[]*sequence[int]{at(1, 1), at(3, 2), at(10, 3), at(12, 4), mark(20)},
+			[]int{3, 7},
+		},
+		{
+			// sequence or water mark exactly gap after last closes session
+			"session boundary", boundary,
+			[]*sequence[int]{at(1, 1), at(6, 2), at(8, 3), mark(13), at(14, 4), mark(30)},
+			[]int{1, 5, 4},
+		},
+	} {
+		results := run(c.win, c.inputs)
+
+		if len(results) != len(c.expect) {
+			t.Fatalf("%s: result mismatch: %v, expect %v", c.name, results, c.expect)
+		}
+
+		for idx, v := range c.expect {
+			if results[idx] != v {
+				t.Fatalf("%s: result mismatch: %v, expect %v", c.name, results, c.expect)
+			}
+		}
+	}
+}
+
+func TestCountWindowFilled(t *testing.T) {
+	count, _ := NewCountWindow[time.Time, int, int](2)
+
+	stream, err := NewMemoStream[time.Time, int, int, string](
+		context.TODO(), "CountStream", count,
+		func(in Window[time.Time, int, int]) (Sequence[time.Time, int], error) {
+			var result int
+
+			for _, v := range in.Values() {
+				result += v
+			}
+
+			return &sequence[int]{data: result, ts: time.Now()}, nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ch, err := stream.Subscribe("test", core.Quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := func() (int, bool) {
+		select {
+		case seq := <-ch:
+			return seq.Value(), true
+		case <-time.After(time.Millisecond * 100):
+			return 0, false
+		}
+	}
+
+	for v := 1; v <= 3; v++ {
+		if err := stream.Publish(&sequence[int]{data: v, ts: time.Now()}, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// filled window publishes without waiting for next sequence,
+	// and the sequence which filled window is not pushed again
+	if v, ok := next(); !ok || v != 3 {
+		t.Fatal("filled window not published:", v, ok)
+	}
+
+	if v, ok := next(); ok {
+		t.Fatal("unfilled window published:", v)
+	}
+
+	if err := stream.Publish(&sequence[int]{data: 4, ts: time.Now()}, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := next(); !ok || v != 7 {
+		t.Fatal("filled window not published:", v, ok)
+	}
+
+	stream.Release()
+	stream.Join()
+}
+
+func TestWindowHistory(t *testing.T) {
+	newStream := func(opts ...StreamOption) *MemoStream[time.Time, int, int, string] {
+		stream, err := NewMemoStream[time.Time, int, int, string](
+			context.TODO(), "HistoryStream", nil,
+			func(in Window[time.Time, int, int]) (Sequence[time.Time, int], error) {
+				var result int
+
+				for _, v := range in.Values() {
+					result += v
+				}
+
+				return &sequence[int]{data: result, ts: time.Now()}, nil
+			},
+			opts...,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return stream
+	}
+
+	run := func(stream *MemoStream[time.Time, int, int, string], windows int) {
+		subID, ch, err := stream.Subscribe("test", core.Quick)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.UnSubscribe(subID)
+
+		done := make(chan struct{})
+		wg := sync.WaitGroup{}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+
+			for range ch {
+			}
+		}()
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					if win := stream.PreWindow(1); win != nil {
+						win.Values()
+					}
+					stream.CurrWindow()
+				}
+			}
+		}()
+
+		for idx := 0; idx < windows; idx++ {
+			if err := stream.Publish(&sequence[int]{data: idx, ts: time.Now()}, -1); err != nil {
+				t.Fatal(err)
+			}
+			if err := stream.Publish(&sequence[int]{ts: time.Now(), mark: true}, -1); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stream.Release()
+		stream.Join()
+		close(done)
+		wg.Wait()
+	}
+
+	stream := newStream(WithHistoryCount(3))
+	run(stream, 10)
+
+	for n := 1; n <= 3; n++ {
+		win := stream.PreWindow(n)
+		if win == nil {
+			t.Fatalf("window %d missing", n)
+		}
+
+		if values := win.Values(); len(values) != 1 || values[0] != 10-n {
+			t.Fatalf("window %d mismatch: %v", n, values)
+		}
+	}
+
+	if win := stream.PreWindow(4); win != nil {
+		t.Fatal("window not evicted:", win.Values())
+	}
+
+	if curr := stream.CurrWindow(); curr == nil ||
+		!slices.Equal(stream.PreWindow(0).Values(), curr.Values()) {
+		t.Fatal("current window mismatch")
+	}
+
+	if stream.CurrWindow().PreWindow() != nil {
+		t.Fatal("window snapshot not detached")
+	}
+
+	chain := 0
+	for win := stream.currWindow.PreWindow(); win != nil; win = win.PreWindow() {
+		chain++
+	}
+	if chain > 4 {
+		t.Fatal("window chain not cut:", chain)
+	}
+
+	stream = newStream(WithHistoryAge(time.Nanosecond))
+	run(stream, 5)
+
+	if stream.PreWindow(1) == nil || stream.PreWindow(2) != nil {
+		t.Fatal("window not evicted by age")
+	}
+}
+
+func TestKBarWindowSnapshot(t *testing.T) {
+	base := time.Date(2022, 12, 9, 10, 0, 0, 0, time.UTC)
+
+	stream, err := NewKBarStream(
+		context.TODO(), "SnapshotKBar", 99, Min1BarGap,
+		WithEventTime(0), WithLatePolicy(LateCorrect),
+	)
+	if err != nil {
+		t.Fatal("create stream failed:", err)
+	}
+
+	_, ch, err := stream.Subscribe("test", core.Quick)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+
+		for range ch {
+		}
+	}()
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if win, ok := stream.PreWindow(1).(*KBarWindow); ok {
+					win.Values()
+					win.Close()
+					win.Volume()
+				}
+				if win, ok := stream.CurrWindow().(*KBarWindow); ok {
+					win.Values()
+					win.Close()
+				}
+			}
+		}
+	}()
+
+	for idx := 0; idx < 100; idx++ {
+		ts := base.Add(time.Duration(idx) * Min1BarGap)
+
+		for _, offset := range []time.Duration{10, 30} {
+			if err := stream.Publish(NewTradeSequence(&trade{
+				price: float64(100 + idx), volume: 1,
+				ts: ts.Add(offset * time.Second),
+			}), -1); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := stream.Publish(NewWaterMark(ts.Add(Min1BarGap)), -1); err != nil {
+			t.Fatal(err)
+		}
+
+		// late trade corrects previous bar
+		if err := stream.Publish(NewTradeSequence(&trade{
+			price: 90, volume: 1, ts: ts.Add(20 * time.Second),
+		}), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stream.Release()
+	stream.Join()
+	close(done)
+	wg.Wait()
+
+	win, ok := stream.PreWindow(1).(*KBarWindow)
+	if !ok || win.Volume() != 3 || win.Low() != 90 {
+		t.Fatal("corrected window mismatch:", win)
+	}
+}
@@ -0,0 +1,274 @@
+package stream
+
+import (
+	"time"
+
+	"github.com/frozenpine/msgqueue/core"
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidWindow = errors.New("invalid window")
+
+type seriesWindow[
+	IDX comparable,
+	IV any,
+] struct {
+	sequence []Sequence[IDX, IV]
+}
+
+func (win *seriesWindow[IDX, IV]) Indexs() []IDX {
+	index := make([]IDX, len(win.sequence))
+
+	for idx, v := range win.sequence {
+		index[idx] = v.Index()
+	}
+
+	return index
+}
+
+func (win *seriesWindow[IDX, IV]) Values() []IV {
+	values := make([]IV, len(win.sequence))
+
+	for idx, v := range win.sequence {
+		values[idx] = v.Value()
+	}
+
+	return values
+}
+
+func (win *seriesWindow[IDX, IV]) Series() []Sequence[IDX, IV] {
+	return win.sequence
+}
+
+// TimeWindow is time window (End-Size, End] aligned to hop,
+// window is anchored by first sequence's index,
+// sequence after window's end or water mark passed end closes window
+type TimeWindow[IV, OV any] struct {
+	seriesWindow[time.Time, IV]
+
+	pre       *TimeWindow[IV, OV]
+	size, hop time.Duration
+	end       time.Time
+}
+
+// NewTumblingWindow create non-overlapping time window with size
+func NewTumblingWindow[IV, OV any](size time.Duration) (*TimeWindow[IV, OV], error) {
+	return NewSlidingWindow[IV, OV](size, size)
+}
+
+// NewSlidingWindow create time window with size, slides every hop,
+// sequence may belong to multiple windows, hop must not exceed size
+func NewSlidingWindow[IV, OV any](size, hop time.Duration) (*TimeWindow[IV, OV], error) {
+	if size <= 0 || hop <= 0 || hop > size {
+		return nil, errors.Wrapf(
+			ErrInvalidWindow, "size %s, hop %s", size, hop,
+		)
+	}
+
+	return &TimeWindow[IV, OV]{size: size, hop: hop}, nil
+}
+
+func (win *TimeWindow[IV, OV]) Start() time.Time {
+	return win.end.Add(-win.size)
+}
+
+func (win *TimeWindow[IV, OV]) End() time.Time {
+	return win.end
+}
+
+func (win *TimeWindow[IV, OV]) Push(seq Sequence[time.Time, IV]) error {
+	if seq.IsWaterMark() {
+		if win.end.IsZero() || core.TimeCompare(seq.Index(), win.end) < 0 {
+			return nil
+		}
+
+		return errors.Wrap(ErrWindowClosed, "water mark passed window")
+	}
+
+	ts := seq.Index()
+
+	if win.end.IsZero() {
+		win.end = barIndex(ts, win.hop)
+	}
+
+	if core.TimeCompare(ts, win.end) > 0 {
+		return errors.Wrap(ErrWindowClosed, "sequence after window")
+	}
+
+	if core.TimeCompare(ts, win.Start()) <= 0 {
+		return errors.Wrap(ErrHistorySequence, "sequence before window")
+	}
+
+	win.sequence = append(win.sequence, seq)
+
+	return nil
+}
+
+func (win *TimeWindow[IV, OV]) PreWindow() Window[time.Time, IV, OV] {
+	if win.pre == nil {
+		return nil
+	}
+
+	return win.pre
+}
+
+func (win *TimeWindow[IV, OV]) NextWindow() Window[time.Time, IV, OV] {
+	next := TimeWindow[IV, OV]{size: win.size, hop: win.hop}
+
+	if win.end.IsZero() {
+		return &next
+	}
+
+	next.pre = win
+	next.end = win.end.Add(win.hop)
+
+	// overlapped sequences in sliding window
+	start := next.Start()
+	for _, seq := range win.sequence {
+		if core.TimeCompare(seq.Index(), start) > 0 {
+			next.sequence = append(next.sequence, seq)
+		}
+	}
+
+	return &next
+}
+
+// CountWindow closes when count sequences pushed,
+// water mark flushes window if not empty
+type CountWindow[
+	IDX comparable,
+	IV, OV any,
+] struct {
+	seriesWindow[IDX, IV]
+
+	pre   *CountWindow[IDX, IV, OV]
+	count int
+}
+
+func NewCountWindow[
+	IDX comparable,
+	IV, OV any,
+](count int) (*CountWindow[IDX, IV, OV], error) {
+	if count <= 0 {
+		return nil, errors.Wrapf(ErrInvalidWindow, "count %d", count)
+	}
+
+	return &CountWindow[IDX, IV, OV]{count: count}, nil
+}
+
+func (win *CountWindow[IDX, IV, OV]) Push(seq Sequence[IDX, IV]) error {
+	if seq.IsWaterMark() {
+		if len(win.sequence) == 0 {
+			return nil
+		}
+
+		return errors.Wrap(ErrWindowClosed, "water mark arrive")
+	}
+
+	win.sequence = append(win.sequence, seq)
+
+	if len(win.sequence) >= win.count {
+		return errors.Wrap(ErrWindowFilled, "window count reached")
+	}
+
+	return nil
+}
+
+func (win *CountWindow[IDX, IV, OV]) PreWindow() Window[IDX, IV, OV] {
+	if win.pre == nil {
+		return nil
+	}
+
+	return win.pre
+}
+
+func (win *CountWindow[IDX, IV, OV]) NextWindow() Window[IDX, IV, OV] {
+	return &CountWindow[IDX, IV, OV]{pre: win, count: win.count}
+}
+
+// SessionWindow groups sequences with index gap less than inactivity gap,
+// sequence or water mark at or after last index + gap closes window
+type SessionWindow[IV, OV any] struct {
+	seriesWindow[time.Time, IV]
+
+	pre         *SessionWindow[IV, OV]
+	gap         time.Duration
+	first, last time.Time
+}
+
+func NewSessionWindow[IV, OV any](gap time.Duration) (*SessionWindow[IV, OV], error) {
+	if gap <= 0 {
+		return nil, errors.Wrapf(ErrInvalidWindow, "gap %s", gap)
+	}
+
+	return &SessionWindow[IV, OV]{gap: gap}, nil
+}
+
+func (win *SessionWindow[IV, OV]) Start() time.Time {
+	return win.first
+}
+
+func (win *SessionWindow[IV, OV]) End() time.Time {
+	return win.last
+}
+
+func (win *SessionWindow[IV, OV]) Push(seq Sequence[time.Time, IV]) error {
+	if seq.IsWaterMark() {
+		if len(win.sequence) == 0 ||
+			core.TimeCompare(seq.Index(), win.last.Add(win.gap)) < 0 {
+			return nil
+		}
+
+		return errors.Wrap(ErrWindowClosed, "session inactive")
+	}
+
+	ts := seq.Index()
+
+	if len(win.sequence) == 0 {
+		if win.pre != nil && core.TimeCompare(ts, win.pre.last.Add(win.gap)) < 0 {
+			return errors.Wrap(ErrHistorySequence, "sequence in closed session")
+		}
+
+		win.first, win.last = ts, ts
+	} else {
+		if core.TimeCompare(ts, win.last.Add(win.gap)) >= 0 {
+			return errors.Wrap(ErrWindowClosed, "session inactive")
+		}
+
+		if core.TimeCompare(ts, win.first.Add(-win.gap)) <= 0 {
+			return errors.Wrap(ErrHistorySequence, "sequence before session")
+		}
+
+		if ts.Before(win.first) {
+			win.first = ts
+		}
+
+		if ts.After(win.last) {
+			win.last = ts
+		}
+	}
+
+	win.sequence = append(win.sequence, seq)
+
+	return nil
+}
+
+func (win *SessionWindow[IV, OV]) PreWindow() Window[time.Time, IV, OV] {
+	if win.pre == nil {
+		return nil
+	}
+
+	return win.pre
+}
+
+func (win *SessionWindow[IV, OV]) NextWindow() Window[time.Time, IV, OV] {
+	next := SessionWindow[IV, OV]{gap: win.gap}
+
+	if len(win.sequence) > 0 {
+		next.pre = win
+	} else {
+		next.pre = win.pre
+	}
+
+	return &next
+}